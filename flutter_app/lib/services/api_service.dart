@@ -1,14 +1,21 @@
 import 'dart:convert';
+import 'dart:math';
 import 'package:http/http.dart' as http;
 import 'storage_service.dart';
 
 class ApiService {
   static const String baseUrl = 'http://localhost:1323/api';
   
-  static Future<Map<String, String>> _getHeaders({bool includeAuth = true}) async {
+  static Future<Map<String, String>> _getHeaders({
+    bool includeAuth = true,
+    String? idempotencyKey,
+  }) async {
     final headers = {
       'Content-Type': 'application/json',
     };
+    if (idempotencyKey != null) {
+      headers['Idempotency-Key'] = idempotencyKey;
+    }
     
     if (includeAuth) {
       final token = await StorageService.getToken();
@@ -33,6 +40,21 @@ class ApiService {
     }
   }
 
+  // A fresh Idempotency-Key. Pass the same key when retrying a request
+  // so the server applies it only once.
+  static String newIdempotencyKey() {
+    final random = Random.secure();
+    return List.generate(16, (_) => random.nextInt(256).toRadixString(16).padLeft(2, '0')).join();
+  }
+
+  // Listings return one page as {"items": [...], "next_cursor": ...}
+  static List<dynamic> _items(dynamic result) {
+    if (result is Map && result['items'] is List) {
+      return result['items'] as List<dynamic>;
+    }
+    return result is List ? result : [];
+  }
+
   // Authentication endpoints
   static Future<Map<String, dynamic>> register({
     required String name,
@@ -78,7 +100,7 @@ class ApiService {
     );
     
     final result = await _handleResponse(response);
-    return result is List ? result : [];
+    return _items(result);
   }
 
   static Future<Map<String, dynamic>> createGoal({
@@ -130,16 +152,334 @@ class ApiService {
     await _handleResponse(response);
   }
 
+  // Deleted goals stay in the trash for a while and can be restored
+  static Future<List<dynamic>> getTrash() async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/goals/trash'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  static Future<Map<String, dynamic>> restoreGoal(int id) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/goals/trash/$id/restore'),
+      headers: await _getHeaders(),
+    );
+
+    return await _handleResponse(response);
+  }
+
+  // until is "deadline" or "target". While the lock is in force, a
+  // "remove" returns a withdrawal request that waits coolingOffHours.
+  static Future<Map<String, dynamic>> setGoalLock(
+    int goalId, {
+    required String until,
+    required int coolingOffHours,
+    int? penaltyBps,
+    int? penaltyGoalId,
+  }) async {
+    final response = await http.put(
+      Uri.parse('$baseUrl/goals/$goalId/lock'),
+      headers: await _getHeaders(),
+      body: jsonEncode({
+        'until': until,
+        'cooling_off_hours': coolingOffHours,
+        'penalty_bps': penaltyBps ?? 0,
+        'penalty_goal_id': penaltyGoalId,
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<Map<String, dynamic>> removeGoalLock(int goalId) async {
+    final response = await http.delete(
+      Uri.parse('$baseUrl/goals/$goalId/lock'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // Goal sharing endpoints
+  static Future<List<dynamic>> getMembers(int goalId) async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/goals/$goalId/members'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  // role is owner, contributor or viewer
+  static Future<Map<String, dynamic>> inviteMember({
+    required int goalId,
+    required String email,
+    required String role,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/goals/$goalId/invitations'),
+      headers: await _getHeaders(),
+      body: jsonEncode({'email': email, 'role': role}),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<List<dynamic>> getInvitations() async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/invitations'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  static Future<void> answerInvitation(int id, {required bool accept}) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/invitations/$id/${accept ? 'accept' : 'decline'}'),
+      headers: await _getHeaders(),
+    );
+
+    await _handleResponse(response);
+  }
+
+  // Goal rule endpoints
+  static Future<List<dynamic>> getRules(int goalId) async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/goals/$goalId/rules'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  // kind is "match" or "interest"; rateBps is in hundredths of a percent
+  static Future<Map<String, dynamic>> createRule(
+    int goalId, {
+    required String kind,
+    required int rateBps,
+    double? monthlyCap,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/goals/$goalId/rules'),
+      headers: await _getHeaders(),
+      body: jsonEncode({
+        'kind': kind,
+        'rate_bps': rateBps,
+        'monthly_cap': monthlyCap,
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<void> deleteRule(int goalId, int ruleId) async {
+    final response = await http.delete(
+      Uri.parse('$baseUrl/goals/$goalId/rules/$ruleId'),
+      headers: await _getHeaders(),
+    );
+
+    await _handleResponse(response);
+  }
+
+  // Budget endpoints
+  static Future<List<dynamic>> getCategories() async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/categories'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  static Future<Map<String, dynamic>> createCategory(String name) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/categories'),
+      headers: await _getHeaders(),
+      body: jsonEncode({'name': name}),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // month is YYYY-MM and defaults to the current month
+  static Future<List<dynamic>> getEntries({String? month}) async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/entries').replace(queryParameters: {
+        if (month != null) 'month': month,
+      }),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  // type is "income" or "expense"; currency defaults to the base currency
+  static Future<Map<String, dynamic>> createEntry({
+    required String type,
+    required double amount,
+    String? currency,
+    int? categoryId,
+    String? description,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/entries'),
+      headers: await _getHeaders(),
+      body: jsonEncode({
+        'type': type,
+        'amount': amount,
+        if (currency != null) 'currency': currency,
+        'category_id': categoryId,
+        'description': description ?? '',
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<Map<String, dynamic>> getBudgetReport({String? month}) async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/budgets').replace(queryParameters: {
+        if (month != null) 'month': month,
+      }),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // amount is in the base currency; rollover is "none", "unspent" or "all"
+  static Future<Map<String, dynamic>> setBudget(
+    int categoryId, {
+    required double amount,
+    String rollover = 'none',
+  }) async {
+    final response = await http.put(
+      Uri.parse('$baseUrl/budgets/$categoryId'),
+      headers: await _getHeaders(),
+      body: jsonEncode({'amount': amount, 'rollover': rollover}),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // amount is in the goal's currency
+  static Future<Map<String, dynamic>> moveSurplus({
+    required int goalId,
+    required double amount,
+    String? description,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/budgets/surplus'),
+      headers: await _getHeaders(),
+      body: jsonEncode({
+        'goal_id': goalId,
+        'amount': amount,
+        'description': description ?? '',
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // Child account endpoints
+  static Future<Map<String, dynamic>> createChild({
+    required String name,
+    required String email,
+    required String password,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/children'),
+      headers: await _getHeaders(),
+      body: jsonEncode({'name': name, 'email': email, 'password': password}),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<List<dynamic>> getChildren() async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/children'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  // A null limit is no limit
+  static Future<Map<String, dynamic>> setChildLimits(
+    int childId, {
+    double? maxWithdrawal,
+    double? monthlyWithdrawals,
+  }) async {
+    final response = await http.put(
+      Uri.parse('$baseUrl/children/$childId/limits'),
+      headers: await _getHeaders(),
+      body: jsonEncode({
+        'max_withdrawal': maxWithdrawal,
+        'monthly_withdrawals': monthlyWithdrawals,
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<List<dynamic>> getWithdrawalRequests({String? status}) async {
+    final query = status != null ? '?status=$status' : '';
+    final response = await http.get(
+      Uri.parse('$baseUrl/withdrawal-requests$query'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return _items(result);
+  }
+
+  static Future<Map<String, dynamic>> decideWithdrawal(int id, {required bool approve, String? reason}) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/withdrawal-requests/$id/${approve ? 'approve' : 'reject'}'),
+      headers: await _getHeaders(idempotencyKey: newIdempotencyKey()),
+      body: approve ? null : jsonEncode({'reason': reason ?? ''}),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
   // Transactions endpoints
+  // For a child account, or from a locked goal, a "remove" returns a
+  // pending withdrawal request instead of a transaction.
   static Future<Map<String, dynamic>> createTransaction({
     required int goalId,
     required double amount,
     required String type,
     String? description,
+    String? idempotencyKey,
   }) async {
     final response = await http.post(
       Uri.parse('$baseUrl/transactions'),
-      headers: await _getHeaders(),
+      headers: await _getHeaders(idempotencyKey: idempotencyKey ?? newIdempotencyKey()),
       body: jsonEncode({
         'goal_id': goalId,
         'amount': amount,
@@ -159,7 +499,7 @@ class ApiService {
     );
     
     final result = await _handleResponse(response);
-    return result is List ? result : [];
+    return _items(result);
   }
 
   static Future<List<dynamic>> getTransactionsByGoal(int goalId) async {
@@ -169,7 +509,71 @@ class ApiService {
     );
     
     final result = await _handleResponse(response);
-    return result is List ? result : [];
+    return _items(result);
+  }
+
+  // Corrects a transaction; the old values stay in its history
+  static Future<Map<String, dynamic>> amendTransaction({
+    required int id,
+    double? amount,
+    String? description,
+  }) async {
+    final body = <String, dynamic>{};
+    if (amount != null) body['amount'] = amount;
+    if (description != null) body['description'] = description;
+
+    final response = await http.put(
+      Uri.parse('$baseUrl/transactions/$id'),
+      headers: await _getHeaders(),
+      body: jsonEncode(body),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // Cancels a transaction with a reversal entry
+  static Future<Map<String, dynamic>> voidTransaction(int id, {String? idempotencyKey}) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/transactions/$id/void'),
+      headers: await _getHeaders(idempotencyKey: idempotencyKey ?? newIdempotencyKey()),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  static Future<Map<String, dynamic>> getTransactionHistory(int id) async {
+    final response = await http.get(
+      Uri.parse('$baseUrl/transactions/$id/history'),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
+  }
+
+  // Moves money between two goals; amount is in the source goal's currency
+  static Future<Map<String, dynamic>> createTransfer({
+    required int fromGoalId,
+    required int toGoalId,
+    required double amount,
+    String? description,
+    String? idempotencyKey,
+  }) async {
+    final response = await http.post(
+      Uri.parse('$baseUrl/transfers'),
+      headers: await _getHeaders(idempotencyKey: idempotencyKey ?? newIdempotencyKey()),
+      body: jsonEncode({
+        'from_goal_id': fromGoalId,
+        'to_goal_id': toGoalId,
+        'amount': amount,
+        if (description != null) 'description': description,
+      }),
+    );
+
+    final result = await _handleResponse(response);
+    return result as Map<String, dynamic>;
   }
 
   // Dashboard endpoint
@@ -194,4 +598,28 @@ class ApiService {
       'goal_progress': [],
     };
   }
+
+  // Savings over time for charts. granularity is day, week or month; from
+  // and to are dates (YYYY-MM-DD) and default to a recent range.
+  static Future<Map<String, dynamic>> getHistory({
+    String granularity = 'day',
+    String? from,
+    String? to,
+  }) async {
+    final query = {
+      'granularity': granularity,
+      if (from != null) 'from': from,
+      if (to != null) 'to': to,
+    };
+    final response = await http.get(
+      Uri.parse('$baseUrl/stats/history').replace(queryParameters: query),
+      headers: await _getHeaders(),
+    );
+
+    final result = await _handleResponse(response);
+    if (result is Map<String, dynamic>) {
+      return result;
+    }
+    return {'total': [], 'goals': []};
+  }
 }
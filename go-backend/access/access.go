@@ -0,0 +1,158 @@
+// Package access decides what each member of a goal may do with it.
+// Handlers ask it before touching a goal or its transactions instead of
+// comparing user IDs themselves.
+package access
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+var (
+	ErrGoalNotFound = errors.New("goal not found")
+	ErrForbidden    = errors.New("access denied")
+	// ErrApprovalRequired means the user is a child account that may only
+	// take money out of the goal with a parent's approval.
+	ErrApprovalRequired = errors.New("withdrawal needs a parent's approval")
+	// ErrGoalLocked means the goal's lock is in force, so money may only
+	// leave it through a withdrawal request.
+	ErrGoalLocked = errors.New("goal is locked")
+)
+
+// Permission is something a member may be allowed to do with a goal.
+type Permission int
+
+const (
+	// View the goal, its transactions, schedules and members
+	View Permission = iota
+	// Add money, by hand, by import or with schedules of one's own
+	Contribute
+	// Take money out, including transfers to another goal
+	Withdraw
+	// Edit, delete and restore the goal, manage its members and
+	// invitations, and change what other members recorded
+	Manage
+)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleOwner:       {View, Contribute, Withdraw, Manage},
+	models.RoleContributor: {View, Contribute},
+	models.RoleViewer:      {View},
+}
+
+// Allows reports whether a member with the role has the permission.
+func Allows(role string, p Permission) bool {
+	for _, allowed := range rolePermissions[role] {
+		if allowed == p {
+			return true
+		}
+	}
+	return false
+}
+
+type GoalStore interface {
+	GetByID(id int) (*models.Goal, error)
+}
+
+type MemberStore interface {
+	// GetRole returns the user's role on the goal, or "" if the user is
+	// not a member.
+	GetRole(goalID, userID int) (string, error)
+}
+
+type UserStore interface {
+	GetByID(id int) (*models.User, error)
+}
+
+type Service struct {
+	goals   GoalStore
+	members MemberStore
+	users   UserStore
+}
+
+func NewService(goals GoalStore, members MemberStore, users UserStore) *Service {
+	return &Service{goals: goals, members: members, users: users}
+}
+
+// Goal loads a goal that is not in the trash and checks that the user may
+// do p with it. It returns ErrGoalNotFound or ErrForbidden otherwise.
+func (s *Service) Goal(userID, goalID int, p Permission) (*models.Goal, error) {
+	goal, err := s.goals.GetByID(goalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGoalNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Check(userID, goal, p); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+// Check is Goal for a goal the caller has loaded already, such as one in
+// the trash. Child accounts never have Withdraw, whatever their role; they
+// get ErrApprovalRequired instead where their role would allow it.
+func (s *Service) Check(userID int, goal *models.Goal, p Permission) error {
+	role, err := s.members.GetRole(goal.ID, userID)
+	if err != nil {
+		return err
+	}
+	if !Allows(role, p) {
+		return ErrForbidden
+	}
+	if p == Withdraw {
+		return s.Supervised(userID)
+	}
+	return nil
+}
+
+// TakeOut checks that money may leave the goal other than through a
+// withdrawal request, as by a transfer or by voiding a contribution. It
+// returns ErrApprovalRequired for child accounts and ErrGoalLocked while
+// the goal is locked. The user's role is not checked.
+func (s *Service) TakeOut(userID, goalID int) error {
+	if err := s.Supervised(userID); err != nil {
+		return err
+	}
+	goal, err := s.goals.GetByID(goalID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrGoalNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if goal.Locked(time.Now()) {
+		return ErrGoalLocked
+	}
+	return nil
+}
+
+// Supervised returns ErrApprovalRequired if the user is a child account.
+func (s *Service) Supervised(userID int) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.IsChild() {
+		return ErrApprovalRequired
+	}
+	return nil
+}
+
+// Record checks that the user may do p with something a member recorded
+// on the goal, such as a transaction or a schedule. Members may change
+// their own records with p alone; other members' records need Manage too.
+func (s *Service) Record(userID, goalID, recordedBy int, p Permission) (*models.Goal, error) {
+	goal, err := s.Goal(userID, goalID, p)
+	if err != nil || recordedBy == userID || p == View {
+		return goal, err
+	}
+	if err := s.Check(userID, goal, Manage); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
@@ -0,0 +1,377 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultJWTSecret is only accepted in dev mode.
+const DefaultJWTSecret = "your-secret-key-change-this-in-production"
+
+const (
+	EnvDev        = "dev"
+	EnvProduction = "production"
+)
+
+// minJWTSecretLength is the shortest secret accepted outside dev mode.
+// HS256 keys should be at least as long as the hash output.
+const minJWTSecretLength = 32
+
+type Config struct {
+	Env         string            `yaml:"env" toml:"env"`
+	ListenAddr  string            `yaml:"listen_addr" toml:"listen_addr"`
+	JWTSecret   string            `yaml:"jwt_secret" toml:"jwt_secret"`
+	RatesFile   string            `yaml:"rates_file" toml:"rates_file"`
+	AppURL      string            `yaml:"app_url" toml:"app_url"` // base of links in emails
+	Database    DatabaseConfig    `yaml:"database" toml:"database"`
+	CORS        CORSConfig        `yaml:"cors" toml:"cors"`
+	Mail        MailConfig        `yaml:"mail" toml:"mail"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth"`
+	Jobs        JobsConfig        `yaml:"jobs" toml:"jobs"`
+	Trash       TrashConfig       `yaml:"trash" toml:"trash"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency"`
+
+	// Args holds the command line arguments left after the flags, such as
+	// a "migrate" subcommand.
+	Args []string `yaml:"-" toml:"-"`
+}
+
+type DatabaseConfig struct {
+	Driver string `yaml:"driver" toml:"driver"` // sqlite or postgres
+	DSN    string `yaml:"dsn" toml:"dsn"`
+}
+
+type CORSConfig struct {
+	// An empty list disables CORS. "*" allows every origin.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+type MailConfig struct {
+	Driver       string `yaml:"driver" toml:"driver"` // log, file or smtp
+	From         string `yaml:"from" toml:"from"`
+	Dir          string `yaml:"dir" toml:"dir"`             // file driver
+	SMTPAddr     string `yaml:"smtp_addr" toml:"smtp_addr"` // host:port
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+}
+
+type AuthConfig struct {
+	// UnverifiedRoutes lists the routes open to accounts whose email is not
+	// verified yet. Each entry is a route path, optionally prefixed by an
+	// HTTP method ("GET /api/goals"); a trailing "*" matches any suffix.
+	UnverifiedRoutes []string `yaml:"unverified_routes" toml:"unverified_routes"`
+}
+
+type JobsConfig struct {
+	// Interval is how often background jobs such as recurring
+	// contributions run, as a Go duration ("1m", "30s")
+	Interval string `yaml:"interval" toml:"interval"`
+}
+
+type TrashConfig struct {
+	// Retention is how long deleted goals can be restored before they are
+	// purged for good, as a Go duration or a number of days ("30d")
+	Retention string `yaml:"retention" toml:"retention"`
+}
+
+type IdempotencyConfig struct {
+	// TTL is how long a response is kept for replay under its
+	// Idempotency-Key, as a Go duration or a number of days ("24h")
+	TTL string `yaml:"ttl" toml:"ttl"`
+	// Lease is how long a key stays reserved for a request that is still
+	// running. A request that dies without finishing frees its key once
+	// the lease runs out
+	Lease string `yaml:"lease" toml:"lease"`
+}
+
+func Default() *Config {
+	return &Config{
+		Env:        EnvDev,
+		ListenAddr: ":1323",
+		JWTSecret:  DefaultJWTSecret,
+		RatesFile:  "./rates.csv",
+		AppURL:     "http://localhost:1323",
+		Database: DatabaseConfig{
+			Driver: "sqlite",
+		},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "CashCandy <no-reply@cashcandy.local>",
+			Dir:    "./mail",
+		},
+		Auth: AuthConfig{
+			// Unverified accounts can look around and manage their login,
+			// but not change anything
+			UnverifiedRoutes: []string{"/api/auth/*", "GET /api/*"},
+		},
+		Jobs: JobsConfig{
+			Interval: "1m",
+		},
+		Trash: TrashConfig{
+			Retention: "30d",
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:   "24h",
+			Lease: "1m",
+		},
+	}
+}
+
+func (c *Config) IsDev() bool {
+	return c.Env == EnvDev
+}
+
+// JobsInterval is the parsed Jobs.Interval. Validate has checked it.
+func (c *Config) JobsInterval() time.Duration {
+	d, _ := time.ParseDuration(c.Jobs.Interval)
+	return d
+}
+
+// TrashRetention is the parsed Trash.Retention. Validate has checked it.
+func (c *Config) TrashRetention() time.Duration {
+	d, _ := parseDuration(c.Trash.Retention)
+	return d
+}
+
+// IdempotencyTTL is the parsed Idempotency.TTL. Validate has checked it.
+func (c *Config) IdempotencyTTL() time.Duration {
+	d, _ := parseDuration(c.Idempotency.TTL)
+	return d
+}
+
+// IdempotencyLease is the parsed Idempotency.Lease. Validate has checked
+// it.
+func (c *Config) IdempotencyLease() time.Duration {
+	d, _ := time.ParseDuration(c.Idempotency.Lease)
+	return d
+}
+
+// parseDuration is time.ParseDuration that also takes whole days ("30d").
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// setting ties one configuration value to its flag and environment variable.
+type setting struct {
+	flag, env, usage string
+	value            func(c *Config) *string
+}
+
+var settings = []setting{
+	{"env", "CASHCANDY_ENV", "environment: dev or production", func(c *Config) *string { return &c.Env }},
+	{"listen", "CASHCANDY_LISTEN_ADDR", "address to listen on", func(c *Config) *string { return &c.ListenAddr }},
+	{"jwt-secret", "CASHCANDY_JWT_SECRET", "secret used to sign tokens (prefer the environment variable)", func(c *Config) *string { return &c.JWTSecret }},
+	{"rates-file", "CASHCANDY_RATES_FILE", "CSV file with exchange rates", func(c *Config) *string { return &c.RatesFile }},
+	{"db-driver", "CASHCANDY_DB_DRIVER", "database driver: sqlite or postgres", func(c *Config) *string { return &c.Database.Driver }},
+	{"db-dsn", "CASHCANDY_DB_DSN", "database connection string", func(c *Config) *string { return &c.Database.DSN }},
+	{"app-url", "CASHCANDY_APP_URL", "base URL for links in emails", func(c *Config) *string { return &c.AppURL }},
+	{"mail-driver", "CASHCANDY_MAIL_DRIVER", "mail delivery: log, file or smtp", func(c *Config) *string { return &c.Mail.Driver }},
+	{"mail-from", "CASHCANDY_MAIL_FROM", "sender address for emails", func(c *Config) *string { return &c.Mail.From }},
+	{"mail-dir", "CASHCANDY_MAIL_DIR", "directory the file mail driver writes to", func(c *Config) *string { return &c.Mail.Dir }},
+	{"smtp-addr", "CASHCANDY_SMTP_ADDR", "SMTP server as host:port", func(c *Config) *string { return &c.Mail.SMTPAddr }},
+	{"smtp-username", "CASHCANDY_SMTP_USERNAME", "SMTP username", func(c *Config) *string { return &c.Mail.SMTPUsername }},
+	{"smtp-password", "CASHCANDY_SMTP_PASSWORD", "SMTP password (prefer the environment variable)", func(c *Config) *string { return &c.Mail.SMTPPassword }},
+	{"jobs-interval", "CASHCANDY_JOBS_INTERVAL", "how often background jobs run, e.g. 1m", func(c *Config) *string { return &c.Jobs.Interval }},
+	{"trash-retention", "CASHCANDY_TRASH_RETENTION", "how long deleted goals stay restorable, e.g. 30d", func(c *Config) *string { return &c.Trash.Retention }},
+	{"idempotency-ttl", "CASHCANDY_IDEMPOTENCY_TTL", "how long responses are kept for Idempotency-Key replays, e.g. 24h", func(c *Config) *string { return &c.Idempotency.TTL }},
+	{"idempotency-lease", "CASHCANDY_IDEMPOTENCY_LEASE", "how long an Idempotency-Key stays reserved for a running request, e.g. 1m", func(c *Config) *string { return &c.Idempotency.Lease }},
+}
+
+// listSetting is a setting holding a comma-separated list.
+type listSetting struct {
+	flag, env, usage string
+	value            func(c *Config) *[]string
+}
+
+var listSettings = []listSetting{
+	{"cors-origins", "CASHCANDY_CORS_ORIGINS", "comma-separated list of allowed CORS origins", func(c *Config) *[]string { return &c.CORS.AllowedOrigins }},
+	{"unverified-routes", "CASHCANDY_UNVERIFIED_ROUTES", "comma-separated list of routes open to unverified accounts", func(c *Config) *[]string { return &c.Auth.UnverifiedRoutes }},
+}
+
+// Load builds the configuration from, in order of precedence:
+//
+//  1. environment variables (CASHCANDY_*)
+//  2. the YAML or TOML file named by -config or CASHCANDY_CONFIG
+//  3. command line flags
+//  4. built-in defaults
+//
+// A value from a higher source overrides the same value from a lower one.
+// The result is validated before it is returned.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	// Flags are parsed into a scratch config so that only flags which
+	// were actually given override the defaults
+	flags := flag.NewFlagSet("cashcandy", flag.ContinueOnError)
+	fromFlags := Default()
+	for _, s := range settings {
+		flags.StringVar(s.value(fromFlags), s.flag, *s.value(fromFlags), s.usage)
+	}
+	lists := make(map[string]*string)
+	for _, s := range listSettings {
+		lists[s.flag] = flags.String(s.flag, "", s.usage)
+	}
+	configFile := flags.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) config file")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				*s.value(cfg) = *s.value(fromFlags)
+			}
+		}
+		for _, s := range listSettings {
+			if s.flag == f.Name {
+				*s.value(cfg) = splitList(*lists[s.flag])
+			}
+		}
+	})
+	cfg.Args = flags.Args()
+
+	if path := os.Getenv("CASHCANDY_CONFIG"); path != "" {
+		*configFile = path
+	}
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			*s.value(cfg) = v
+		}
+	}
+	for _, s := range listSettings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			*s.value(cfg) = splitList(v)
+		}
+	}
+
+	// Dev mode allows any origin unless told otherwise
+	if cfg.CORS.AllowedOrigins == nil && cfg.IsDev() {
+		cfg.CORS.AllowedOrigins = []string{"*"}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var problems []string
+
+	switch c.Env {
+	case EnvDev, EnvProduction:
+	default:
+		problems = append(problems, fmt.Sprintf("env must be %q or %q, got %q", EnvDev, EnvProduction, c.Env))
+	}
+
+	if c.ListenAddr == "" {
+		problems = append(problems, "listen address must not be empty")
+	}
+
+	switch {
+	case c.JWTSecret == "":
+		problems = append(problems, "JWT secret must not be empty")
+	case !c.IsDev() && c.JWTSecret == DefaultJWTSecret:
+		problems = append(problems, "refusing to use the default JWT secret outside dev mode, set CASHCANDY_JWT_SECRET")
+	case !c.IsDev() && len(c.JWTSecret) < minJWTSecretLength:
+		problems = append(problems, fmt.Sprintf("JWT secret must be at least %d characters outside dev mode", minJWTSecretLength))
+	}
+
+	switch strings.ToLower(c.Database.Driver) {
+	case "sqlite", "sqlite3":
+	case "postgres", "postgresql", "pg":
+		if c.Database.DSN == "" {
+			problems = append(problems, "database DSN is required for postgres")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported database driver %q", c.Database.Driver))
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			problems = append(problems, "mail directory is required for the file mail driver")
+		}
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			problems = append(problems, "SMTP address is required for the smtp mail driver")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported mail driver %q", c.Mail.Driver))
+	}
+	if c.Mail.From == "" {
+		problems = append(problems, "mail sender address must not be empty")
+	}
+
+	if d, err := time.ParseDuration(c.Jobs.Interval); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("jobs interval must be a positive duration such as \"1m\", got %q", c.Jobs.Interval))
+	}
+	if d, err := parseDuration(c.Trash.Retention); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("trash retention must be a positive duration such as \"30d\", got %q", c.Trash.Retention))
+	}
+	if d, err := parseDuration(c.Idempotency.TTL); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("idempotency TTL must be a positive duration such as \"24h\", got %q", c.Idempotency.TTL))
+	}
+	if d, err := time.ParseDuration(c.Idempotency.Lease); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("idempotency lease must be a positive duration such as \"1m\", got %q", c.Idempotency.Lease))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
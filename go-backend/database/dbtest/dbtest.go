@@ -0,0 +1,127 @@
+// Package dbtest opens throwaway databases for tests, one per storage
+// backend, so repository tests run once against each dialect.
+//
+// SQLite always runs, in a temporary file. PostgreSQL runs when
+// CASHCANDY_TEST_POSTGRES_DSN names a server the tests may create schemas
+// on, or when the tests are built with the embeddedpg tag, which starts an
+// embedded server instead:
+//
+//	CASHCANDY_TEST_POSTGRES_DSN=postgres://localhost/cashcandy_test?sslmode=disable go test ./...
+//	go test -tags embeddedpg ./...
+//
+// Each PostgreSQL test gets a schema of its own, dropped when it ends.
+package dbtest
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+// PostgresDSNEnv names the environment variable with the PostgreSQL server
+// to test against.
+const PostgresDSNEnv = "CASHCANDY_TEST_POSTGRES_DSN"
+
+var schemaCount atomic.Int64
+
+// Main runs the tests of a package that uses dbtest and returns the exit
+// code. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) { os.Exit(dbtest.Main(m)) }
+func Main(m *testing.M) int {
+	defer stopEmbeddedPostgres()
+	return m.Run()
+}
+
+// ForEachDialect runs fn as a subtest, named after the dialect, against an
+// empty database of every backend available.
+func ForEachDialect(t *testing.T, fn func(t *testing.T, db *database.DB)) {
+	t.Helper()
+	t.Run("sqlite", func(t *testing.T) {
+		fn(t, OpenSQLite(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		fn(t, OpenPostgres(t))
+	})
+}
+
+// ForEachMigrated is ForEachDialect with every migration applied.
+func ForEachMigrated(t *testing.T, fn func(t *testing.T, db *database.DB)) {
+	t.Helper()
+	ForEachDialect(t, func(t *testing.T, db *database.DB) {
+		if err := database.Migrate(db); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		fn(t, db)
+	})
+}
+
+// OpenSQLite opens an empty SQLite database that is removed after the
+// test.
+func OpenSQLite(t *testing.T) *database.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "cashcandy.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := database.InitDB(database.SQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// OpenPostgres opens an empty schema on the test server, or skips the test
+// if there is none.
+func OpenPostgres(t *testing.T) *database.DB {
+	t.Helper()
+	dsn := os.Getenv(PostgresDSNEnv)
+	if dsn == "" {
+		dsn = embeddedPostgres(t)
+	}
+	if dsn == "" {
+		t.Skipf("no PostgreSQL server: set %s or build with -tags embeddedpg", PostgresDSNEnv)
+	}
+
+	admin, err := database.InitDB(database.Postgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), schemaCount.Add(1))
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+
+	// lib/pq passes unknown parameters on as settings, so every
+	// connection in the pool works in the schema
+	db, err := database.InitDB(database.Postgres, withParam(dsn, "search_path", schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if admin, err := database.InitDB(database.Postgres, dsn); err == nil {
+			admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			admin.Close()
+		}
+	})
+	return db
+}
+
+// withParam adds a parameter to a DSN in either URL or key=value form.
+func withParam(dsn, key, value string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return dsn + " " + key + "=" + value
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,10 @@
+//go:build !embeddedpg
+
+package dbtest
+
+import "testing"
+
+// Without the embeddedpg tag PostgreSQL tests need PostgresDSNEnv.
+func embeddedPostgres(t *testing.T) string { return "" }
+
+func stopEmbeddedPostgres() {}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour of the storage backend. Its value is
+// the database/sql driver name.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	case "postgres", "postgresql", "pg":
+		return Postgres, nil
+	}
+	return "", fmt.Errorf("unsupported database driver %q", name)
+}
+
+func (d Dialect) migrationsDir() string {
+	if d == Postgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+func (d Dialect) tableExistsQuery() string {
+	if d == Postgres {
+		return `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+}
+
+// DateBucket returns an expression for the first day, in UTC, of the day,
+// week (starting Monday) or month containing the timestamp column. The
+// expression yields text in the form 2006-01-02.
+func (d Dialect) DateBucket(column, unit string) string {
+	if d == Postgres {
+		return fmt.Sprintf("to_char(date_trunc('%s', %s AT TIME ZONE 'UTC'), 'YYYY-MM-DD')", unit, column)
+	}
+	switch unit {
+	case "week":
+		// "weekday 0" moves forward to Sunday, six days back is Monday
+		return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", column)
+	case "month":
+		return fmt.Sprintf("strftime('%%Y-%%m-01', %s)", column)
+	default:
+		return fmt.Sprintf("date(%s)", column)
+	}
+}
+
+// Rebind rewrites the ? placeholders used throughout the repositories into
+// the dialect's native form. Question marks inside quoted strings are left
+// alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// DB wraps *sql.DB and rebinds every query for its dialect, so the
+// repositories can be written once with ? placeholders.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect}, nil
+}
+
+// Tx is the transaction counterpart of DB.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.Dialect.Rebind(query), args...)
+}
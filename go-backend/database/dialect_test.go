@@ -0,0 +1,31 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		postgres string
+	}{
+		{"no placeholders", `SELECT 1`, `SELECT 1`},
+		{"numbered in order", `SELECT * FROM goals WHERE id = ? AND user_id = ?`, `SELECT * FROM goals WHERE id = $1 AND user_id = $2`},
+		{"quoted strings kept", `SELECT '?', "a?b" FROM t WHERE x = ?`, `SELECT '?', "a?b" FROM t WHERE x = $1`},
+		{"more than nine", `VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, `VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := database.Postgres.Rebind(tt.query); got != tt.postgres {
+				t.Errorf("Postgres.Rebind() = %q, want %q", got, tt.postgres)
+			}
+			if got := database.SQLite.Rebind(tt.query); got != tt.query {
+				t.Errorf("SQLite.Rebind() = %q, want it unchanged", got)
+			}
+		})
+	}
+}
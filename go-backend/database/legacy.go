@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Databases created before the migration runner existed have the tables
+// but no schema_migrations. upgradeLegacySchema brings any of those
+// historical shapes up to the schema of migration 0001, after which the
+// runner records 0001 as applied and takes over.
+func upgradeLegacySchema(db *sql.DB) error {
+	if err := migrateMoneyToMinorUnits(db); err != nil {
+		return err
+	}
+	return addCurrencyColumns(db)
+}
+
+// migrateMoneyToMinorUnits converts databases created before amounts were
+// stored as integer cents. The REAL columns of goals and transactions are
+// rebuilt as INTEGER columns holding ROUND(amount * 100), so 0.29 becomes
+// 29 rather than 28. Databases already on the new schema are left alone.
+//
+// Dropping goals would fail while transactions reference it, so the
+// rebuild runs on its own connection with foreign keys off. SQLite ignores
+// that pragma inside a transaction; the references are checked before
+// committing instead. Transactions of goals deleted before foreign keys
+// were enforced are let through, for migration 0007 to archive.
+func migrateMoneyToMinorUnits(db *sql.DB) error {
+	columnType, err := columnType(db, "goals", "target_amount")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(columnType, "REAL") {
+		return nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin money migration: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return fmt.Errorf("failed to begin money migration: %v", err)
+	}
+	// The connection goes back to the pool afterwards
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin money migration: %v", err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE goals_minor (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			target_amount INTEGER NOT NULL,
+			current_amount INTEGER DEFAULT 0,
+			deadline DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`INSERT INTO goals_minor (id, user_id, title, target_amount, current_amount, deadline, created_at)
+		SELECT id, user_id, title,
+			CAST(ROUND(target_amount * 100) AS INTEGER),
+			CAST(ROUND(COALESCE(current_amount, 0) * 100) AS INTEGER),
+			deadline, created_at
+		FROM goals`,
+		`DROP TABLE goals`,
+		`ALTER TABLE goals_minor RENAME TO goals`,
+
+		`CREATE TABLE transactions_minor (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			goal_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			description TEXT,
+			type TEXT NOT NULL CHECK(type IN ('add', 'remove')),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (goal_id) REFERENCES goals(id)
+		)`,
+		`INSERT INTO transactions_minor (id, user_id, goal_id, amount, description, type, created_at)
+		SELECT id, user_id, goal_id, CAST(ROUND(amount * 100) AS INTEGER), description, type, created_at
+		FROM transactions`,
+		`DROP TABLE transactions`,
+		`ALTER TABLE transactions_minor RENAME TO transactions`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to migrate money columns: %v", err)
+		}
+	}
+	if err := checkForeignKeys(tx); err != nil {
+		return fmt.Errorf("failed to migrate money columns: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit money migration: %v", err)
+	}
+	return nil
+}
+
+// checkForeignKeys fails if any row references a row that does not exist,
+// other than a transaction of a deleted goal.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			table, parent string
+			rowID         sql.NullInt64
+			fkID          int
+		)
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		if table == "transactions" && parent == "goals" {
+			continue
+		}
+		return fmt.Errorf("%s row %d references a missing %s row", table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// columnType returns the declared type of a column, or "" if it is missing.
+func columnType(db *sql.DB, table, column string) (string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return "", fmt.Errorf("failed to inspect table %s: %v", table, err)
+		}
+		if name == column {
+			return ctype, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// addCurrencyColumns adds the multi-currency columns to databases created
+// before goals and transactions carried a currency. Existing rows get the
+// default currency, which is what they were implicitly stored in.
+func addCurrencyColumns(db *sql.DB) error {
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"users", "base_currency", "TEXT NOT NULL DEFAULT 'USD'"},
+		{"goals", "currency", "TEXT NOT NULL DEFAULT 'USD'"},
+		{"transactions", "currency", "TEXT NOT NULL DEFAULT 'USD'"},
+		{"transactions", "original_amount", "INTEGER"},
+		{"transactions", "original_currency", "TEXT"},
+		{"transactions", "exchange_rate", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := ensureColumn(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds a column to a table unless it already exists.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	existing, err := columnType(db, table, column)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		return nil
+	}
+
+	statement := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := db.Exec(statement); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
+	return nil
+}
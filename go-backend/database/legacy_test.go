@@ -0,0 +1,112 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+// A database from before the migration runner, with amounts stored as REAL
+// and transactions referencing their goals.
+const legacySchema = `
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE goals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		target_amount REAL NOT NULL,
+		current_amount REAL DEFAULT 0,
+		deadline DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	CREATE TABLE transactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		goal_id INTEGER NOT NULL,
+		amount REAL NOT NULL,
+		description TEXT,
+		type TEXT NOT NULL CHECK(type IN ('add', 'remove')),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (goal_id) REFERENCES goals(id)
+	);
+	INSERT INTO users (name, email, password_hash) VALUES ('Saver', 'saver@example.com', 'x');
+	INSERT INTO goals (user_id, title, target_amount, current_amount, deadline)
+	VALUES (1, 'Bike', 100.5, 0.29, '2027-01-01');
+	INSERT INTO transactions (user_id, goal_id, amount, type) VALUES (1, 1, 0.29, 'add');
+`
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := dbtest.OpenSQLite(t)
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	var target, current, amount int64
+	if err := db.QueryRow(`SELECT target_amount, current_amount FROM goals WHERE id = 1`).Scan(&target, &current); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT amount FROM transactions WHERE id = 1`).Scan(&amount); err != nil {
+		t.Fatal(err)
+	}
+	if target != 10050 || current != 29 || amount != 29 {
+		t.Errorf("amounts = %d, %d, %d; want 10050, 29, 29", target, current, amount)
+	}
+
+	// Foreign keys are enforced again afterwards
+	_, err := db.Exec(`INSERT INTO transactions (user_id, goal_id, currency, amount, type) VALUES (1, 99, 'USD', 1, 'add')`)
+	if err == nil {
+		t.Error("a transaction for a missing goal was accepted")
+	}
+}
+
+// Deleting a goal used to leave its transactions behind. The upgrade keeps
+// them, archived, rather than deleting them.
+func TestMigrateLegacyDatabaseWithOrphans(t *testing.T) {
+	db := dbtest.OpenSQLite(t)
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old schema did not enforce foreign keys
+	for _, statement := range []string{
+		`PRAGMA foreign_keys = OFF`,
+		legacySchema,
+		`INSERT INTO transactions (user_id, goal_id, amount, type) VALUES (1, 7, 1.5, 'add')`,
+		`PRAGMA foreign_keys = ON`,
+	} {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	var kept, archived, amount int64
+	if err := db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*), MAX(amount) FROM orphaned_transactions WHERE goal_id = 7`).Scan(&archived, &amount); err != nil {
+		t.Fatal(err)
+	}
+	if kept != 1 || archived != 1 || amount != 150 {
+		t.Errorf("transactions = %d, archived = %d of %d; want 1, 1 of 150", kept, archived, amount)
+	}
+}
@@ -0,0 +1,398 @@
+package database
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Each dialect has its own directory of migrations under migrations/
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var (
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	ErrUnknownMigration = errors.New("database has a migration this binary does not know")
+	ErrNotInitialised   = errors.New("database is not initialised: schema_migrations does not exist")
+)
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change, loaded from a pair of files named
+// NNNN_name.up.sql and NNNN_name.down.sql.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the embedded migrations in version order and records
+// each one in schema_migrations together with its checksum.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+
+	// DryRun prints the SQL that would run instead of executing it
+	DryRun bool
+	Out    io.Writer
+
+	// Set in dry-run mode when a legacy database would be baselined
+	dryRunBaseline bool
+}
+
+func NewMigrator(db *DB, out io.Writer) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations/"+db.Dialect.migrationsDir())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, Out: out}, nil
+}
+
+// Migrate brings the database up to date. It is run on every server start.
+func Migrate(db *DB) error {
+	migrator, err := NewMigrator(db, io.Discard)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up()
+	return err
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func (m *Migrator) tableExists(name string) (bool, error) {
+	var count int
+	err := m.db.QueryRow(m.db.Dialect.tableExistsQuery(), name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for table %s: %v", name, err)
+	}
+	return count > 0, nil
+}
+
+// initialised returns ErrNotInitialised if no migration has been recorded
+// yet. Unlike ensureMigrationsTable it never changes the database.
+func (m *Migrator) initialised() error {
+	exists, err := m.tableExists("schema_migrations")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotInitialised
+	}
+	return nil
+}
+
+func (m *Migrator) ensureMigrationsTable() error {
+	exists, err := m.tableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	// SQLite databases created by older versions already have the tables
+	// but no record of it. Upgrade them to the first migration and mark it
+	// applied. PostgreSQL support arrived with the runner, so it has no
+	// legacy databases.
+	legacy := false
+	if m.db.Dialect == SQLite {
+		if legacy, err = m.tableExists("goals"); err != nil {
+			return err
+		}
+	}
+
+	if m.DryRun {
+		if legacy {
+			fmt.Fprintln(m.Out, "-- would upgrade the legacy schema and record the first migration as applied")
+			m.dryRunBaseline = true
+		}
+		return nil
+	}
+
+	if legacy {
+		if err := upgradeLegacySchema(m.db.DB); err != nil {
+			return err
+		}
+	}
+
+	_, err = m.db.Exec(`
+		CREATE TABLE schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	if legacy && len(m.migrations) > 0 {
+		first := m.migrations[0]
+		_, err = m.db.Exec(
+			`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+			first.Version, first.Name, first.Checksum, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record legacy baseline: %v", err)
+		}
+	}
+	return nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	if m.dryRunBaseline && len(m.migrations) > 0 {
+		first := m.migrations[0]
+		applied[first.Version] = appliedMigration{name: first.Name, checksum: first.Checksum, appliedAt: time.Now()}
+	}
+	if m.DryRun {
+		// The table may not exist yet in dry-run mode
+		exists, err := m.tableExists("schema_migrations")
+		if err != nil || !exists {
+			return applied, err
+		}
+	}
+
+	rows, err := m.db.Query(`SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version int
+			record  appliedMigration
+		)
+		if err := rows.Scan(&version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+		applied[version] = record
+	}
+	return applied, rows.Err()
+}
+
+// Verify checks that every applied migration still matches the script it
+// was applied from, and that the database is not ahead of this binary.
+// It only reads the database.
+func (m *Migrator) Verify() error {
+	if err := m.initialised(); err != nil {
+		return err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	return m.verify(applied)
+}
+
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	known := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, record := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: %04d_%s", ErrUnknownMigration, version, record.name)
+		}
+		if migration.Checksum != record.checksum {
+			return fmt.Errorf("%w: %04d_%s was changed after it was applied", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration and returns the ones it applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	if err := m.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.run(migration, migration.Up, func(tx *Tx) error {
+			_, err := tx.Exec(
+				`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+				migration.Version, migration.Name, migration.Checksum, time.Now(),
+			)
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		if !m.DryRun {
+			fmt.Fprintf(m.Out, "applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the most recently applied migrations, newest first.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if err := m.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.run(migration, migration.Down, func(tx *Tx) error {
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		if !m.DryRun {
+			fmt.Fprintf(m.Out, "rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Status lists every known migration and when it was applied, if at all.
+// It only reads the database; before the first migration it lists them all
+// as pending and returns ErrNotInitialised.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied := make(map[int]appliedMigration)
+	err := m.initialised()
+	switch {
+	case err == nil:
+		if applied, err = m.applied(); err != nil {
+			return nil, err
+		}
+		err = m.verify(applied)
+	case !errors.Is(err, ErrNotInitialised):
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.appliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, err
+}
+
+// run executes one migration script and its bookkeeping in a single
+// transaction, or only prints the script in dry-run mode.
+func (m *Migrator) run(migration Migration, script string, record func(tx *Tx) error) error {
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %04d_%s\n%s\n", migration.Version, migration.Name, script)
+		return nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %04d: %v", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+	}
+	if err := record(tx); err != nil {
+		return fmt.Errorf("failed to record migration %04d: %v", migration.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %04d: %v", migration.Version, err)
+	}
+	return nil
+}
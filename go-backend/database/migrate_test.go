@@ -0,0 +1,105 @@
+package database_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Main(m))
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.DB) {
+		migrator, err := database.NewMigrator(db, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applied, err := migrator.Up()
+		if err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if len(applied) == 0 {
+			t.Fatal("Up applied no migrations")
+		}
+		assertApplied(t, migrator, len(applied))
+		if err := migrator.Verify(); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if _, err := db.Exec(`SELECT COUNT(*) FROM budget_periods`); err != nil {
+			t.Fatalf("latest table missing after Up: %v", err)
+		}
+
+		again, err := migrator.Up()
+		if err != nil || len(again) != 0 {
+			t.Fatalf("second Up = %d migrations, %v; want none", len(again), err)
+		}
+
+		rolledBack, err := migrator.Down(len(applied))
+		if err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+		if len(rolledBack) != len(applied) {
+			t.Fatalf("Down rolled back %d migrations, want %d", len(rolledBack), len(applied))
+		}
+		assertApplied(t, migrator, 0)
+		if _, err := db.Exec(`SELECT COUNT(*) FROM goals`); err == nil {
+			t.Fatal("goals still exists after rolling everything back")
+		}
+
+		if _, err := migrator.Up(); err != nil {
+			t.Fatalf("Up after Down: %v", err)
+		}
+		assertApplied(t, migrator, len(applied))
+	})
+}
+
+func TestStatusAndVerifyAreReadOnly(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.DB) {
+		migrator, err := database.NewMigrator(db, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		statuses, err := migrator.Status()
+		if !errors.Is(err, database.ErrNotInitialised) {
+			t.Fatalf("Status error = %v, want ErrNotInitialised", err)
+		}
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				t.Errorf("%04d_%s is applied in an empty database", status.Version, status.Name)
+			}
+		}
+		if err := migrator.Verify(); !errors.Is(err, database.ErrNotInitialised) {
+			t.Fatalf("Verify error = %v, want ErrNotInitialised", err)
+		}
+
+		// Neither may have created the table
+		if _, err := db.Exec(`SELECT COUNT(*) FROM schema_migrations`); err == nil {
+			t.Fatal("schema_migrations was created")
+		}
+	})
+}
+
+func assertApplied(t *testing.T, migrator *database.Migrator, want int) {
+	t.Helper()
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	applied := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied++
+		}
+	}
+	if applied != want {
+		t.Fatalf("%d migrations applied, want %d", applied, want)
+	}
+}
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	base_currency TEXT NOT NULL DEFAULT 'USD',
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS goals (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	title TEXT NOT NULL,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	target_amount BIGINT NOT NULL,
+	current_amount BIGINT DEFAULT 0,
+	deadline TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	goal_id INTEGER NOT NULL REFERENCES goals(id),
+	currency TEXT NOT NULL DEFAULT 'USD',
+	amount BIGINT NOT NULL,
+	description TEXT,
+	type TEXT NOT NULL CHECK(type IN ('add', 'remove')),
+	original_amount BIGINT,
+	original_currency TEXT,
+	exchange_rate TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
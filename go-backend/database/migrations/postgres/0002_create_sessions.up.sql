@@ -0,0 +1,16 @@
+CREATE TABLE sessions (
+	id SERIAL PRIMARY KEY,
+	public_id TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	refresh_token_hash TEXT NOT NULL UNIQUE,
+	previous_token_hash TEXT,
+	device TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	last_used_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_previous_token_hash ON sessions(previous_token_hash);
@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN schedule_id;
+DROP TABLE IF EXISTS recurring_contributions;
@@ -0,0 +1,23 @@
+CREATE TABLE recurring_contributions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	goal_id INTEGER NOT NULL REFERENCES goals(id),
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	rule TEXT NOT NULL,
+	start_at TIMESTAMPTZ NOT NULL,
+	end_at TIMESTAMPTZ,
+	-- NULL once the schedule has run its last occurrence
+	next_run_at TIMESTAMPTZ,
+	last_run_at TIMESTAMPTZ,
+	run_count INTEGER NOT NULL DEFAULT 0,
+	paused_at TIMESTAMPTZ,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_recurring_contributions_goal_id ON recurring_contributions(goal_id);
+CREATE INDEX idx_recurring_contributions_next_run_at ON recurring_contributions(next_run_at);
+
+ALTER TABLE transactions ADD COLUMN schedule_id INTEGER REFERENCES recurring_contributions(id) ON DELETE SET NULL;
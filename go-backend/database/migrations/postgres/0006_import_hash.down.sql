@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_transactions_goal_id_import_hash;
+ALTER TABLE transactions DROP COLUMN import_hash;
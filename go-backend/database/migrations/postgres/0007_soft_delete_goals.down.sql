@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_goals_deleted_at;
+ALTER TABLE goals DROP COLUMN deleted_at;
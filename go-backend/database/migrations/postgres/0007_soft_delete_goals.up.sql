@@ -0,0 +1,2 @@
+ALTER TABLE goals ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX idx_goals_deleted_at ON goals(deleted_at);
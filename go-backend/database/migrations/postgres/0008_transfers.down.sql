@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_transactions_transfer_id;
+ALTER TABLE transactions DROP COLUMN transfer_id;
+DROP TABLE IF EXISTS transfers;
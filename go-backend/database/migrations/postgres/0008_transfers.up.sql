@@ -0,0 +1,19 @@
+CREATE TABLE transfers (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	-- NULL once that goal has been purged
+	from_goal_id INTEGER REFERENCES goals(id) ON DELETE SET NULL,
+	to_goal_id INTEGER REFERENCES goals(id) ON DELETE SET NULL,
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	converted_currency TEXT NOT NULL,
+	converted_amount BIGINT NOT NULL,
+	exchange_rate TEXT,
+	description TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_transfers_user_id ON transfers(user_id);
+
+ALTER TABLE transactions ADD COLUMN transfer_id INTEGER REFERENCES transfers(id);
+CREATE INDEX idx_transactions_transfer_id ON transactions(transfer_id);
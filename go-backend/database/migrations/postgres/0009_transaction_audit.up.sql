@@ -0,0 +1,18 @@
+-- A voided transaction stays in place and is offset by a reversal entry
+-- pointing back at it
+ALTER TABLE transactions ADD COLUMN voided_at TIMESTAMPTZ;
+ALTER TABLE transactions ADD COLUMN reversal_of INTEGER REFERENCES transactions(id);
+CREATE INDEX idx_transactions_reversal_of ON transactions(reversal_of);
+
+CREATE TABLE transaction_events (
+	id SERIAL PRIMARY KEY,
+	transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	action TEXT NOT NULL,
+	-- JSON object of the amended fields and their old and new values
+	changes TEXT,
+	reversal_id INTEGER,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_transaction_events_transaction_id ON transaction_events(transaction_id);
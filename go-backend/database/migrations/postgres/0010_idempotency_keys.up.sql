@@ -0,0 +1,16 @@
+-- Responses kept for replay when a client retries a request with the
+-- same Idempotency-Key. status_code is NULL while the first request runs
+CREATE TABLE idempotency_keys (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	status_code INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (user_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
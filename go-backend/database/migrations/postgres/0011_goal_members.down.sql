@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS goal_invitations;
+DROP TABLE IF EXISTS goal_members;
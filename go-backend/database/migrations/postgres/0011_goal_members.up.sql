@@ -0,0 +1,28 @@
+CREATE TABLE goal_members (
+	goal_id INTEGER NOT NULL REFERENCES goals(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	role TEXT NOT NULL, -- owner, contributor or viewer
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (goal_id, user_id)
+);
+
+CREATE INDEX idx_goal_members_user_id ON goal_members(user_id);
+
+-- Every existing goal is owned by the user who created it
+INSERT INTO goal_members (goal_id, user_id, role, created_at)
+SELECT id, user_id, 'owner', created_at FROM goals;
+
+CREATE TABLE goal_invitations (
+	id SERIAL PRIMARY KEY,
+	goal_id INTEGER NOT NULL REFERENCES goals(id) ON DELETE CASCADE,
+	inviter_id INTEGER NOT NULL REFERENCES users(id),
+	email TEXT NOT NULL, -- lower case
+	role TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending', -- pending, accepted, declined or revoked
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	responded_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_goal_invitations_goal_id ON goal_invitations(goal_id);
+CREATE INDEX idx_goal_invitations_email ON goal_invitations(email);
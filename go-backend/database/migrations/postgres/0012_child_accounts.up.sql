@@ -0,0 +1,32 @@
+-- A child account is managed by the parent account that created it
+ALTER TABLE users ADD COLUMN parent_id INTEGER REFERENCES users(id);
+
+CREATE INDEX idx_users_parent_id ON users(parent_id);
+
+-- Limits a parent sets on a child's withdrawals, in the parent's chosen
+-- currency. A NULL limit is no limit.
+CREATE TABLE child_limits (
+	child_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	currency TEXT NOT NULL,
+	max_withdrawal BIGINT,
+	monthly_withdrawals BIGINT,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE withdrawal_requests (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	goal_id INTEGER NOT NULL REFERENCES goals(id) ON DELETE CASCADE,
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	description TEXT,
+	status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected or cancelled
+	decided_by INTEGER REFERENCES users(id),
+	decided_at TIMESTAMPTZ,
+	reason TEXT,
+	transaction_id INTEGER REFERENCES transactions(id),
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_withdrawal_requests_user_id ON withdrawal_requests(user_id);
+CREATE INDEX idx_withdrawal_requests_goal_id ON withdrawal_requests(goal_id);
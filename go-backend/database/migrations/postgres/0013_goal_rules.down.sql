@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_transactions_match_of;
+DROP INDEX IF EXISTS idx_transactions_rule_id;
+ALTER TABLE transactions DROP COLUMN match_of;
+ALTER TABLE transactions DROP COLUMN source;
+ALTER TABLE transactions DROP COLUMN rule_id;
+DROP TABLE IF EXISTS goal_rules;
@@ -0,0 +1,25 @@
+-- Rules pay bonuses into a goal on behalf of user_id: a match on each
+-- deposit, or monthly interest on the balance
+CREATE TABLE goal_rules (
+	id SERIAL PRIMARY KEY,
+	goal_id INTEGER NOT NULL REFERENCES goals(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	kind TEXT NOT NULL, -- match or interest
+	rate_bps INTEGER NOT NULL, -- hundredths of a percent
+	monthly_cap BIGINT, -- in the goal's currency
+	next_run_at TIMESTAMPTZ, -- interest only
+	last_run_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_goal_rules_goal_id ON goal_rules(goal_id);
+CREATE INDEX idx_goal_rules_next_run_at ON goal_rules(next_run_at);
+
+-- source survives the rule being deleted, so earned money stays apart
+-- from deposits
+ALTER TABLE transactions ADD COLUMN rule_id INTEGER REFERENCES goal_rules(id);
+ALTER TABLE transactions ADD COLUMN source TEXT;
+ALTER TABLE transactions ADD COLUMN match_of INTEGER REFERENCES transactions(id);
+
+CREATE INDEX idx_transactions_rule_id ON transactions(rule_id);
+CREATE INDEX idx_transactions_match_of ON transactions(match_of);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_withdrawal_requests_available_at;
+ALTER TABLE withdrawal_requests DROP COLUMN penalty_goal_id;
+ALTER TABLE withdrawal_requests DROP COLUMN penalty;
+ALTER TABLE withdrawal_requests DROP COLUMN available_at;
+ALTER TABLE goals DROP COLUMN penalty_goal_id;
+ALTER TABLE goals DROP COLUMN penalty_bps;
+ALTER TABLE goals DROP COLUMN cooling_off_hours;
+ALTER TABLE goals DROP COLUMN lock_until;
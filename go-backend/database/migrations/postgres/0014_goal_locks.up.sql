@@ -0,0 +1,16 @@
+-- A locked goal keeps its money until its deadline passes or it reaches
+-- its target. Taking money out before then waits cooling_off_hours, and
+-- penalty_bps of it moves to penalty_goal_id
+ALTER TABLE goals ADD COLUMN lock_until TEXT; -- deadline or target; NULL if not locked
+ALTER TABLE goals ADD COLUMN cooling_off_hours INTEGER;
+ALTER TABLE goals ADD COLUMN penalty_bps INTEGER;
+ALTER TABLE goals ADD COLUMN penalty_goal_id INTEGER REFERENCES goals(id) ON DELETE SET NULL;
+
+-- Withdrawals from a locked goal wait until available_at. The penalty is
+-- part of amount, in the goal's currency. Once released, a withdrawal the
+-- goal can no longer cover gets status 'failed' with a reason
+ALTER TABLE withdrawal_requests ADD COLUMN available_at TIMESTAMPTZ;
+ALTER TABLE withdrawal_requests ADD COLUMN penalty BIGINT;
+ALTER TABLE withdrawal_requests ADD COLUMN penalty_goal_id INTEGER REFERENCES goals(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_withdrawal_requests_available_at ON withdrawal_requests(available_at);
@@ -0,0 +1,40 @@
+-- Income and spending outside of goals. Budgets plan the spending per
+-- category; what is left over can be moved into goals
+CREATE TABLE categories (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_categories_user_id ON categories(user_id);
+
+CREATE TABLE entries (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	category_id INTEGER REFERENCES categories(id), -- NULL if uncategorized
+	type TEXT NOT NULL, -- income or expense
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	description TEXT,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_entries_user_id_occurred_at ON entries(user_id, occurred_at);
+CREATE INDEX idx_entries_category_id ON entries(category_id);
+
+-- What may be spent in a category each calendar month from starts_at on.
+-- rollover is none, unspent (carry what is left into the next month) or
+-- all (carry overspending too)
+CREATE TABLE budgets (
+	category_id INTEGER PRIMARY KEY REFERENCES categories(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	currency TEXT NOT NULL,
+	amount BIGINT NOT NULL,
+	rollover TEXT NOT NULL DEFAULT 'none',
+	starts_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_budgets_user_id ON budgets(user_id);
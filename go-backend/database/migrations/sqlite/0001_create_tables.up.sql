@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	base_currency TEXT NOT NULL DEFAULT 'USD',
+	password_hash TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS goals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	target_amount INTEGER NOT NULL,
+	current_amount INTEGER DEFAULT 0,
+	deadline DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	goal_id INTEGER NOT NULL,
+	currency TEXT NOT NULL DEFAULT 'USD',
+	amount INTEGER NOT NULL,
+	description TEXT,
+	type TEXT NOT NULL CHECK(type IN ('add', 'remove')),
+	original_amount INTEGER,
+	original_currency TEXT,
+	exchange_rate TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (goal_id) REFERENCES goals(id)
+);
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+-- Accounts created before verification existed are trusted as they are
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE user_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	purpose TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id, purpose);
@@ -0,0 +1,26 @@
+CREATE TABLE recurring_contributions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	goal_id INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	rule TEXT NOT NULL,
+	start_at DATETIME NOT NULL,
+	end_at DATETIME,
+	-- NULL once the schedule has run its last occurrence
+	next_run_at DATETIME,
+	last_run_at DATETIME,
+	run_count INTEGER NOT NULL DEFAULT 0,
+	paused_at DATETIME,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (goal_id) REFERENCES goals(id)
+);
+
+CREATE INDEX idx_recurring_contributions_goal_id ON recurring_contributions(goal_id);
+CREATE INDEX idx_recurring_contributions_next_run_at ON recurring_contributions(next_run_at);
+
+-- No REFERENCES here: SQLite cannot drop a column that has one
+ALTER TABLE transactions ADD COLUMN schedule_id INTEGER;
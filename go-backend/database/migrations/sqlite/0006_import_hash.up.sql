@@ -0,0 +1,4 @@
+-- Fingerprint of an imported row's date, amount and description, used to
+-- spot rows that were imported before. NULL for other transactions.
+ALTER TABLE transactions ADD COLUMN import_hash TEXT;
+CREATE INDEX idx_transactions_goal_id_import_hash ON transactions(goal_id, import_hash);
@@ -0,0 +1,5 @@
+-- orphaned_transactions and orphaned_recurring_contributions are kept:
+-- their rows reference goals that no longer exist, so they cannot go back
+-- while foreign keys are enforced, and dropping them would lose them.
+DROP INDEX IF EXISTS idx_goals_deleted_at;
+ALTER TABLE goals DROP COLUMN deleted_at;
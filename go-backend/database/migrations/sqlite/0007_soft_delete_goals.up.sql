@@ -0,0 +1,13 @@
+ALTER TABLE goals ADD COLUMN deleted_at DATETIME;
+CREATE INDEX idx_goals_deleted_at ON goals(deleted_at);
+
+-- Deleting a goal used to leave its transactions and schedules behind,
+-- unreachable. Foreign keys are enforced from now on, so those rows move
+-- to archive tables, where they can still be looked at or restored by hand.
+CREATE TABLE IF NOT EXISTS orphaned_transactions AS SELECT * FROM transactions WHERE 0;
+INSERT INTO orphaned_transactions SELECT * FROM transactions WHERE goal_id NOT IN (SELECT id FROM goals);
+DELETE FROM transactions WHERE goal_id NOT IN (SELECT id FROM goals);
+
+CREATE TABLE IF NOT EXISTS orphaned_recurring_contributions AS SELECT * FROM recurring_contributions WHERE 0;
+INSERT INTO orphaned_recurring_contributions SELECT * FROM recurring_contributions WHERE goal_id NOT IN (SELECT id FROM goals);
+DELETE FROM recurring_contributions WHERE goal_id NOT IN (SELECT id FROM goals);
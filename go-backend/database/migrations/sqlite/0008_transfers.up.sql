@@ -0,0 +1,23 @@
+CREATE TABLE transfers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	-- NULL once that goal has been purged
+	from_goal_id INTEGER,
+	to_goal_id INTEGER,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	converted_currency TEXT NOT NULL,
+	converted_amount INTEGER NOT NULL,
+	exchange_rate TEXT,
+	description TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (from_goal_id) REFERENCES goals(id) ON DELETE SET NULL,
+	FOREIGN KEY (to_goal_id) REFERENCES goals(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_transfers_user_id ON transfers(user_id);
+
+-- No REFERENCES here: SQLite cannot drop a column that has one
+ALTER TABLE transactions ADD COLUMN transfer_id INTEGER;
+CREATE INDEX idx_transactions_transfer_id ON transactions(transfer_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS transaction_events;
+DROP INDEX IF EXISTS idx_transactions_reversal_of;
+ALTER TABLE transactions DROP COLUMN reversal_of;
+ALTER TABLE transactions DROP COLUMN voided_at;
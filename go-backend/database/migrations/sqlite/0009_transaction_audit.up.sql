@@ -0,0 +1,21 @@
+-- A voided transaction stays in place and is offset by a reversal entry
+-- pointing back at it. No REFERENCES here: SQLite cannot drop a column
+-- that has one
+ALTER TABLE transactions ADD COLUMN voided_at DATETIME;
+ALTER TABLE transactions ADD COLUMN reversal_of INTEGER;
+CREATE INDEX idx_transactions_reversal_of ON transactions(reversal_of);
+
+CREATE TABLE transaction_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	-- JSON object of the amended fields and their old and new values
+	changes TEXT,
+	reversal_id INTEGER,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (transaction_id) REFERENCES transactions(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_transaction_events_transaction_id ON transaction_events(transaction_id);
@@ -0,0 +1,32 @@
+CREATE TABLE goal_members (
+	goal_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL, -- owner, contributor or viewer
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (goal_id, user_id),
+	FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_goal_members_user_id ON goal_members(user_id);
+
+-- Every existing goal is owned by the user who created it
+INSERT INTO goal_members (goal_id, user_id, role, created_at)
+SELECT id, user_id, 'owner', created_at FROM goals;
+
+CREATE TABLE goal_invitations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	goal_id INTEGER NOT NULL,
+	inviter_id INTEGER NOT NULL,
+	email TEXT NOT NULL, -- lower case
+	role TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending', -- pending, accepted, declined or revoked
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	responded_at DATETIME,
+	FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE,
+	FOREIGN KEY (inviter_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_goal_invitations_goal_id ON goal_invitations(goal_id);
+CREATE INDEX idx_goal_invitations_email ON goal_invitations(email);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS withdrawal_requests;
+DROP TABLE IF EXISTS child_limits;
+DROP INDEX IF EXISTS idx_users_parent_id;
+ALTER TABLE users DROP COLUMN parent_id;
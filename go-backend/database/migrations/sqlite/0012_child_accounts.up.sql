@@ -0,0 +1,37 @@
+-- A child account is managed by the parent account that created it
+ALTER TABLE users ADD COLUMN parent_id INTEGER;
+
+CREATE INDEX idx_users_parent_id ON users(parent_id);
+
+-- Limits a parent sets on a child's withdrawals, in the parent's chosen
+-- currency. A NULL limit is no limit.
+CREATE TABLE child_limits (
+	child_id INTEGER PRIMARY KEY,
+	currency TEXT NOT NULL,
+	max_withdrawal INTEGER,
+	monthly_withdrawals INTEGER,
+	updated_at DATETIME NOT NULL,
+	FOREIGN KEY (child_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE withdrawal_requests (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	goal_id INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	description TEXT,
+	status TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected or cancelled
+	decided_by INTEGER,
+	decided_at DATETIME,
+	reason TEXT,
+	transaction_id INTEGER,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE,
+	FOREIGN KEY (decided_by) REFERENCES users(id),
+	FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
+
+CREATE INDEX idx_withdrawal_requests_user_id ON withdrawal_requests(user_id);
+CREATE INDEX idx_withdrawal_requests_goal_id ON withdrawal_requests(goal_id);
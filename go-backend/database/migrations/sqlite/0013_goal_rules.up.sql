@@ -0,0 +1,27 @@
+-- Rules pay bonuses into a goal on behalf of user_id: a match on each
+-- deposit, or monthly interest on the balance
+CREATE TABLE goal_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	goal_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL, -- match or interest
+	rate_bps INTEGER NOT NULL, -- hundredths of a percent
+	monthly_cap INTEGER, -- in the goal's currency
+	next_run_at DATETIME, -- interest only
+	last_run_at DATETIME,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (goal_id) REFERENCES goals(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_goal_rules_goal_id ON goal_rules(goal_id);
+CREATE INDEX idx_goal_rules_next_run_at ON goal_rules(next_run_at);
+
+-- source survives the rule being deleted, so earned money stays apart
+-- from deposits. No REFERENCES: SQLite cannot drop a column that has one
+ALTER TABLE transactions ADD COLUMN rule_id INTEGER;
+ALTER TABLE transactions ADD COLUMN source TEXT;
+ALTER TABLE transactions ADD COLUMN match_of INTEGER;
+
+CREATE INDEX idx_transactions_rule_id ON transactions(rule_id);
+CREATE INDEX idx_transactions_match_of ON transactions(match_of);
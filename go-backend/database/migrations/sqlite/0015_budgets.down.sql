@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS budgets;
+DROP TABLE IF EXISTS entries;
+DROP TABLE IF EXISTS categories;
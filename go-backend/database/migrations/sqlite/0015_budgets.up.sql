@@ -0,0 +1,45 @@
+-- Income and spending outside of goals. Budgets plan the spending per
+-- category; what is left over can be moved into goals
+CREATE TABLE categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_categories_user_id ON categories(user_id);
+
+CREATE TABLE entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	category_id INTEGER, -- NULL if uncategorized
+	type TEXT NOT NULL, -- income or expense
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	description TEXT,
+	occurred_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+CREATE INDEX idx_entries_user_id_occurred_at ON entries(user_id, occurred_at);
+CREATE INDEX idx_entries_category_id ON entries(category_id);
+
+-- What may be spent in a category each calendar month from starts_at on.
+-- rollover is none, unspent (carry what is left into the next month) or
+-- all (carry overspending too)
+CREATE TABLE budgets (
+	category_id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	rollover TEXT NOT NULL DEFAULT 'none',
+	starts_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_budgets_user_id ON budgets(user_id);
@@ -0,0 +1,22 @@
+CREATE TABLE budgets (
+	category_id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	rollover TEXT NOT NULL DEFAULT 'none',
+	starts_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_budgets_user_id ON budgets(user_id);
+
+-- The latest version of each budget, from when the budget first started
+INSERT INTO budgets (category_id, user_id, currency, amount, rollover, starts_at, updated_at)
+SELECT p.category_id, p.user_id, p.currency, p.amount, p.rollover,
+	(SELECT MIN(starts_at) FROM budget_periods f WHERE f.category_id = p.category_id), p.updated_at
+FROM budget_periods p
+WHERE p.starts_at = (SELECT MAX(starts_at) FROM budget_periods l WHERE l.category_id = p.category_id);
+
+DROP TABLE budget_periods;
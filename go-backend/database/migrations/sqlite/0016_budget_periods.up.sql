@@ -0,0 +1,22 @@
+-- Every version of a category's budget, each in effect from the month it
+-- was set in (starts_at) until the next, so changing a budget leaves the
+-- figures of earlier months alone
+CREATE TABLE budget_periods (
+	category_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	starts_at DATETIME NOT NULL,
+	currency TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	rollover TEXT NOT NULL DEFAULT 'none',
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (category_id, starts_at),
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_budget_periods_user_id ON budget_periods(user_id);
+
+INSERT INTO budget_periods (category_id, user_id, starts_at, currency, amount, rollover, updated_at)
+SELECT category_id, user_id, starts_at, currency, amount, rollover, updated_at FROM budgets;
+
+DROP TABLE budgets;
@@ -0,0 +1,67 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvColumns are shared by goal and transaction rows; the record column
+// says which one a row is and the other kind's columns stay empty.
+var csvColumns = []string{
+	"schema_version", "record", "id", "goal_id", "title", "type", "description",
+	"amount", "currency", "target_amount", "current_amount", "deadline",
+	"original_amount", "original_currency", "exchange_rate", "schedule_id",
+	"voided_at", "reversal_of", "source", "match_of", "transfer_id", "created_at",
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	version string
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) begin(meta Meta, goals []GoalRecord) error {
+	e.version = strconv.Itoa(meta.SchemaVersion)
+	if err := e.w.Write(csvColumns); err != nil {
+		return err
+	}
+	for _, g := range goals {
+		row := []string{
+			e.version, "goal", strconv.Itoa(g.ID), "", safeText(g.Title), "", "",
+			"", g.Currency, g.TargetAmount, g.CurrentAmount, formatTime(g.Deadline),
+			"", "", "", "", "", "", "", "", "", formatTime(g.CreatedAt),
+		}
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *csvEncoder) transaction(t TransactionRecord) error {
+	return e.w.Write([]string{
+		e.version, "transaction", strconv.Itoa(t.ID), strconv.Itoa(t.GoalID), "", t.Type, safeText(t.Description),
+		t.Amount, t.Currency, "", "", "",
+		t.OriginalAmount, t.OriginalCurrency, t.ExchangeRate, formatID(t.ScheduleID),
+		formatOptionalTime(t.VoidedAt), formatID(t.ReversalOf), t.Source, formatID(t.MatchOf), formatID(t.TransferID),
+		formatTime(t.CreatedAt),
+	})
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// safeText keeps spreadsheets from running user text as a formula.
+func safeText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,183 @@
+// Package export writes a user's goals and transactions as CSV, JSON or
+// XLSX. Transactions are streamed: each is written as soon as it is read,
+// so exports of any size take the same memory.
+//
+// The layout of every format is versioned by SchemaVersion. Fields may be
+// added within a version; renaming or removing one needs a new version.
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+const SchemaVersion = 1
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+	XLSX Format = "xlsx"
+)
+
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/json"
+	}
+}
+
+// Meta describes an export as a whole.
+type Meta struct {
+	SchemaVersion int        `json:"schema_version"`
+	ExportedAt    time.Time  `json:"exported_at"`
+	User          UserRecord `json:"user"`
+}
+
+type UserRecord struct {
+	ID           int    `json:"id"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	BaseCurrency string `json:"base_currency"`
+}
+
+// Amounts are decimal strings such as "12.50", so no precision is lost.
+type GoalRecord struct {
+	ID            int       `json:"id"`
+	Title         string    `json:"title"`
+	Currency      string    `json:"currency"`
+	TargetAmount  string    `json:"target_amount"`
+	CurrentAmount string    `json:"current_amount"`
+	Deadline      time.Time `json:"deadline"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type TransactionRecord struct {
+	ID               int        `json:"id"`
+	GoalID           int        `json:"goal_id"`
+	Type             string     `json:"type"`
+	Amount           string     `json:"amount"`
+	Currency         string     `json:"currency"`
+	Description      string     `json:"description"`
+	OriginalAmount   string     `json:"original_amount,omitempty"`
+	OriginalCurrency string     `json:"original_currency,omitempty"`
+	ExchangeRate     string     `json:"exchange_rate,omitempty"`
+	ScheduleID       *int       `json:"schedule_id,omitempty"`
+	VoidedAt         *time.Time `json:"voided_at,omitempty"`
+	ReversalOf       *int       `json:"reversal_of,omitempty"`
+	Source           string     `json:"source,omitempty"`
+	MatchOf          *int       `json:"match_of,omitempty"`
+	TransferID       *int       `json:"transfer_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+func NewMeta(user *models.User, now time.Time) Meta {
+	return Meta{
+		SchemaVersion: SchemaVersion,
+		ExportedAt:    now,
+		User: UserRecord{
+			ID:           user.ID,
+			Email:        user.Email,
+			Name:         user.Name,
+			BaseCurrency: user.BaseCurrency,
+		},
+	}
+}
+
+func NewGoalRecord(g *models.Goal) GoalRecord {
+	return GoalRecord{
+		ID:            g.ID,
+		Title:         g.Title,
+		Currency:      g.Currency,
+		TargetAmount:  g.TargetAmount.String(),
+		CurrentAmount: g.CurrentAmount.String(),
+		Deadline:      g.Deadline,
+		CreatedAt:     g.CreatedAt,
+	}
+}
+
+func NewTransactionRecord(t *models.Transaction) TransactionRecord {
+	record := TransactionRecord{
+		ID:          t.ID,
+		GoalID:      t.GoalID,
+		Type:        t.Type,
+		Amount:      t.Amount.String(),
+		Currency:    t.Currency,
+		Description: t.Description,
+		ScheduleID:  t.ScheduleID,
+		VoidedAt:    t.VoidedAt,
+		ReversalOf:  t.ReversalOf,
+		Source:      t.Source,
+		MatchOf:     t.MatchOf,
+		TransferID:  t.TransferID,
+		CreatedAt:   t.CreatedAt,
+	}
+	if t.OriginalAmount != nil {
+		record.OriginalAmount = t.OriginalAmount.String()
+		record.OriginalCurrency = t.OriginalCurrency
+		record.ExchangeRate = t.ExchangeRate
+	}
+	return record
+}
+
+// Transactions feeds every transaction to fn in turn, stopping at the
+// first error.
+type Transactions func(fn func(TransactionRecord) error) error
+
+// encoder writes one format. begin is called once, then transaction for
+// every transaction, then end.
+type encoder interface {
+	begin(meta Meta, goals []GoalRecord) error
+	transaction(t TransactionRecord) error
+	end() error
+}
+
+// Write writes the export to w in the given format.
+func Write(w io.Writer, format Format, meta Meta, goals []GoalRecord, transactions Transactions) error {
+	var enc encoder
+	switch format {
+	case CSV:
+		enc = newCSVEncoder(w)
+	case JSON:
+		enc = newJSONEncoder(w)
+	case XLSX:
+		enc = newXLSXEncoder(w)
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	if err := enc.begin(meta, goals); err != nil {
+		return err
+	}
+	if err := transactions(enc.transaction); err != nil {
+		return err
+	}
+	return enc.end()
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
+
+func formatID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
@@ -0,0 +1,60 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// jsonEncoder writes one object:
+//
+//	{"schema_version": 1, "exported_at": ..., "user": {...},
+//	 "goals": [...], "transactions": [...]}
+type jsonEncoder struct {
+	w     *bufio.Writer
+	first bool
+}
+
+func newJSONEncoder(w io.Writer) *jsonEncoder {
+	return &jsonEncoder{w: bufio.NewWriter(w), first: true}
+}
+
+func (e *jsonEncoder) begin(meta Meta, goals []GoalRecord) error {
+	head, err := json.Marshal(struct {
+		Meta
+		Goals []GoalRecord `json:"goals"`
+	}{meta, goals})
+	if err != nil {
+		return err
+	}
+	// Leave the object open for the transactions
+	head = bytes.TrimSuffix(head, []byte("}"))
+	if _, err := e.w.Write(head); err != nil {
+		return err
+	}
+	_, err = e.w.WriteString(`,"transactions":[`)
+	return err
+}
+
+func (e *jsonEncoder) transaction(t TransactionRecord) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if !e.first {
+		if err := e.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	e.first = false
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	if _, err := e.w.WriteString("]}\n"); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,199 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// xlsxEncoder writes a workbook with an Info, a Goals and a Transactions
+// sheet. The archive is written front to back and the Transactions sheet
+// comes last, so its rows can be streamed into it. Text uses inline
+// strings, which spares a shared string table that would have to be kept
+// in memory.
+type xlsxEncoder struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer // the open Transactions sheet
+}
+
+// cell is a spreadsheet value, written as a number when numeric is set.
+type cell struct {
+	value   string
+	numeric bool
+}
+
+func text(s string) cell   { return cell{value: s} }
+func number(s string) cell { return cell{value: s, numeric: s != ""} }
+
+var goalSheetColumns = []string{"id", "title", "currency", "target_amount", "current_amount", "deadline", "created_at"}
+
+var transactionSheetColumns = []string{
+	"id", "goal_id", "type", "amount", "currency", "description",
+	"original_amount", "original_currency", "exchange_rate", "schedule_id",
+	"voided_at", "reversal_of", "source", "match_of", "transfer_id", "created_at",
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/worksheets/sheet3.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>
+<sheet name="Info" sheetId="1" r:id="rId1"/>
+<sheet name="Goals" sheetId="2" r:id="rId2"/>
+<sheet name="Transactions" sheetId="3" r:id="rId3"/>
+</sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet3.xml"/>
+</Relationships>`
+
+const (
+	sheetHead = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetTail = `</sheetData></worksheet>`
+)
+
+func newXLSXEncoder(w io.Writer) *xlsxEncoder {
+	return &xlsxEncoder{zip: zip.NewWriter(w)}
+}
+
+func (e *xlsxEncoder) begin(meta Meta, goals []GoalRecord) error {
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := e.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	info := [][]cell{
+		{text("schema_version"), number(strconv.Itoa(meta.SchemaVersion))},
+		{text("exported_at"), text(formatTime(meta.ExportedAt))},
+		{text("user_id"), number(strconv.Itoa(meta.User.ID))},
+		{text("email"), text(meta.User.Email)},
+		{text("name"), text(meta.User.Name)},
+		{text("base_currency"), text(meta.User.BaseCurrency)},
+	}
+	if err := e.writeSheet("xl/worksheets/sheet1.xml", info); err != nil {
+		return err
+	}
+
+	rows := [][]cell{header(goalSheetColumns)}
+	for _, g := range goals {
+		rows = append(rows, []cell{
+			number(strconv.Itoa(g.ID)), text(g.Title), text(g.Currency),
+			number(g.TargetAmount), number(g.CurrentAmount),
+			text(formatTime(g.Deadline)), text(formatTime(g.CreatedAt)),
+		})
+	}
+	if err := e.writeSheet("xl/worksheets/sheet2.xml", rows); err != nil {
+		return err
+	}
+
+	f, err := e.zip.Create("xl/worksheets/sheet3.xml")
+	if err != nil {
+		return err
+	}
+	e.sheet = bufio.NewWriter(f)
+	if _, err := e.sheet.WriteString(sheetHead); err != nil {
+		return err
+	}
+	return writeRow(e.sheet, header(transactionSheetColumns))
+}
+
+func (e *xlsxEncoder) transaction(t TransactionRecord) error {
+	return writeRow(e.sheet, []cell{
+		number(strconv.Itoa(t.ID)), number(strconv.Itoa(t.GoalID)), text(t.Type),
+		number(t.Amount), text(t.Currency), text(t.Description),
+		number(t.OriginalAmount), text(t.OriginalCurrency), number(t.ExchangeRate),
+		number(formatID(t.ScheduleID)), text(formatOptionalTime(t.VoidedAt)), number(formatID(t.ReversalOf)),
+		text(t.Source), number(formatID(t.MatchOf)), number(formatID(t.TransferID)), text(formatTime(t.CreatedAt)),
+	})
+}
+
+func (e *xlsxEncoder) end() error {
+	if _, err := e.sheet.WriteString(sheetTail); err != nil {
+		return err
+	}
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+func (e *xlsxEncoder) writeSheet(name string, rows [][]cell) error {
+	f, err := e.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(sheetHead); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writeRow(w, row); err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteString(sheetTail); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func header(columns []string) []cell {
+	cells := make([]cell, len(columns))
+	for i, c := range columns {
+		cells[i] = text(c)
+	}
+	return cells
+}
+
+// writeRow checks only the last write: a bufio.Writer keeps returning
+// its first error.
+func writeRow(w *bufio.Writer, cells []cell) error {
+	w.WriteString("<row>")
+	for _, c := range cells {
+		switch {
+		case c.value == "":
+			w.WriteString("<c/>")
+		case c.numeric:
+			w.WriteString(`<c t="n"><v>`)
+			xml.EscapeText(w, []byte(c.value))
+			w.WriteString("</v></c>")
+		default:
+			w.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(w, []byte(c.value))
+			w.WriteString("</t></is></c>")
+		}
+	}
+	_, err := w.WriteString("</row>")
+	return err
+}
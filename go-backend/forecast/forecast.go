@@ -0,0 +1,145 @@
+// Package forecast projects when a savings goal will be reached from the
+// pace of its past contributions. It works on plain minor-unit amounts
+// and takes the current time as an argument, so results depend only on
+// the input.
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Window is how far back contributions count towards the velocity
+	Window = 90 * 24 * time.Hour
+	// MinWindow keeps one early contribution to a new goal from
+	// suggesting an unrealistic pace
+	MinWindow = 7 * 24 * time.Hour
+	// AtRiskRatio is the share of the required pace below which a goal
+	// is behind rather than at risk
+	AtRiskRatio = 0.75
+	// Horizon is how far ahead a completion date is projected. Further
+	// out, at a pace that slow, the goal is taken as never reached; it
+	// also keeps the projection well within the range of time.Duration
+	Horizon = 100 * 365 * 24 * time.Hour
+)
+
+const day = 24 * time.Hour
+
+// daysPerMonth is the average length of a month in the Gregorian calendar
+const daysPerMonth = 365.2425 / 12
+
+type Status string
+
+const (
+	Completed Status = "completed"
+	OnTrack   Status = "on_track"
+	AtRisk    Status = "at_risk"
+	Behind    Status = "behind"
+)
+
+// Contribution is one change to a goal's balance, negative for withdrawals.
+type Contribution struct {
+	Amount int64
+	At     time.Time
+}
+
+// Goal is what a forecast needs to know about a goal. Amounts are in minor
+// units of the goal's currency.
+type Goal struct {
+	Target    int64
+	Current   int64
+	CreatedAt time.Time
+	Deadline  time.Time
+	History   []Contribution
+}
+
+// Forecast is the outlook for a goal. Required amounts are rounded up to
+// whole minor units and are zero once the goal is completed.
+type Forecast struct {
+	// Velocity is the net amount saved per day over the recent window
+	Velocity float64
+	// ProjectedCompletion is when the goal is reached at the current
+	// velocity, nil if it is already reached or won't be within Horizon
+	ProjectedCompletion *time.Time
+	RequiredDaily       int64
+	RequiredWeekly      int64
+	RequiredMonthly     int64
+	Status              Status
+}
+
+// Compute forecasts g as of now.
+func Compute(g Goal, now time.Time) Forecast {
+	f := Forecast{Velocity: Velocity(g, now)}
+
+	remaining := g.Target - g.Current
+	if remaining <= 0 {
+		f.Status = Completed
+		return f
+	}
+
+	if f.Velocity > 0 {
+		// Compared as a float, as a slow enough pace overflows Duration
+		if toGo := float64(remaining) / f.Velocity * float64(day); toGo <= float64(Horizon) {
+			projected := now.Add(time.Duration(toGo))
+			f.ProjectedCompletion = &projected
+		}
+	}
+
+	daysLeft := g.Deadline.Sub(now).Hours() / 24
+	f.RequiredDaily = required(remaining, 1, daysLeft)
+	f.RequiredWeekly = required(remaining, 7, daysLeft)
+	f.RequiredMonthly = required(remaining, daysPerMonth, daysLeft)
+
+	switch {
+	case daysLeft <= 0:
+		f.Status = Behind
+	case f.Velocity >= float64(remaining)/daysLeft:
+		f.Status = OnTrack
+	case f.Velocity >= AtRiskRatio*float64(remaining)/daysLeft:
+		f.Status = AtRisk
+	default:
+		f.Status = Behind
+	}
+	return f
+}
+
+// Velocity is the net amount contributed per day over the last Window, or
+// since the goal started if that is more recent. A goal starts when it
+// was created or at its first contribution, whichever is earlier, so
+// backdated history counts. The period is at least MinWindow long.
+func Velocity(g Goal, now time.Time) float64 {
+	started := g.CreatedAt
+	for _, c := range g.History {
+		if c.At.Before(started) {
+			started = c.At
+		}
+	}
+	from := now.Add(-Window)
+	if started.After(from) {
+		from = started
+	}
+
+	var total int64
+	for _, c := range g.History {
+		if !c.At.Before(from) && !c.At.After(now) {
+			total += c.Amount
+		}
+	}
+
+	period := now.Sub(from)
+	if period < MinWindow {
+		period = MinWindow
+	}
+	return float64(total) / (period.Hours() / 24)
+}
+
+// required is the amount to save every periodDays days to close remaining
+// within daysLeft. With less than one period left the whole remainder is
+// needed at once.
+func required(remaining int64, periodDays, daysLeft float64) int64 {
+	if daysLeft <= periodDays {
+		return remaining
+	}
+	return int64(math.Ceil(float64(remaining) * periodDays / daysLeft))
+}
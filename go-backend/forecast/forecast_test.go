@@ -0,0 +1,193 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return now.Add(-time.Duration(n) * day) }
+	inDays := func(n int) time.Time { return now.Add(time.Duration(n) * day) }
+
+	tests := []struct {
+		name string
+		goal Goal
+		want Forecast
+	}{
+		{
+			name: "no history",
+			goal: Goal{Target: 10000, CreatedAt: daysAgo(30), Deadline: inDays(100)},
+			want: Forecast{
+				RequiredDaily:   100,
+				RequiredWeekly:  700,
+				RequiredMonthly: 3044,
+				Status:          Behind,
+			},
+		},
+		{
+			name: "zero velocity",
+			goal: Goal{
+				Target:    10000,
+				CreatedAt: daysAgo(30),
+				Deadline:  inDays(100),
+				History: []Contribution{
+					{Amount: 500, At: daysAgo(20)},
+					{Amount: -500, At: daysAgo(10)},
+				},
+			},
+			want: Forecast{
+				RequiredDaily:   100,
+				RequiredWeekly:  700,
+				RequiredMonthly: 3044,
+				Status:          Behind,
+			},
+		},
+		{
+			name: "past deadline",
+			goal: Goal{
+				Target:    10000,
+				Current:   5000,
+				CreatedAt: daysAgo(50),
+				Deadline:  daysAgo(1),
+				History:   []Contribution{{Amount: 5000, At: daysAgo(50)}},
+			},
+			want: Forecast{
+				Velocity:            100,
+				ProjectedCompletion: timePtr(inDays(50)),
+				RequiredDaily:       5000,
+				RequiredWeekly:      5000,
+				RequiredMonthly:     5000,
+				Status:              Behind,
+			},
+		},
+		{
+			name: "on track",
+			goal: Goal{
+				Target:    11000,
+				Current:   1000,
+				CreatedAt: daysAgo(10),
+				Deadline:  inDays(100),
+				History:   []Contribution{{Amount: 1000, At: daysAgo(10)}},
+			},
+			want: Forecast{
+				Velocity:            100,
+				ProjectedCompletion: timePtr(inDays(100)),
+				RequiredDaily:       100,
+				RequiredWeekly:      700,
+				RequiredMonthly:     3044,
+				Status:              OnTrack,
+			},
+		},
+		{
+			name: "at risk",
+			goal: Goal{
+				Target:    10800,
+				Current:   800,
+				CreatedAt: daysAgo(10),
+				Deadline:  inDays(100),
+				History:   []Contribution{{Amount: 800, At: daysAgo(10)}},
+			},
+			want: Forecast{
+				Velocity:            80,
+				ProjectedCompletion: timePtr(inDays(125)),
+				RequiredDaily:       100,
+				RequiredWeekly:      700,
+				RequiredMonthly:     3044,
+				Status:              AtRisk,
+			},
+		},
+		{
+			name: "behind",
+			goal: Goal{
+				Target:    10500,
+				Current:   500,
+				CreatedAt: daysAgo(10),
+				Deadline:  inDays(100),
+				History:   []Contribution{{Amount: 500, At: daysAgo(10)}},
+			},
+			want: Forecast{
+				Velocity:            50,
+				ProjectedCompletion: timePtr(inDays(200)),
+				RequiredDaily:       100,
+				RequiredWeekly:      700,
+				RequiredMonthly:     3044,
+				Status:              Behind,
+			},
+		},
+		{
+			name: "beyond the horizon",
+			goal: Goal{
+				Target:    100_000_000_001,
+				Current:   1,
+				CreatedAt: daysAgo(10),
+				Deadline:  inDays(100),
+				History:   []Contribution{{Amount: 1, At: daysAgo(10)}},
+			},
+			want: Forecast{
+				Velocity:        0.1,
+				RequiredDaily:   1_000_000_000,
+				RequiredWeekly:  7_000_000_000,
+				RequiredMonthly: 30_436_875_000,
+				Status:          Behind,
+			},
+		},
+		{
+			name: "already completed",
+			goal: Goal{
+				Target:    1000,
+				Current:   1200,
+				CreatedAt: daysAgo(10),
+				Deadline:  inDays(100),
+				History:   []Contribution{{Amount: 1200, At: daysAgo(10)}},
+			},
+			want: Forecast{Velocity: 120, Status: Completed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compute(tt.goal, now)
+
+			if got.Status != tt.want.Status {
+				t.Errorf("Status = %s, want %s", got.Status, tt.want.Status)
+			}
+			if got.Velocity != tt.want.Velocity {
+				t.Errorf("Velocity = %v, want %v", got.Velocity, tt.want.Velocity)
+			}
+			if got.RequiredDaily != tt.want.RequiredDaily ||
+				got.RequiredWeekly != tt.want.RequiredWeekly ||
+				got.RequiredMonthly != tt.want.RequiredMonthly {
+				t.Errorf("required = %d/%d/%d, want %d/%d/%d",
+					got.RequiredDaily, got.RequiredWeekly, got.RequiredMonthly,
+					tt.want.RequiredDaily, tt.want.RequiredWeekly, tt.want.RequiredMonthly)
+			}
+			switch {
+			case tt.want.ProjectedCompletion == nil && got.ProjectedCompletion != nil:
+				t.Errorf("ProjectedCompletion = %v, want nil", *got.ProjectedCompletion)
+			case tt.want.ProjectedCompletion != nil && got.ProjectedCompletion == nil:
+				t.Errorf("ProjectedCompletion = nil, want %v", *tt.want.ProjectedCompletion)
+			case tt.want.ProjectedCompletion != nil && !got.ProjectedCompletion.Equal(*tt.want.ProjectedCompletion):
+				t.Errorf("ProjectedCompletion = %v, want %v", *got.ProjectedCompletion, *tt.want.ProjectedCompletion)
+			}
+		})
+	}
+}
+
+func TestVelocityMinWindow(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	g := Goal{
+		Target:    10000,
+		CreatedAt: now.Add(-day),
+		History:   []Contribution{{Amount: 700, At: now.Add(-day)}},
+	}
+
+	// One day of history is spread over MinWindow
+	if got := Velocity(g, now); got != 100 {
+		t.Errorf("Velocity = %v, want 100", got)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// Authorizer decides what a user may do with a goal; see access.Service.
+type Authorizer interface {
+	Goal(userID, goalID int, p access.Permission) (*models.Goal, error)
+	Check(userID int, goal *models.Goal, p access.Permission) error
+	Record(userID, goalID, recordedBy int, p access.Permission) (*models.Goal, error)
+	TakeOut(userID, goalID int) error
+	Supervised(userID int) error
+}
+
+// authorizedGoal loads the goal named by the :id parameter if the user has
+// the permission on it. Otherwise it writes the error response and returns
+// a nil goal.
+func authorizedGoal(c echo.Context, authorizer Authorizer, p access.Permission) (*models.Goal, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
+	}
+
+	goal, err := authorizer.Goal(userID, goalID, p)
+	if err != nil {
+		return nil, accessFailed(c, err)
+	}
+	return goal, nil
+}
+
+// accessFailed writes the response for an error from an Authorizer.
+func accessFailed(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, access.ErrGoalNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+	case errors.Is(err, access.ErrForbidden):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+	case errors.Is(err, access.ErrApprovalRequired):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Withdrawals need a parent's approval"})
+	case errors.Is(err, access.ErrGoalLocked):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Goal is locked; money can only leave it through a withdrawal request"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check access"})
+}
+
+// transactionPermission is what recording a transaction of the type takes.
+func transactionPermission(transactionType string) access.Permission {
+	if transactionType == "remove" {
+		return access.Withdraw
+	}
+	return access.Contribute
+}
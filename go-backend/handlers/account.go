@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/mailer"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+type UserTokenRepository interface {
+	Create(token *models.UserToken) error
+	Consume(purpose, tokenHash string) (*models.UserToken, error)
+}
+
+type Mailer interface {
+	Send(msg mailer.Message) error
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ForgotPassword emails a password reset link. It answers the same way
+// whether or not the address belongs to an account, so it cannot be used
+// to find out who is registered.
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	var req ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if user, err := h.userRepo.GetByEmail(req.Email); err == nil {
+		token, err := h.issueUserToken(user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+		if err == nil {
+			err = h.mailer.Send(mailer.Message{
+				To:      user.Email,
+				Subject: "Reset your CashCandy password",
+				Body: fmt.Sprintf("Hi %s,\n\nOpen this link to choose a new password:\n\n%s\n\n"+
+					"The link expires in 1 hour. If you did not ask for it, you can ignore this email.\n",
+					user.Name, h.link("/reset-password", token)),
+			})
+		}
+		if err != nil {
+			c.Logger().Errorf("failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "If the address is registered, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using the token from the reset email
+// and signs the user out of every device.
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	token, err := h.tokenRepo.Consume(models.TokenPurposePasswordReset, h.signToken(models.TokenPurposePasswordReset, req.Token))
+	if errors.Is(err, models.ErrTokenInvalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset password"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+	}
+	if err := h.userRepo.UpdatePassword(token.UserID, string(hashedPassword)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset password"})
+	}
+
+	// Following the emailed link proves the user owns the address
+	if err := h.userRepo.MarkEmailVerified(token.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reset password"})
+	}
+	if err := h.sessionRepo.RevokeAllByUserID(token.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke sessions"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
+}
+
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	var req VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	token, err := h.tokenRepo.Consume(models.TokenPurposeEmailVerification, h.signToken(models.TokenPurposeEmailVerification, req.Token))
+	if errors.Is(err, models.ErrTokenInvalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify email"})
+	}
+
+	if err := h.userRepo.MarkEmailVerified(token.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify email"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email verified successfully"})
+}
+
+func (h *AuthHandler) ResendVerification(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if user.IsEmailVerified() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Email is already verified"})
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send verification email"})
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
+
+func (h *AuthHandler) sendVerificationEmail(user *models.User) error {
+	token, err := h.issueUserToken(user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your CashCandy email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to confirm your email address:\n\n%s\n\n"+
+			"The link expires in 48 hours.\n",
+			user.Name, h.link("/verify-email", token)),
+	})
+}
+
+// issueUserToken stores a new single-use token and returns the plain
+// value, which only ever leaves the server inside an email.
+func (h *AuthHandler) issueUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.tokenRepo.Create(&models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: h.signToken(purpose, token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// signToken is the HMAC stored for an emailed token. Keying it with the
+// server secret means rows copied out of the database cannot be turned
+// into working tokens, and binding the purpose keeps a verification token
+// from being used as a reset token.
+func (h *AuthHandler) signToken(purpose, token string) string {
+	mac := hmac.New(sha256.New, h.jwtKey)
+	mac.Write([]byte(purpose + ":" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *AuthHandler) link(path, token string) string {
+	return h.appURL + path + "?token=" + url.QueryEscape(token)
+}
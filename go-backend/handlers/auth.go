@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,20 +12,34 @@ import (
 )
 
 type AuthHandler struct {
-	userRepo UserRepository
-	jwtKey   []byte
+	userRepo     UserRepository
+	sessionRepo  SessionRepository
+	tokenRepo    UserTokenRepository
+	recoveryRepo RecoveryCodeRepository
+	mailer       Mailer
+	jwtKey       []byte
+	appURL       string
+	mfaAttempts  attemptLimiter
 }
 
 type UserRepository interface {
 	Create(user *models.User) error
 	GetByEmail(email string) (*models.User, error)
 	GetByID(id int) (*models.User, error)
+	UpdatePassword(id int, passwordHash string) error
+	MarkEmailVerified(id int) error
+	SetTOTPSecret(id int, secret string) error
+	EnableTOTP(id int) error
+	DisableTOTP(id int) error
+	UseTOTPStep(id int, step int64) error
 }
 
 type RegisterRequest struct {
-	Name     string `json:"name" validate:"required"`
+	Name     string `json:"name" validate:"required,max=100"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=6"`
+	// Dashboard totals are reported in this currency, defaults to USD
+	BaseCurrency string `json:"base_currency" validate:"omitempty,currency"`
 }
 
 type LoginRequest struct {
@@ -33,8 +48,10 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+	RefreshToken string      `json:"refresh_token"`
+	User         models.User `json:"user"`
 }
 
 type Claims struct {
@@ -42,10 +59,15 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func NewAuthHandler(userRepo UserRepository, jwtKey []byte) *AuthHandler {
+func NewAuthHandler(userRepo UserRepository, sessionRepo SessionRepository, tokenRepo UserTokenRepository, recoveryRepo RecoveryCodeRepository, mailer Mailer, jwtKey []byte, appURL string) *AuthHandler {
 	return &AuthHandler{
-		userRepo: userRepo,
-		jwtKey:   jwtKey,
+		userRepo:     userRepo,
+		sessionRepo:  sessionRepo,
+		tokenRepo:    tokenRepo,
+		recoveryRepo: recoveryRepo,
+		mailer:       mailer,
+		jwtKey:       jwtKey,
+		appURL:       strings.TrimRight(appURL, "/"),
 	}
 }
 
@@ -54,6 +76,14 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	baseCurrency, err := models.NormalizeCurrency(req.BaseCurrency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
+	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -65,6 +95,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	user := &models.User{
 		Name:         req.Name,
 		Email:        req.Email,
+		BaseCurrency: baseCurrency,
 		PasswordHash: string(hashedPassword),
 	}
 
@@ -72,16 +103,18 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return c.JSON(http.StatusConflict, map[string]string{"error": "User already exists"})
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	// The account works without it, so a failed email only gets logged
+	if err := h.sendVerificationEmail(user); err != nil {
+		c.Logger().Errorf("failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	// Start a session and issue its tokens
+	response, err := h.startSession(c, user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
 	}
 
-	return c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	return c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c echo.Context) error {
@@ -89,6 +122,9 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
 
 	// Get user by email
 	user, err := h.userRepo.GetByEmail(req.Email)
@@ -101,23 +137,30 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	// With two-factor authentication the password only earns a challenge
+	if user.IsTOTPEnabled() {
+		challenge, err := h.mfaChallenge(user)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+		}
+		return c.JSON(http.StatusOK, challenge)
+	}
+
+	// Start a session and issue its tokens
+	response, err := h.startSession(c, user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
 	}
 
-	return c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	return c.JSON(http.StatusOK, response)
 }
 
-func (h *AuthHandler) generateToken(userID int) (string, error) {
+func (h *AuthHandler) generateToken(userID int, sessionID string, expiresAt time.Time) (string, error) {
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -0,0 +1,569 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/rates"
+)
+
+// BudgetsHandler tracks a user's income and spending outside of their
+// goals: categories, the entries recorded in them, and monthly budgets per
+// category. What the user earns beyond what they spend is their surplus,
+// which they can move into goals as ordinary deposits.
+type BudgetsHandler struct {
+	categories CategoryRepository
+	entries    EntryRepository
+	budgets    BudgetRepository
+	userRepo   UserRepository
+	access     Authorizer
+	ledger     SurplusLedger
+	rates      ExchangeRateProvider
+}
+
+type CategoryRepository interface {
+	Create(category *models.Category) error
+	GetByID(id int) (*models.Category, error)
+	GetByUserID(userID int) ([]models.Category, error)
+	Rename(category *models.Category) error
+	Delete(id int) error
+}
+
+type EntryRepository interface {
+	Create(e *models.Entry) error
+	GetByID(id int) (*models.Entry, error)
+	GetBetween(userID int, from, to time.Time) ([]models.Entry, error)
+	Delete(id int) error
+	Surplus(userID int) ([]models.Money, error)
+}
+
+type BudgetRepository interface {
+	GetByUserID(userID int) (map[int]models.BudgetHistory, error)
+	Set(b *models.Budget) error
+	Delete(categoryID int) error
+}
+
+// SurplusLedger records moves of surplus into goals; see
+// models.Ledger.MoveSurplus.
+type SurplusLedger interface {
+	MoveSurplus(transaction *models.Transaction, check func(surplus []models.Money) error) error
+}
+
+type CategoryRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type CreateEntryRequest struct {
+	Type       string       `json:"type" validate:"required,oneof=income expense"`
+	Amount     models.Money `json:"amount" validate:"required,gt=0"`
+	Currency   string       `json:"currency" validate:"omitempty,currency"` // defaults to the user's base currency
+	CategoryID *int         `json:"category_id"`
+	// Description is optional
+	Description string    `json:"description" validate:"max=500"`
+	OccurredAt  time.Time `json:"occurred_at"` // defaults to now
+}
+
+// BudgetRequest changes a category's budget. Amount is in the user's
+// base currency and Rollover defaults to none.
+type BudgetRequest struct {
+	Amount   models.Money `json:"amount" validate:"required,gt=0"`
+	Rollover string       `json:"rollover" validate:"omitempty,oneof=none unspent all"`
+}
+
+type MoveSurplusRequest struct {
+	GoalID      int          `json:"goal_id" validate:"required,gt=0"`
+	Amount      models.Money `json:"amount" validate:"required,gt=0"` // in the goal's currency
+	Description string       `json:"description" validate:"max=500"`
+}
+
+type MonthQuery struct {
+	Month string `query:"month"` // YYYY-MM, defaults to the current month
+}
+
+// BudgetReport compares a month's spending with the user's budgets, in
+// their base currency.
+type BudgetReport struct {
+	Month            string       `json:"month"`
+	Currency         string       `json:"currency"`
+	Income           models.Money `json:"income"`
+	Expenses         models.Money `json:"expenses"`
+	Surplus          models.Money `json:"surplus"`           // income less expenses in the month
+	AvailableSurplus models.Money `json:"available_surplus"` // all surplus to date not yet moved into goals
+	Categories       []BudgetLine `json:"categories"`
+	// Currencies left out of the figures for want of an exchange rate
+	MissingRates []string `json:"missing_rates"`
+}
+
+// BudgetLine is a category with a budget or spending in the month. The
+// budget figures are nil if it had no budget that month, or its budget is
+// in a currency without an exchange rate.
+type BudgetLine struct {
+	CategoryID  *int          `json:"category_id"` // nil for uncategorized spending
+	Name        string        `json:"name"`
+	Rollover    string        `json:"rollover,omitempty"`
+	Planned     *models.Money `json:"planned"`
+	CarriedOver *models.Money `json:"carried_over"` // negative if overspending was carried
+	Spent       models.Money  `json:"spent"`
+	Remaining   *models.Money `json:"remaining"` // planned and carried over, less spent
+}
+
+func NewBudgetsHandler(categories CategoryRepository, entries EntryRepository, budgets BudgetRepository, userRepo UserRepository, authorizer Authorizer, ledger SurplusLedger, rates ExchangeRateProvider) *BudgetsHandler {
+	return &BudgetsHandler{
+		categories: categories,
+		entries:    entries,
+		budgets:    budgets,
+		userRepo:   userRepo,
+		access:     authorizer,
+		ledger:     ledger,
+		rates:      rates,
+	}
+}
+
+func (h *BudgetsHandler) GetCategories(c echo.Context) error {
+	categories, err := h.categories.GetByUserID(c.Get("user_id").(int))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get categories"})
+	}
+	return c.JSON(http.StatusOK, listResponse(categories, ""))
+}
+
+func (h *BudgetsHandler) CreateCategory(c echo.Context) error {
+	var req CategoryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	category := &models.Category{UserID: c.Get("user_id").(int), Name: req.Name}
+	if err := h.categories.Create(category); err != nil {
+		return categoryFailed(c, err, "Failed to create category")
+	}
+
+	return c.JSON(http.StatusCreated, category)
+}
+
+func (h *BudgetsHandler) RenameCategory(c echo.Context) error {
+	category, err := h.category(c, c.Param("id"))
+	if category == nil {
+		return err
+	}
+
+	var req CategoryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	category.Name = req.Name
+	if err := h.categories.Rename(category); err != nil {
+		return categoryFailed(c, err, "Failed to rename category")
+	}
+
+	return c.JSON(http.StatusOK, category)
+}
+
+// DeleteCategory removes a category and its budget. Its entries become
+// uncategorized.
+func (h *BudgetsHandler) DeleteCategory(c echo.Context) error {
+	category, err := h.category(c, c.Param("id"))
+	if category == nil {
+		return err
+	}
+
+	if err := h.categories.Delete(category.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete category"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Category deleted successfully"})
+}
+
+// GetEntries lists the user's entries in a month, newest first.
+func (h *BudgetsHandler) GetEntries(c echo.Context) error {
+	month, err := h.month(c)
+	if month == nil {
+		return err
+	}
+
+	entries, err := h.entries.GetBetween(c.Get("user_id").(int), *month, models.NextMonth(*month))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get entries"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(entries, ""))
+}
+
+func (h *BudgetsHandler) CreateEntry(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req CreateEntryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	currency, err := models.NormalizeCurrency(req.Currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
+	}
+	if currency == "" {
+		user, err := h.userRepo.GetByID(userID)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+		}
+		currency = user.BaseCurrency
+	}
+
+	if req.CategoryID != nil {
+		category, err := h.category(c, strconv.Itoa(*req.CategoryID))
+		if category == nil {
+			return err
+		}
+	}
+
+	entry := &models.Entry{
+		UserID:      userID,
+		CategoryID:  req.CategoryID,
+		Type:        req.Type,
+		Amount:      models.NewMoney(req.Amount.Amount, currency),
+		Description: req.Description,
+		OccurredAt:  req.OccurredAt,
+	}
+	if err := h.entries.Create(entry); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create entry"})
+	}
+
+	// Read it back for the category's name
+	created, err := h.entries.GetByID(entry.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get entry"})
+	}
+	return c.JSON(http.StatusCreated, created)
+}
+
+func (h *BudgetsHandler) DeleteEntry(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid entry ID"})
+	}
+
+	entry, err := h.entries.GetByID(id)
+	if errors.Is(err, models.ErrEntryNotFound) || (err == nil && entry.UserID != c.Get("user_id").(int)) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Entry not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get entry"})
+	}
+
+	if err := h.entries.Delete(entry.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete entry"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Entry deleted successfully"})
+}
+
+// GetBudgets reports a month's income and spending against the user's
+// budgets. Budgets that roll over carry what earlier months left, or
+// overspent, into the month.
+func (h *BudgetsHandler) GetBudgets(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+	month, err := h.month(c)
+	if month == nil {
+		return err
+	}
+
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	categories, err := h.categories.GetByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get categories"})
+	}
+	budgets, err := h.budgets.GetByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get budgets"})
+	}
+
+	// Rolling over needs every month since the budget first started
+	from := *month
+	for _, history := range budgets {
+		if b := history.At(*month); b != nil && b.Rollover != models.RolloverNone && history[0].StartsAt.Before(from) {
+			from = history[0].StartsAt
+		}
+	}
+	entries, err := h.entries.GetBetween(userID, from, models.NextMonth(*month))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get entries"})
+	}
+	surplus, err := h.entries.Surplus(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get surplus"})
+	}
+
+	return c.JSON(http.StatusOK, h.budgetReport(*month, user.BaseCurrency, categories, budgets, entries, surplus))
+}
+
+func (h *BudgetsHandler) budgetReport(month time.Time, currency string, categories []models.Category, budgets map[int]models.BudgetHistory, entries []models.Entry, surplus []models.Money) *BudgetReport {
+	report := &BudgetReport{
+		Month:            month.Format("2006-01"),
+		Currency:         currency,
+		Income:           models.NewMoney(0, currency),
+		Expenses:         models.NewMoney(0, currency),
+		AvailableSurplus: models.NewMoney(0, currency),
+		Categories:       make([]BudgetLine, 0),
+	}
+	base := newBaseConverter(h.rates, currency)
+
+	// This month's spending per category in the base currency, keyed 0
+	// for uncategorized, and every month's in the currency of the budget
+	// the category had that month
+	spent := make(map[int]int64)
+	spentByMonth := make(map[int]map[time.Time]int64)
+	for _, e := range entries {
+		if !e.OccurredAt.Before(month) {
+			if amount, ok := base.convert(e.Amount); ok {
+				if e.Type == models.EntryIncome {
+					report.Income = report.Income.Add(amount)
+				} else {
+					report.Expenses = report.Expenses.Add(amount)
+					key := 0
+					if e.CategoryID != nil {
+						key = *e.CategoryID
+					}
+					spent[key] += amount.Amount
+				}
+			}
+		}
+
+		if e.Type != models.EntryExpense || e.CategoryID == nil {
+			continue
+		}
+		b := budgets[*e.CategoryID].At(models.MonthStart(e.OccurredAt))
+		if b == nil {
+			continue
+		}
+		amount, err := toCurrency(h.rates, e.Amount, b.Currency)
+		if err != nil {
+			base.missing[e.Currency] = true
+			continue
+		}
+		if spentByMonth[b.CategoryID] == nil {
+			spentByMonth[b.CategoryID] = make(map[time.Time]int64)
+		}
+		spentByMonth[b.CategoryID][models.MonthStart(e.OccurredAt)] += amount.Amount
+	}
+	report.Surplus = report.Income.Sub(report.Expenses)
+
+	for _, total := range surplus {
+		if amount, ok := base.convert(total); ok {
+			report.AvailableSurplus = report.AvailableSurplus.Add(amount)
+		}
+	}
+
+	for _, category := range categories {
+		b := budgets[category.ID].At(month)
+		if b == nil && spent[category.ID] == 0 {
+			continue
+		}
+
+		id := category.ID
+		line := BudgetLine{CategoryID: &id, Name: category.Name, Spent: models.NewMoney(spent[category.ID], currency)}
+		if b != nil {
+			planned, ok := base.convert(b.Amount)
+			carried, _ := base.convert(budgets[category.ID].CarriedOver(month, spentByMonth[category.ID]))
+			if ok {
+				remaining := planned.Add(carried).Sub(line.Spent)
+				line.Rollover = b.Rollover
+				line.Planned, line.CarriedOver, line.Remaining = &planned, &carried, &remaining
+			}
+		}
+		report.Categories = append(report.Categories, line)
+	}
+	if spent[0] != 0 {
+		report.Categories = append(report.Categories, BudgetLine{
+			Name:  "Uncategorized",
+			Spent: models.NewMoney(spent[0], currency),
+		})
+	}
+	report.MissingRates = base.missingRates()
+	return report
+}
+
+// SetBudget sets or changes the budget of the category named by
+// :category_id from this month on. Earlier months keep the budget they had.
+func (h *BudgetsHandler) SetBudget(c echo.Context) error {
+	category, err := h.category(c, c.Param("category_id"))
+	if category == nil {
+		return err
+	}
+
+	var req BudgetRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	user, err := h.userRepo.GetByID(category.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	budget := &models.Budget{
+		CategoryID: category.ID,
+		UserID:     category.UserID,
+		Amount:     models.NewMoney(req.Amount.Amount, user.BaseCurrency),
+		Rollover:   req.Rollover,
+	}
+	if budget.Rollover == "" {
+		budget.Rollover = models.RolloverNone
+	}
+	if err := h.budgets.Set(budget); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set budget"})
+	}
+
+	return c.JSON(http.StatusOK, budget)
+}
+
+// DeleteBudget removes the category's budget, with its history.
+func (h *BudgetsHandler) DeleteBudget(c echo.Context) error {
+	category, err := h.category(c, c.Param("category_id"))
+	if category == nil {
+		return err
+	}
+
+	if err := h.budgets.Delete(category.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete budget"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Budget deleted successfully"})
+}
+
+// MoveSurplus deposits some of the user's surplus into a goal they may
+// contribute to. The deposit is an ordinary transaction with Source
+// models.SourceSurplus, so it can be voided to return the money to the
+// surplus.
+func (h *BudgetsHandler) MoveSurplus(c echo.Context) error {
+	userID := c.Get("user_id").(int)
+
+	var req MoveSurplusRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	goal, err := h.access.Goal(userID, req.GoalID, access.Contribute)
+	if err != nil {
+		return accessFailed(c, err)
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	transaction := &models.Transaction{
+		UserID:      userID,
+		GoalID:      goal.ID,
+		Amount:      models.NewMoney(req.Amount.Amount, goal.Currency),
+		Description: req.Description,
+		Type:        "add",
+	}
+	if transaction.Description == "" {
+		transaction.Description = "From surplus"
+	}
+
+	// The surplus is compared in the base currency at today's rates
+	amount, err := toCurrency(h.rates, transaction.Amount, user.BaseCurrency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Exchange rate not available"})
+	}
+	available := models.NewMoney(0, user.BaseCurrency)
+	check := func(totals []models.Money) error {
+		for _, total := range totals {
+			converted, err := toCurrency(h.rates, total, user.BaseCurrency)
+			if err != nil {
+				return err
+			}
+			available = available.Add(converted)
+		}
+		if amount.Amount > available.Amount {
+			return models.ErrInsufficientSurplus
+		}
+		return nil
+	}
+
+	if err := h.ledger.MoveSurplus(transaction, check); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientSurplus):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error": "Only " + available.String() + " " + available.Currency + " of surplus is available",
+			})
+		case errors.Is(err, rates.ErrRateNotFound):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Exchange rate not available"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to move surplus"})
+	}
+
+	return c.JSON(http.StatusCreated, transaction)
+}
+
+// category loads the user's category with the given ID. Other users'
+// categories are reported as not found; on any failure it writes the
+// error response and returns a nil category.
+func (h *BudgetsHandler) category(c echo.Context, param string) (*models.Category, error) {
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid category ID"})
+	}
+
+	category, err := h.categories.GetByID(id)
+	if errors.Is(err, models.ErrCategoryNotFound) || (err == nil && category.UserID != c.Get("user_id").(int)) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Category not found"})
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get category"})
+	}
+	return category, nil
+}
+
+// month reads the month query parameter as the first instant of the
+// month, UTC. On failure it writes the error response and returns nil.
+func (h *BudgetsHandler) month(c echo.Context) (*time.Time, error) {
+	var query MonthQuery
+	if err := c.Bind(&query); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+
+	month := models.MonthStart(getCurrentTime())
+	if query.Month != "" {
+		parsed, err := time.Parse("2006-01", query.Month)
+		if err != nil {
+			return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid month, expected YYYY-MM"})
+		}
+		month = parsed
+	}
+	return &month, nil
+}
+
+func categoryFailed(c echo.Context, err error, message string) error {
+	if errors.Is(err, models.ErrCategoryExists) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A category with this name already exists"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/rates"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChildrenHandler manages child accounts and the withdrawals they ask
+// for. A child may do anything with a goal their role allows except take
+// money out: each "remove" they record becomes a withdrawal request, and
+// only a parent approving it moves the money. A "remove" from a locked
+// goal becomes a request too, which waits out the goal's cooling-off
+// period; see jobs.ReleaseWithdrawals.
+type ChildrenHandler struct {
+	userRepo    ChildUserRepository
+	limits      ChildLimitsRepository
+	withdrawals WithdrawalRequestRepository
+	ledger      WithdrawalLedger
+	rates       ExchangeRateProvider
+}
+
+type ChildUserRepository interface {
+	GetByID(id int) (*models.User, error)
+	CreateChild(user *models.User) error
+	GetChildren(parentID int) ([]models.User, error)
+}
+
+type ChildLimitsRepository interface {
+	Get(childID int) (*models.ChildLimits, error)
+	Set(limits *models.ChildLimits) error
+}
+
+type WithdrawalRequestRepository interface {
+	Create(w *models.WithdrawalRequest, since time.Time, check func(totals []models.Money) error) error
+	GetByID(id int) (*models.WithdrawalRequest, error)
+	GetForUser(userID int, status string) ([]models.WithdrawalRequest, error)
+	Reject(w *models.WithdrawalRequest, parentID int, reason string) error
+	Cancel(w *models.WithdrawalRequest) error
+}
+
+type WithdrawalLedger interface {
+	ApproveWithdrawal(w *models.WithdrawalRequest, decidedBy int) (*models.Transaction, error)
+}
+
+type CreateChildRequest struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,min=6"`
+	// Defaults to the parent's base currency
+	BaseCurrency string `json:"base_currency" validate:"omitempty,currency"`
+}
+
+// ChildLimitsRequest replaces a child's limits. Omitted limits are
+// lifted; Currency defaults to the child's base currency.
+type ChildLimitsRequest struct {
+	Currency           string        `json:"currency" validate:"omitempty,currency"`
+	MaxWithdrawal      *models.Money `json:"max_withdrawal"`
+	MonthlyWithdrawals *models.Money `json:"monthly_withdrawals"`
+}
+
+// Child is a child account with the limits set on it, if any.
+type Child struct {
+	models.User
+	Limits *models.ChildLimits `json:"limits"`
+}
+
+type WithdrawalRequestQuery struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending approved rejected cancelled failed"`
+}
+
+type RejectWithdrawalRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type ApproveWithdrawalResponse struct {
+	Request     *models.WithdrawalRequest `json:"request"`
+	Transaction *models.Transaction       `json:"transaction"`
+}
+
+func NewChildrenHandler(userRepo ChildUserRepository, limits ChildLimitsRepository, withdrawals WithdrawalRequestRepository, ledger WithdrawalLedger, rates ExchangeRateProvider) *ChildrenHandler {
+	return &ChildrenHandler{
+		userRepo:    userRepo,
+		limits:      limits,
+		withdrawals: withdrawals,
+		ledger:      ledger,
+		rates:       rates,
+	}
+}
+
+// CreateChild creates an account managed by the user. Children cannot
+// have children of their own.
+func (h *ChildrenHandler) CreateChild(c echo.Context) error {
+	parent, err := h.parent(c)
+	if parent == nil {
+		return err
+	}
+
+	var req CreateChildRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	baseCurrency, err := models.NormalizeCurrency(req.BaseCurrency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
+	}
+	if baseCurrency == "" {
+		baseCurrency = parent.BaseCurrency
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+	}
+
+	child := &models.User{
+		Name:         req.Name,
+		Email:        req.Email,
+		BaseCurrency: baseCurrency,
+		PasswordHash: string(hashedPassword),
+		ParentID:     &parent.ID,
+	}
+	if err := h.userRepo.CreateChild(child); err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "User already exists"})
+	}
+
+	return c.JSON(http.StatusCreated, Child{User: *child})
+}
+
+func (h *ChildrenHandler) GetChildren(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	users, err := h.userRepo.GetChildren(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get children"})
+	}
+
+	children := make([]Child, 0, len(users))
+	for _, user := range users {
+		limits, err := h.limits.Get(user.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get limits"})
+		}
+		children = append(children, Child{User: user, Limits: limits})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(children, ""))
+}
+
+func (h *ChildrenHandler) SetLimits(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	childID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid child ID"})
+	}
+	child, err := h.userRepo.GetByID(childID)
+	if err != nil || child.ParentID == nil || *child.ParentID != userID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Child not found"})
+	}
+
+	var req ChildLimitsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	currency, err := models.NormalizeCurrency(req.Currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
+	}
+	if currency == "" {
+		currency = child.BaseCurrency
+	}
+
+	for _, limit := range []*models.Money{req.MaxWithdrawal, req.MonthlyWithdrawals} {
+		if limit != nil && !limit.IsPositive() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limits must be greater than zero"})
+		}
+	}
+
+	limits := &models.ChildLimits{
+		ChildID:            child.ID,
+		Currency:           currency,
+		MaxWithdrawal:      inCurrency(req.MaxWithdrawal, currency),
+		MonthlyWithdrawals: inCurrency(req.MonthlyWithdrawals, currency),
+	}
+	if err := h.limits.Set(limits); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to set limits"})
+	}
+
+	return c.JSON(http.StatusOK, limits)
+}
+
+// RequestWithdrawal stores a "remove" as a pending withdrawal request
+// instead of recording it. The goal must hold the amount now and a child's
+// limits must allow it; the balance is checked again when the money is
+// released. If the goal is locked, the request waits out its cooling-off
+// period and carries its penalty.
+func (h *ChildrenHandler) RequestWithdrawal(c echo.Context, transaction *models.Transaction, goal *models.Goal) error {
+	if goal.CurrentAmount.Amount < transaction.Amount.Amount {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+	}
+
+	w := &models.WithdrawalRequest{
+		UserID:      transaction.UserID,
+		GoalID:      goal.ID,
+		Amount:      transaction.Amount,
+		Description: transaction.Description,
+	}
+	if now := getCurrentTime(); goal.Locked(now) {
+		availableAt := now.Add(goal.Lock.CoolingOff())
+		w.AvailableAt = &availableAt
+		if penalty := goal.Lock.Penalty(transaction.Amount); penalty.IsPositive() {
+			w.Penalty = &penalty
+			w.PenaltyGoalID = goal.Lock.PenaltyGoalID
+		}
+	}
+
+	// The monthly limit is checked as the request is stored, so that two
+	// requests at once can't both fit under it
+	limits, err := h.limits.Get(transaction.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get limits"})
+	}
+	var exceeded string
+	check := func(totals []models.Money) error {
+		var err error
+		exceeded, err = exceededLimit(h.rates, limits, transaction.Amount, totals)
+		if err == nil && exceeded != "" {
+			return models.ErrWithdrawalLimitExceeded
+		}
+		return err
+	}
+
+	if err := h.withdrawals.Create(w, models.MonthStart(getCurrentTime()), check); err != nil {
+		switch {
+		case errors.Is(err, models.ErrWithdrawalLimitExceeded):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": exceeded})
+		case errors.Is(err, rates.ErrRateNotFound):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Exchange rate not available"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request withdrawal"})
+	}
+
+	// Read it back for the names of the user and the goal
+	created, err := h.withdrawals.GetByID(w.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get withdrawal request"})
+	}
+	return c.JSON(http.StatusAccepted, created)
+}
+
+// exceededLimit describes the first of a child's limits the withdrawal
+// would break, given the totals of their requests this month, or returns ""
+// if it breaks none. Amounts are compared in the limits' currency at
+// today's rates.
+func exceededLimit(provider ExchangeRateProvider, limits *models.ChildLimits, amount models.Money, monthTotals []models.Money) (string, error) {
+	if limits == nil {
+		return "", nil
+	}
+
+	converted, err := toCurrency(provider, amount, limits.Currency)
+	if err != nil {
+		return "", err
+	}
+	if limits.MaxWithdrawal != nil && converted.Amount > limits.MaxWithdrawal.Amount {
+		return "Withdrawal is over the limit of " + limits.MaxWithdrawal.String() + " " + limits.Currency, nil
+	}
+	if limits.MonthlyWithdrawals == nil {
+		return "", nil
+	}
+
+	total := converted
+	for _, t := range monthTotals {
+		m, err := toCurrency(provider, t, limits.Currency)
+		if err != nil {
+			return "", err
+		}
+		total = total.Add(m)
+	}
+	if total.Amount > limits.MonthlyWithdrawals.Amount {
+		return "Withdrawal would go over the monthly limit of " + limits.MonthlyWithdrawals.String() + " " + limits.Currency, nil
+	}
+	return "", nil
+}
+
+// GetWithdrawalRequests lists the user's own requests and those of their
+// children, newest first.
+func (h *ChildrenHandler) GetWithdrawalRequests(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var query WithdrawalRequestQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
+	}
+
+	requests, err := h.withdrawals.GetForUser(userID, query.Status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get withdrawal requests"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(requests, ""))
+}
+
+// ApproveWithdrawal records the withdrawal a child asked for, once any
+// cooling-off period is over.
+func (h *ChildrenHandler) ApproveWithdrawal(c echo.Context) error {
+	w, err := h.withdrawalRequest(c, true)
+	if w == nil {
+		return err
+	}
+
+	transaction, err := h.ledger.ApproveWithdrawal(w, c.Get("user_id").(int))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientFunds):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		case errors.Is(err, models.ErrWithdrawalCoolingOff):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Withdrawal is cooling off until " + w.AvailableAt.UTC().Format(time.RFC3339)})
+		}
+		return withdrawalFailed(c, err, "Failed to approve withdrawal")
+	}
+
+	return c.JSON(http.StatusOK, ApproveWithdrawalResponse{Request: w, Transaction: transaction})
+}
+
+func (h *ChildrenHandler) RejectWithdrawal(c echo.Context) error {
+	w, err := h.withdrawalRequest(c, true)
+	if w == nil {
+		return err
+	}
+
+	var req RejectWithdrawalRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := h.withdrawals.Reject(w, c.Get("user_id").(int), req.Reason); err != nil {
+		return withdrawalFailed(c, err, "Failed to reject withdrawal")
+	}
+
+	return c.JSON(http.StatusOK, w)
+}
+
+// CancelWithdrawal lets the user take back a request that is still
+// pending.
+func (h *ChildrenHandler) CancelWithdrawal(c echo.Context) error {
+	w, err := h.withdrawalRequest(c, false)
+	if w == nil {
+		return err
+	}
+
+	if err := h.withdrawals.Cancel(w); err != nil {
+		return withdrawalFailed(c, err, "Failed to cancel withdrawal")
+	}
+
+	return c.JSON(http.StatusOK, w)
+}
+
+// parent loads the user, who must not be a child account. Otherwise it
+// writes the error response and returns a nil user.
+func (h *ChildrenHandler) parent(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	if user.IsChild() {
+		return nil, c.JSON(http.StatusForbidden, map[string]string{"error": "Child accounts cannot manage children"})
+	}
+	return user, nil
+}
+
+// withdrawalRequest loads the request named by :id. With asParent it must
+// come from one of the user's children, otherwise from the user. A request
+// the user may not act on is reported as not found; on any failure it
+// writes the error response and returns a nil request.
+func (h *ChildrenHandler) withdrawalRequest(c echo.Context, asParent bool) (*models.WithdrawalRequest, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid withdrawal request ID"})
+	}
+
+	w, err := h.withdrawals.GetByID(id)
+	if err != nil {
+		return nil, withdrawalFailed(c, err, "Failed to get withdrawal request")
+	}
+
+	allowed := w.UserID == userID
+	if asParent {
+		child, err := h.userRepo.GetByID(w.UserID)
+		if err != nil {
+			return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get withdrawal request"})
+		}
+		allowed = child.ParentID != nil && *child.ParentID == userID
+	}
+	if !allowed {
+		return nil, withdrawalFailed(c, models.ErrWithdrawalRequestNotFound, "")
+	}
+	return w, nil
+}
+
+// inCurrency returns an optional amount in the currency, or nil.
+func inCurrency(m *models.Money, currency string) *models.Money {
+	if m == nil {
+		return nil
+	}
+	converted := models.NewMoney(m.Amount, currency)
+	return &converted
+}
+
+func withdrawalFailed(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, models.ErrWithdrawalRequestNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Withdrawal request not found"})
+	case errors.Is(err, models.ErrWithdrawalDecided):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Withdrawal request has already been decided"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/export"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+type ExportHandler struct {
+	goalRepo        GoalRepository
+	transactionRepo ExportRepository
+	userRepo        UserRepository
+}
+
+type ExportRepository interface {
+	EachByUserID(userID int, fn func(*models.Transaction) error) error
+}
+
+type ExportQuery struct {
+	Format string `query:"format" validate:"omitempty,oneof=csv json xlsx"` // defaults to json
+}
+
+func NewExportHandler(goalRepo GoalRepository, transactionRepo ExportRepository, userRepo UserRepository) *ExportHandler {
+	return &ExportHandler{
+		goalRepo:        goalRepo,
+		transactionRepo: transactionRepo,
+		userRepo:        userRepo,
+	}
+}
+
+// Export downloads all of the user's goals and transactions. The response
+// is streamed, so once it has started an error can only cut it short; it
+// is logged.
+func (h *ExportHandler) Export(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var query ExportQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
+	}
+	format := export.Format(query.Format)
+	if format == "" {
+		format = export.JSON
+	}
+
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	goals, err := h.goalRepo.GetByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get goals"})
+	}
+	goalRecords := make([]export.GoalRecord, len(goals))
+	for i := range goals {
+		goalRecords[i] = export.NewGoalRecord(&goals[i])
+	}
+
+	now := getCurrentTime()
+	filename := fmt.Sprintf("cashcandy-export-%s.%s", now.Format("2006-01-02"), format)
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, format.ContentType())
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	response.WriteHeader(http.StatusOK)
+
+	transactions := func(fn func(export.TransactionRecord) error) error {
+		return h.transactionRepo.EachByUserID(userID, func(t *models.Transaction) error {
+			return fn(export.NewTransactionRecord(t))
+		})
+	}
+	if err := export.Write(response, format, export.NewMeta(user, now), goalRecords, transactions); err != nil {
+		c.Logger().Errorf("export for user %d failed: %v", userID, err)
+	}
+	return nil
+}
@@ -1,41 +1,70 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
 	"github.com/oleksii-dukh/cashcandy/go-backend/models"
 )
 
 type GoalsHandler struct {
-	goalRepo GoalRepository
+	goalRepo       GoalRepository
+	access         Authorizer
+	trashRetention time.Duration
 }
 
 type GoalRepository interface {
 	Create(goal *models.Goal) error
 	GetByUserID(userID int) ([]models.Goal, error)
+	ListByUserID(userID int, opts models.ListOptions) ([]models.Goal, string, error)
 	GetByID(id int) (*models.Goal, error)
 	Update(goal *models.Goal) error
 	Delete(id int) error
+	GetDeletedByUserID(userID int) ([]models.Goal, error)
+	GetDeletedByID(id int) (*models.Goal, error)
+	Restore(id int) error
+	Purge(id int) error
+	SetLock(id int, lock *models.GoalLock) error
+}
+
+// TrashedGoal is a deleted goal, which can be restored until PurgeAt.
+type TrashedGoal struct {
+	models.Goal
+	PurgeAt time.Time `json:"purge_at"`
 }
 
 type CreateGoalRequest struct {
-	Title        string    `json:"title" validate:"required"`
-	TargetAmount float64   `json:"target_amount" validate:"required,min=0.01"`
-	Deadline     time.Time `json:"deadline" validate:"required"`
+	Title        string       `json:"title" validate:"required,max=100"`
+	TargetAmount models.Money `json:"target_amount" validate:"required,gt=0"`
+	Currency     string       `json:"currency" validate:"omitempty,currency"` // defaults to USD
+	Deadline     time.Time    `json:"deadline" validate:"required,future"`
 }
 
 type UpdateGoalRequest struct {
-	Title        string    `json:"title"`
-	TargetAmount float64   `json:"target_amount" validate:"min=0.01"`
-	Deadline     time.Time `json:"deadline"`
+	Title        string       `json:"title" validate:"omitempty,max=100"`
+	TargetAmount models.Money `json:"target_amount" validate:"omitempty,gt=0"`
+	Deadline     time.Time    `json:"deadline" validate:"omitempty,future"`
+}
+
+// GoalLockRequest locks a goal until its deadline or target. A penalty
+// needs another goal in the same currency, which the user may contribute
+// to, to go to.
+type GoalLockRequest struct {
+	Until           string `json:"until" validate:"required,oneof=deadline target"`
+	CoolingOffHours int    `json:"cooling_off_hours" validate:"required,gt=0,lte=8760"`
+	PenaltyBps      int    `json:"penalty_bps" validate:"gte=0,lt=10000"` // hundredths of a percent
+	PenaltyGoalID   *int   `json:"penalty_goal_id"`
 }
 
-func NewGoalsHandler(goalRepo GoalRepository) *GoalsHandler {
+func NewGoalsHandler(goalRepo GoalRepository, authorizer Authorizer, trashRetention time.Duration) *GoalsHandler {
 	return &GoalsHandler{
-		goalRepo: goalRepo,
+		goalRepo:       goalRepo,
+		access:         authorizer,
+		trashRetention: trashRetention,
 	}
 }
 
@@ -49,12 +78,24 @@ func (h *GoalsHandler) CreateGoal(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	currency, err := models.NormalizeCurrency(req.Currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
+	}
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
 
 	goal := &models.Goal{
 		UserID:        userID,
 		Title:         req.Title,
-		TargetAmount:  req.TargetAmount,
-		CurrentAmount: 0,
+		Currency:      currency,
+		TargetAmount:  models.NewMoney(req.TargetAmount.Amount, currency),
+		CurrentAmount: models.NewMoney(0, currency),
 		Deadline:      req.Deadline,
 	}
 
@@ -71,12 +112,20 @@ func (h *GoalsHandler) GetGoals(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
 	}
 
-	goals, err := h.goalRepo.GetByUserID(userID)
+	var query GoalListQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
+	}
+
+	goals, next, err := h.goalRepo.ListByUserID(userID, query.options())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get goals"})
+		return listFailed(c, err, "Failed to get goals")
 	}
 
-	return c.JSON(http.StatusOK, goals)
+	return c.JSON(http.StatusOK, listResponse(goals, next))
 }
 
 func (h *GoalsHandler) GetGoal(c echo.Context) error {
@@ -90,14 +139,9 @@ func (h *GoalsHandler) GetGoal(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
 	}
 
-	goal, err := h.goalRepo.GetByID(goalID)
+	goal, err := h.access.Goal(userID, goalID, access.View)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
-	}
-
-	// Check if goal belongs to the user
-	if goal.UserID != userID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+		return accessFailed(c, err)
 	}
 
 	return c.JSON(http.StatusOK, goal)
@@ -114,27 +158,35 @@ func (h *GoalsHandler) UpdateGoal(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
 	}
 
-	goal, err := h.goalRepo.GetByID(goalID)
+	goal, err := h.access.Goal(userID, goalID, access.Manage)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
-	}
-
-	// Check if goal belongs to the user
-	if goal.UserID != userID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+		return accessFailed(c, err)
 	}
 
 	var req UpdateGoalRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	// Moving the goalposts must not open a locked goal early
+	if goal.Locked(getCurrentTime()) {
+		switch {
+		case goal.Lock.Until == models.LockUntilDeadline && !req.Deadline.IsZero() && req.Deadline.Before(goal.Deadline):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Goal is locked until its deadline, which cannot be brought forward"})
+		case goal.Lock.Until == models.LockUntilTarget && req.TargetAmount.IsPositive() && req.TargetAmount.Amount < goal.TargetAmount.Amount:
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Goal is locked until it reaches its target, which cannot be lowered"})
+		}
+	}
 
 	// Update only provided fields
 	if req.Title != "" {
 		goal.Title = req.Title
 	}
-	if req.TargetAmount > 0 {
-		goal.TargetAmount = req.TargetAmount
+	if req.TargetAmount.IsPositive() {
+		goal.TargetAmount = models.NewMoney(req.TargetAmount.Amount, goal.Currency)
 	}
 	if !req.Deadline.IsZero() {
 		goal.Deadline = req.Deadline
@@ -158,19 +210,175 @@ func (h *GoalsHandler) DeleteGoal(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
 	}
 
-	goal, err := h.goalRepo.GetByID(goalID)
+	goal, err := h.access.Goal(userID, goalID, access.Manage)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		return accessFailed(c, err)
 	}
-
-	// Check if goal belongs to the user
-	if goal.UserID != userID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+	if goal.Locked(getCurrentTime()) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Locked goals cannot be deleted"})
 	}
 
 	if err := h.goalRepo.Delete(goalID); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete goal"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Goal deleted successfully"})
+	return c.JSON(http.StatusOK, map[string]string{"message": "Goal moved to trash"})
+}
+
+// SetLock locks the goal or replaces its lock.
+func (h *GoalsHandler) SetLock(c echo.Context) error {
+	goal, err := h.unlockedGoal(c)
+	if goal == nil {
+		return err
+	}
+
+	var req GoalLockRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	lock := &models.GoalLock{
+		Until:           req.Until,
+		CoolingOffHours: req.CoolingOffHours,
+		PenaltyBps:      req.PenaltyBps,
+	}
+	if req.PenaltyBps > 0 {
+		if req.PenaltyGoalID == nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "A penalty needs a penalty_goal_id to go to"})
+		}
+		if *req.PenaltyGoalID == goal.ID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Penalty must go to another goal"})
+		}
+		penaltyGoal, err := h.access.Goal(c.Get("user_id").(int), *req.PenaltyGoalID, access.Contribute)
+		if err != nil {
+			return accessFailed(c, err)
+		}
+		if penaltyGoal.Currency != goal.Currency {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Penalty goal must be in " + goal.Currency})
+		}
+		lock.PenaltyGoalID = &penaltyGoal.ID
+	}
+
+	if err := h.goalRepo.SetLock(goal.ID, lock); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to lock goal"})
+	}
+
+	goal.Lock = lock
+	return c.JSON(http.StatusOK, goal)
+}
+
+func (h *GoalsHandler) RemoveLock(c echo.Context) error {
+	goal, err := h.unlockedGoal(c)
+	if goal == nil {
+		return err
+	}
+
+	if err := h.goalRepo.SetLock(goal.ID, nil); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlock goal"})
+	}
+
+	goal.Lock = nil
+	return c.JSON(http.StatusOK, goal)
+}
+
+// unlockedGoal loads the goal named by the :id parameter to change its
+// lock, which takes access.Manage and must wait until the lock is no
+// longer in force. Otherwise it writes the error response and returns a
+// nil goal.
+func (h *GoalsHandler) unlockedGoal(c echo.Context) (*models.Goal, error) {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return nil, err
+	}
+	if goal.Locked(getCurrentTime()) {
+		return nil, c.JSON(http.StatusConflict, map[string]string{"error": "Goal is locked; its lock can be changed once it opens"})
+	}
+	return goal, nil
+}
+
+func (h *GoalsHandler) GetTrash(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	goals, err := h.goalRepo.GetDeletedByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get trash"})
+	}
+
+	trash := make([]TrashedGoal, 0, len(goals))
+	for _, goal := range goals {
+		purgeAt := goal.DeletedAt.Add(h.trashRetention)
+		// The purge job may not have caught up yet
+		if !purgeAt.After(getCurrentTime()) {
+			continue
+		}
+		trash = append(trash, TrashedGoal{Goal: goal, PurgeAt: purgeAt})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(trash, ""))
+}
+
+func (h *GoalsHandler) RestoreGoal(c echo.Context) error {
+	goal, err := h.trashedGoal(c)
+	if goal == nil {
+		return err
+	}
+
+	if err := h.goalRepo.Restore(goal.ID); err != nil {
+		if errors.Is(err, models.ErrGoalNotInTrash) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found in trash"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restore goal"})
+	}
+
+	goal.DeletedAt = nil
+	return c.JSON(http.StatusOK, goal)
+}
+
+// PurgeGoal deletes a goal in the trash for good, with its transactions,
+// without waiting for the retention period to end.
+func (h *GoalsHandler) PurgeGoal(c echo.Context) error {
+	goal, err := h.trashedGoal(c)
+	if goal == nil {
+		return err
+	}
+
+	if err := h.goalRepo.Purge(goal.ID); err != nil {
+		if errors.Is(err, models.ErrGoalNotInTrash) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found in trash"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete goal"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Goal deleted permanently"})
+}
+
+// trashedGoal loads the goal in the trash named by the :id parameter. It
+// returns a nil goal, after writing the error response, unless the user
+// owns the goal and it is still within the retention period.
+func (h *GoalsHandler) trashedGoal(c echo.Context) (*models.Goal, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	goalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
+	}
+
+	goal, err := h.goalRepo.GetDeletedByID(goalID)
+	if err != nil || !goal.DeletedAt.Add(h.trashRetention).After(getCurrentTime()) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found in trash"})
+	}
+
+	if err := h.access.Check(userID, goal, access.Manage); err != nil {
+		return nil, accessFailed(c, err)
+	}
+	return goal, nil
 }
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// maxHistoryPeriods caps how many periods one history request covers.
+const maxHistoryPeriods = 400
+
+// HistoryQuery selects the range of GET /api/stats/history. From and To
+// take a date (2006-01-02) or an RFC 3339 time, and a date in To includes
+// that whole day. They default to the last 30 days, 12 weeks or 12 months
+// depending on the granularity, which defaults to day.
+type HistoryQuery struct {
+	Granularity string `query:"granularity" validate:"omitempty,oneof=day week month"`
+	From        string `query:"from" validate:"omitempty,date"`
+	To          string `query:"to" validate:"omitempty,date"`
+}
+
+// HistoryPoint is one period of a series. Period is its first day in UTC.
+type HistoryPoint struct {
+	Period  string       `json:"period"`
+	Net     models.Money `json:"net"`     // contributions minus withdrawals
+	Balance models.Money `json:"balance"` // at the end of the period
+}
+
+type GoalHistory struct {
+	GoalID   int            `json:"goal_id"`
+	Title    string         `json:"title"`
+	Currency string         `json:"currency"`
+	Points   []HistoryPoint `json:"points"`
+}
+
+// HistoryResponse has one point per period from From up to To, including
+// periods without transactions. From is moved back to the start of its
+// period. Total converts every goal into BaseCurrency at today's rates,
+// leaving out goals in the MissingRates currencies.
+type HistoryResponse struct {
+	Granularity  string         `json:"granularity"`
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	BaseCurrency string         `json:"base_currency"`
+	Total        []HistoryPoint `json:"total"`
+	Goals        []GoalHistory  `json:"goals"`
+	MissingRates []string       `json:"missing_rates"`
+}
+
+func (h *StatsHandler) GetHistory(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var query HistoryQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
+	}
+
+	granularity := query.Granularity
+	if granularity == "" {
+		granularity = "day"
+	}
+	from, to := query.bounds(granularity, getCurrentTime())
+	if !from.Before(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be before to"})
+	}
+	periods := historyPeriods(granularity, from, to)
+	if len(periods) > maxHistoryPeriods {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Range too long for this granularity"})
+	}
+
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	goals, err := h.goalRepo.GetByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get goals"})
+	}
+
+	buckets, err := h.transactionRepo.History(userID, granularity, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get history"})
+	}
+
+	response := h.buildHistory(goals, buckets, periods, user.BaseCurrency)
+	response.Granularity = granularity
+	response.From = periodStart(granularity, from)
+	response.To = to
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *StatsHandler) buildHistory(goals []models.Goal, buckets []models.HistoryBucket, periods []string, baseCurrency string) HistoryResponse {
+	response := HistoryResponse{
+		BaseCurrency: baseCurrency,
+		Total:        make([]HistoryPoint, len(periods)),
+		Goals:        make([]GoalHistory, 0, len(goals)),
+	}
+	for i, period := range periods {
+		response.Total[i] = HistoryPoint{
+			Period:  period,
+			Net:     models.NewMoney(0, baseCurrency),
+			Balance: models.NewMoney(0, baseCurrency),
+		}
+	}
+
+	base := newBaseConverter(h.rates, baseCurrency)
+	byGoal := make(map[int][]models.HistoryBucket)
+	for _, b := range buckets {
+		byGoal[b.GoalID] = append(byGoal[b.GoalID], b)
+	}
+
+	sort.Slice(goals, func(i, j int) bool { return goals[i].ID < goals[j].ID })
+	for _, goal := range goals {
+		series := goalSeries(byGoal[goal.ID], periods, goal.Currency)
+		response.Goals = append(response.Goals, GoalHistory{
+			GoalID:   goal.ID,
+			Title:    goal.Title,
+			Currency: goal.Currency,
+			Points:   series,
+		})
+
+		for i, point := range series {
+			net, ok := base.convert(point.Net)
+			if !ok {
+				// Without a rate the goal is left out of the total
+				break
+			}
+			balance, _ := base.convert(point.Balance)
+			response.Total[i].Net = response.Total[i].Net.Add(net)
+			response.Total[i].Balance = response.Total[i].Balance.Add(balance)
+		}
+	}
+	response.MissingRates = base.missingRates()
+	return response
+}
+
+// goalSeries spreads one goal's buckets, sorted by period, over periods,
+// carrying the balance through periods without transactions.
+func goalSeries(buckets []models.HistoryBucket, periods []string, currency string) []HistoryPoint {
+	points := make([]HistoryPoint, len(periods))
+	var balance int64
+	next := 0
+	for i, period := range periods {
+		var net int64
+		// Buckets before the first period only set the opening balance
+		for next < len(buckets) && buckets[next].Period <= period {
+			if buckets[next].Period == period {
+				net = buckets[next].Net
+			}
+			balance = buckets[next].Balance
+			next++
+		}
+		points[i] = HistoryPoint{
+			Period:  period,
+			Net:     models.NewMoney(net, currency),
+			Balance: models.NewMoney(balance, currency),
+		}
+	}
+	return points
+}
+
+// bounds resolves the requested range, defaulting to the recent past.
+func (q HistoryQuery) bounds(granularity string, now time.Time) (time.Time, time.Time) {
+	to := now
+	if q.To != "" {
+		var dateOnly bool
+		to, dateOnly = parseDateOrTime(q.To)
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+
+	if q.From != "" {
+		from, _ := parseDateOrTime(q.From)
+		return from, to
+	}
+	switch granularity {
+	case "week":
+		return to.AddDate(0, 0, -12*7), to
+	case "month":
+		return to.AddDate(0, -12, 0), to
+	default:
+		return to.AddDate(0, 0, -30), to
+	}
+}
+
+// historyPeriods lists the first day of every period overlapping
+// [from, to), the same way database.Dialect.DateBucket labels them.
+func historyPeriods(granularity string, from, to time.Time) []string {
+	periods := []string{}
+	for start := periodStart(granularity, from); start.Before(to); {
+		periods = append(periods, start.Format("2006-01-02"))
+		if len(periods) > maxHistoryPeriods {
+			break
+		}
+		switch granularity {
+		case "week":
+			start = start.AddDate(0, 0, 7)
+		case "month":
+			start = start.AddDate(0, 1, 0)
+		default:
+			start = start.AddDate(0, 0, 1)
+		}
+	}
+	return periods
+}
+
+// periodStart is the first instant, in UTC, of the period containing t.
+func periodStart(granularity string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
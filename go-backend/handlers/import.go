@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/importer"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// maxImportSize is the largest file POST /api/import accepts.
+const maxImportSize = 5 << 20
+
+type ImportHandler struct {
+	access          Authorizer
+	transactionRepo ImportRepository
+	ledger          ImportLedger
+}
+
+type ImportRepository interface {
+	CountImportHashes(goalID int) (map[string]int, error)
+}
+
+type ImportLedger interface {
+	RecordAll(transactions []*models.Transaction) error
+}
+
+// ImportRequest holds the form fields sent along with the file. Format
+// defaults to the file's extension. Mapping is a JSON importer.Mapping and
+// only applies to CSV. With DryRun nothing is recorded.
+type ImportRequest struct {
+	GoalID  int    `form:"goal_id" validate:"required,gt=0"`
+	Format  string `form:"format" validate:"omitempty,oneof=csv ofx qfx"`
+	Mapping string `form:"mapping"`
+	DryRun  bool   `form:"dry_run"`
+}
+
+// ImportRow describes what happens to one record of the file.
+type ImportRow struct {
+	Line        int           `json:"line"`
+	Date        *time.Time    `json:"date,omitempty"`
+	Amount      *models.Money `json:"amount,omitempty"`
+	Type        string        `json:"type,omitempty"`
+	Description string        `json:"description"`
+	Status      string        `json:"status"` // new, duplicate or error
+	Error       string        `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	DryRun     bool        `json:"dry_run"`
+	Imported   int         `json:"imported"` // or would be, in a dry run
+	Duplicates int         `json:"duplicates"`
+	Errors     int         `json:"errors"`
+	Rows       []ImportRow `json:"rows"`
+}
+
+func NewImportHandler(authorizer Authorizer, transactionRepo ImportRepository, ledger ImportLedger) *ImportHandler {
+	return &ImportHandler{
+		access:          authorizer,
+		transactionRepo: transactionRepo,
+		ledger:          ledger,
+	}
+}
+
+// Import records the contributions in an uploaded CSV or OFX/QFX file on
+// a goal. Rows already imported to the goal, judged by date, amount and
+// description, are skipped. If any row cannot be read the whole import is
+// rejected; otherwise every new row is recorded in one database
+// transaction, so an import is never half done.
+func (h *ImportHandler) Import(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var req ImportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A file is required"})
+	}
+	if file.Size > maxImportSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "File is too large"})
+	}
+
+	goal, err := h.access.Goal(userID, req.GoalID, access.Contribute)
+	if err != nil {
+		return accessFailed(c, err)
+	}
+
+	format := req.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read file"})
+	}
+	defer src.Close()
+
+	var records []importer.Record
+	switch format {
+	case "csv":
+		var mapping importer.Mapping
+		if req.Mapping != "" {
+			if err := json.Unmarshal([]byte(req.Mapping), &mapping); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid mapping"})
+			}
+		}
+		records, err = importer.ParseCSV(src, mapping)
+	case "ofx", "qfx":
+		records, err = importer.ParseOFX(src)
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown file format, use csv, ofx or qfx"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read file: " + err.Error()})
+	}
+
+	existing, err := h.transactionRepo.CountImportHashes(goal.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check for duplicates"})
+	}
+
+	response, transactions := planImport(records, userID, goal, existing)
+	response.DryRun = req.DryRun
+	if response.Errors > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+	for _, transaction := range transactions {
+		if transaction.Type == "remove" {
+			if err := h.access.Check(userID, goal, access.Withdraw); err != nil {
+				return accessFailed(c, err)
+			}
+			if err := h.access.TakeOut(userID, goal.ID); err != nil {
+				return accessFailed(c, err)
+			}
+			break
+		}
+	}
+	if req.DryRun || len(transactions) == 0 {
+		return c.JSON(http.StatusOK, response)
+	}
+
+	if err := h.ledger.RecordAll(transactions); err != nil {
+		if errors.Is(err, models.ErrInsufficientFunds) {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Withdrawals in the file exceed the goal balance"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to import transactions"})
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+// planImport decides what happens to each record and builds the
+// transactions for the new ones, oldest first. A hash already on the goal
+// n times marks the first n matching records of the file as duplicates,
+// so identical rows within one file are all imported, but only once.
+func planImport(records []importer.Record, userID int, goal *models.Goal, existing map[string]int) (ImportResponse, []*models.Transaction) {
+	response := ImportResponse{Rows: make([]ImportRow, 0, len(records))}
+	transactions := []*models.Transaction{}
+	seen := make(map[string]int)
+
+	for _, record := range records {
+		row := ImportRow{Line: record.Line, Description: record.Description}
+
+		err := record.Err
+		switch {
+		case err != nil:
+		case record.Currency != "" && record.Currency != goal.Currency:
+			err = errors.New("currency " + record.Currency + " does not match the goal's " + goal.Currency)
+		case record.Amount == 0:
+			err = errors.New("amount must not be zero")
+		}
+		if err != nil {
+			row.Status = "error"
+			row.Error = err.Error()
+			response.Errors++
+			response.Rows = append(response.Rows, row)
+			continue
+		}
+
+		transaction := &models.Transaction{
+			UserID:      userID,
+			GoalID:      goal.ID,
+			Amount:      models.NewMoney(record.Amount, goal.Currency),
+			Description: record.Description,
+			Type:        "add",
+			ImportHash:  record.Hash(),
+			CreatedAt:   record.Date,
+		}
+		if record.Amount < 0 {
+			transaction.Amount = transaction.Amount.Neg()
+			transaction.Type = "remove"
+		}
+		row.Date = &transaction.CreatedAt
+		row.Amount = &transaction.Amount
+		row.Type = transaction.Type
+
+		seen[transaction.ImportHash]++
+		if seen[transaction.ImportHash] <= existing[transaction.ImportHash] {
+			row.Status = "duplicate"
+			response.Duplicates++
+		} else {
+			row.Status = "new"
+			response.Imported++
+			transactions = append(transactions, transaction)
+		}
+		response.Rows = append(response.Rows, row)
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+	return response, transactions
+}
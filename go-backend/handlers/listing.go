@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// ListResponse is one page of a listing. NextCursor is null on the last
+// page; otherwise it is passed back as ?cursor= to get the next one.
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor *string     `json:"next_cursor"`
+}
+
+// ListQuery holds the query parameters shared by all listings. Sort names
+// a column, prefixed with "-" for descending order; the default is
+// "-created_at". From and To take a date (2006-01-02) or an RFC 3339 time,
+// and a date in To includes that whole day.
+type ListQuery struct {
+	Limit     int    `query:"limit" validate:"omitempty,min=1,max=200"`
+	Cursor    string `query:"cursor"`
+	From      string `query:"from" validate:"omitempty,date"`
+	To        string `query:"to" validate:"omitempty,date"`
+	MinAmount string `query:"min_amount" validate:"omitempty,amount"`
+	MaxAmount string `query:"max_amount" validate:"omitempty,amount"`
+	Search    string `query:"q" validate:"max=100"`
+}
+
+type GoalListQuery struct {
+	ListQuery
+	Sort string `query:"sort" validate:"omitempty,oneof=created_at -created_at deadline -deadline target_amount -target_amount current_amount -current_amount title -title"`
+}
+
+type TransactionListQuery struct {
+	ListQuery
+	Sort string `query:"sort" validate:"omitempty,oneof=created_at -created_at amount -amount"`
+	Type string `query:"type" validate:"omitempty,oneof=add remove"`
+}
+
+func (q GoalListQuery) options() models.ListOptions {
+	return q.listOptions(q.Sort)
+}
+
+func (q TransactionListQuery) options() models.ListOptions {
+	opts := q.listOptions(q.Sort)
+	opts.Type = q.Type
+	return opts
+}
+
+// listOptions converts validated query parameters into repository options.
+func (q ListQuery) listOptions(sort string) models.ListOptions {
+	opts := models.ListOptions{
+		Limit:  q.Limit,
+		Cursor: q.Cursor,
+		Search: strings.TrimSpace(q.Search),
+	}
+	if sort != "" {
+		opts.SortBy = strings.TrimPrefix(sort, "-")
+		opts.Desc = strings.HasPrefix(sort, "-")
+	}
+
+	if q.From != "" {
+		opts.CreatedFrom, _ = parseDateOrTime(q.From)
+	}
+	if q.To != "" {
+		to, dateOnly := parseDateOrTime(q.To)
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		opts.CreatedTo = to
+	}
+	if q.MinAmount != "" {
+		minAmount, _ := models.ParseMoney(q.MinAmount, "")
+		opts.MinAmount = &minAmount.Amount
+	}
+	if q.MaxAmount != "" {
+		maxAmount, _ := models.ParseMoney(q.MaxAmount, "")
+		opts.MaxAmount = &maxAmount.Amount
+	}
+	return opts
+}
+
+func parseDateOrTime(s string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	t, _ := time.Parse(time.RFC3339, s)
+	return t, false
+}
+
+func listResponse(items interface{}, next string) ListResponse {
+	response := ListResponse{Items: items}
+	if next != "" {
+		response.NextCursor = &next
+	}
+	return response
+}
+
+// listFailed writes the response for an error from a repository listing.
+func listFailed(c echo.Context, err error, message string) error {
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/mailer"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+type MembersHandler struct {
+	access         Authorizer
+	memberRepo     GoalMemberRepository
+	invitationRepo GoalInvitationRepository
+	totals         MemberTotals
+	userRepo       MemberUserRepository
+	mailer         Mailer
+	appURL         string
+}
+
+type GoalMemberRepository interface {
+	GetByGoalID(goalID int) ([]models.GoalMember, error)
+	UpdateRole(goalID, userID int, role string) error
+	Remove(goalID, userID int) error
+}
+
+type GoalInvitationRepository interface {
+	Create(inv *models.GoalInvitation) error
+	GetByID(id int) (*models.GoalInvitation, error)
+	GetPendingByGoalID(goalID int, now time.Time) ([]models.GoalInvitation, error)
+	GetPendingByEmail(email string, now time.Time) ([]models.GoalInvitation, error)
+	Accept(inv *models.GoalInvitation, userID int) error
+	Decline(inv *models.GoalInvitation) error
+	Revoke(inv *models.GoalInvitation) error
+}
+
+type MemberTotals interface {
+	TotalsByMember(goalID int) (map[int]models.MemberTotal, error)
+}
+
+type MemberUserRepository interface {
+	GetByID(id int) (*models.User, error)
+}
+
+// Member is a goal member with what they have put in and taken out, in
+// the goal's currency.
+type Member struct {
+	models.GoalMember
+	Added     models.Money `json:"added"`
+	Withdrawn models.Money `json:"withdrawn"`
+	Net       models.Money `json:"net"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner contributor viewer"`
+}
+
+type InvitationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=owner contributor viewer"`
+}
+
+func NewMembersHandler(authorizer Authorizer, memberRepo GoalMemberRepository, invitationRepo GoalInvitationRepository, totals MemberTotals, userRepo MemberUserRepository, mailer Mailer, appURL string) *MembersHandler {
+	return &MembersHandler{
+		access:         authorizer,
+		memberRepo:     memberRepo,
+		invitationRepo: invitationRepo,
+		totals:         totals,
+		userRepo:       userRepo,
+		mailer:         mailer,
+		appURL:         strings.TrimRight(appURL, "/"),
+	}
+}
+
+// GetMembers lists who shares the goal and how much each of them has
+// contributed.
+func (h *MembersHandler) GetMembers(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.View)
+	if goal == nil {
+		return err
+	}
+
+	members, err := h.memberRepo.GetByGoalID(goal.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get members"})
+	}
+	totals, err := h.totals.TotalsByMember(goal.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get members"})
+	}
+
+	items := make([]Member, 0, len(members))
+	for _, m := range members {
+		total := totals[m.UserID]
+		items = append(items, Member{
+			GoalMember: m,
+			Added:      models.NewMoney(total.Added, goal.Currency),
+			Withdrawn:  models.NewMoney(total.Withdrawn, goal.Currency),
+			Net:        models.NewMoney(total.Added-total.Withdrawn, goal.Currency),
+		})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(items, ""))
+}
+
+func (h *MembersHandler) UpdateMember(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return err
+	}
+
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var req UpdateMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := h.memberRepo.UpdateRole(goal.ID, memberID, req.Role); err != nil {
+		return memberChangeFailed(c, err, "Failed to update member")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Member updated"})
+}
+
+// RemoveMember takes a member off the goal. Owners can remove anyone and
+// every member can leave.
+func (h *MembersHandler) RemoveMember(c echo.Context) error {
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	perm := access.Manage
+	if userID, _ := c.Get("user_id").(int); memberID == userID {
+		perm = access.View
+	}
+	goal, err := authorizedGoal(c, h.access, perm)
+	if goal == nil {
+		return err
+	}
+
+	if err := h.memberRepo.Remove(goal.ID, memberID); err != nil {
+		return memberChangeFailed(c, err, "Failed to remove member")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Member removed"})
+}
+
+func memberChangeFailed(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, models.ErrMemberNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Member not found"})
+	case errors.Is(err, models.ErrLastOwner):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The goal must keep at least one owner"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
+
+// CreateInvitation emails an invitation to join the goal. The address need
+// not belong to an account yet.
+func (h *MembersHandler) CreateInvitation(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return err
+	}
+
+	var req InvitationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	inviter, err := h.userRepo.GetByID(c.Get("user_id").(int))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	inv := &models.GoalInvitation{
+		GoalID:      goal.ID,
+		GoalTitle:   goal.Title,
+		InviterID:   inviter.ID,
+		InviterName: inviter.Name,
+		Email:       strings.ToLower(req.Email),
+		Role:        req.Role,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	}
+	if err := h.invitationRepo.Create(inv); err != nil {
+		switch {
+		case errors.Is(err, models.ErrAlreadyMember):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "This person is already a member of the goal"})
+		case errors.Is(err, models.ErrInvitationPending):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "An invitation to this address is already pending"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create invitation"})
+	}
+
+	// The invitation also shows up in the app, so it stands even if the
+	// email cannot be sent
+	err = h.mailer.Send(mailer.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("%s invited you to save for %s", inviter.Name, goal.Title),
+		Body: fmt.Sprintf("Hi,\n\n%s has invited you to join the savings goal \"%s\" on CashCandy as a %s.\n\n"+
+			"Sign in, or create an account with this email address, to accept or decline:\n\n%s\n\n"+
+			"The invitation expires in 7 days.\n",
+			inviter.Name, goal.Title, inv.Role, h.appURL+"/invitations"),
+	})
+	if err != nil {
+		c.Logger().Errorf("failed to send invitation %d: %v", inv.ID, err)
+	}
+
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// GetInvitations lists the goal's pending invitations.
+func (h *MembersHandler) GetInvitations(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return err
+	}
+
+	invitations, err := h.invitationRepo.GetPendingByGoalID(goal.ID, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get invitations"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(invitations, ""))
+}
+
+func (h *MembersHandler) RevokeInvitation(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return err
+	}
+
+	invitationID, err := strconv.Atoi(c.Param("invitation_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid invitation ID"})
+	}
+
+	inv, err := h.invitationRepo.GetByID(invitationID)
+	if err == nil && inv.GoalID == goal.ID {
+		err = h.invitationRepo.Revoke(inv)
+	} else if err == nil {
+		err = models.ErrInvitationNotFound
+	}
+	if err != nil {
+		return invitationFailed(c, err, "Failed to revoke invitation")
+	}
+
+	return c.JSON(http.StatusOK, inv)
+}
+
+// GetMyInvitations lists the pending invitations to the user's email
+// address.
+func (h *MembersHandler) GetMyInvitations(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	invitations, err := h.invitationRepo.GetPendingByEmail(strings.ToLower(user.Email), time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get invitations"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(invitations, ""))
+}
+
+// AcceptInvitation makes the user a member of the goal they were invited
+// to.
+func (h *MembersHandler) AcceptInvitation(c echo.Context) error {
+	inv, user, err := h.myInvitation(c)
+	if inv == nil {
+		return err
+	}
+
+	if err := h.invitationRepo.Accept(inv, user.ID); err != nil {
+		return invitationFailed(c, err, "Failed to accept invitation")
+	}
+
+	return c.JSON(http.StatusOK, inv)
+}
+
+func (h *MembersHandler) DeclineInvitation(c echo.Context) error {
+	inv, _, err := h.myInvitation(c)
+	if inv == nil {
+		return err
+	}
+
+	if err := h.invitationRepo.Decline(inv); err != nil {
+		return invitationFailed(c, err, "Failed to decline invitation")
+	}
+
+	return c.JSON(http.StatusOK, inv)
+}
+
+// myInvitation loads the invitation named by :id. Only the account with
+// the invited email address may answer it; to everyone else it does not
+// exist. On failure it writes the error response and returns a nil
+// invitation.
+func (h *MembersHandler) myInvitation(c echo.Context) (*models.GoalInvitation, *models.User, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	invitationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid invitation ID"})
+	}
+
+	inv, err := h.invitationRepo.GetByID(invitationID)
+	if err == nil && inv.Email != strings.ToLower(user.Email) {
+		err = models.ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, nil, invitationFailed(c, err, "Failed to get invitation")
+	}
+	return inv, user, nil
+}
+
+func invitationFailed(c echo.Context, err error, message string) error {
+	if errors.Is(err, models.ErrInvitationNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Invitation not found or already answered"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
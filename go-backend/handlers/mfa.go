@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer = "CashCandy"
+
+	mfaChallengeTTL     = 5 * time.Minute
+	mfaChallengeSubject = "mfa"
+	// Wrong codes allowed per challenge before the user has to log in again
+	maxMFAAttempts = 5
+
+	recoveryCodeCount = 10
+	recoveryPurpose   = "recovery_code"
+)
+
+var errInvalidSecondFactor = errors.New("invalid authentication code")
+
+type RecoveryCodeRepository interface {
+	Replace(userID int, codeHashes []string) error
+	Use(userID int, codeHash string) error
+	CountUnused(userID int) (int, error)
+	DeleteByUserID(userID int) error
+}
+
+// MFAChallengeResponse is returned by Login instead of an AuthResponse when
+// the account has two-factor authentication enabled. The token is
+// exchanged for a session at /api/auth/login/mfa.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type DisableMFARequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type EnrollMFAResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+	// QRPayload is the text to encode in the QR code the user scans
+	QRPayload string `json:"qr_payload"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+func (h *AuthHandler) GetMFAStatus(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	remaining, err := h.recoveryRepo.CountUnused(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, MFAStatusResponse{
+		Enabled:                user.IsTOTPEnabled(),
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+// EnrollMFA starts two-factor enrollment by generating a secret. Nothing
+// changes at login until the user proves their app works via ConfirmMFA.
+func (h *AuthHandler) EnrollMFA(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate secret"})
+	}
+
+	err = h.userRepo.SetTOTPSecret(user.ID, secret)
+	if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is already enabled"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start enrollment"})
+	}
+
+	uri := totp.ProvisioningURI(secret, totpIssuer, user.Email)
+	return c.JSON(http.StatusOK, EnrollMFAResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRPayload:       uri,
+	})
+}
+
+// ConfirmMFA enables two-factor authentication once the user enters a
+// valid code, and returns their recovery codes. This is the only time the
+// codes are shown.
+func (h *AuthHandler) ConfirmMFA(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var req MFACodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if user.IsTOTPEnabled() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is already enabled"})
+	}
+	if user.TOTPSecret == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Enrollment has not been started"})
+	}
+
+	if err := h.verifyTOTP(user, req.Code); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid authentication code"})
+	}
+	if err := h.userRepo.EnableTOTP(user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to enable two-factor authentication"})
+	}
+
+	codes, err := h.newRecoveryCodes(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA turns two-factor authentication off. It needs both the
+// password and a current code, so a stolen session alone is not enough.
+func (h *AuthHandler) DisableMFA(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var req DisableMFARequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if !user.IsTOTPEnabled() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is not enabled"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+	}
+	if err := h.verifySecondFactor(user, req.Code); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid authentication code"})
+	}
+
+	if err := h.userRepo.DisableTOTP(user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to disable two-factor authentication"})
+	}
+	if err := h.recoveryRepo.DeleteByUserID(user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, for example after
+// the user has used most of them.
+func (h *AuthHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var req MFACodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if !user.IsTOTPEnabled() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Two-factor authentication is not enabled"})
+	}
+	if err := h.verifyTOTP(user, req.Code); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid authentication code"})
+	}
+
+	codes, err := h.newRecoveryCodes(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// LoginMFA completes a login that Login answered with an MFA challenge.
+// It accepts either a code from the authenticator app or a recovery code.
+func (h *AuthHandler) LoginMFA(c echo.Context) error {
+	var req MFALoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	claims, err := h.parseMFAChallenge(req.MFAToken)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired MFA token"})
+	}
+	if !h.mfaAttempts.allow(claims.ID, claims.ExpiresAt.Time) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "MFA token is no longer valid, log in again"})
+	}
+
+	user, err := h.userRepo.GetByID(claims.UserID)
+	if err != nil || !user.IsTOTPEnabled() {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired MFA token"})
+	}
+	if err := h.verifySecondFactor(user, req.Code); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid authentication code"})
+	}
+	// A challenge completes one login only
+	h.mfaAttempts.exhaust(claims.ID)
+
+	response, err := h.startSession(c, user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *AuthHandler) currentUser(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, errors.New("missing user")
+	}
+	return h.userRepo.GetByID(userID)
+}
+
+// verifySecondFactor accepts a TOTP code or, failing that, an unused
+// recovery code.
+func (h *AuthHandler) verifySecondFactor(user *models.User, code string) error {
+	if err := h.verifyTOTP(user, code); err == nil {
+		return nil
+	}
+
+	err := h.recoveryRepo.Use(user.ID, h.signToken(recoveryPurpose, normalizeRecoveryCode(code)))
+	if errors.Is(err, models.ErrRecoveryCodeInvalid) {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+func (h *AuthHandler) verifyTOTP(user *models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	err := h.userRepo.UseTOTPStep(user.ID, step)
+	if errors.Is(err, models.ErrTOTPCodeReused) {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+// newRecoveryCodes replaces the user's recovery codes with a fresh set
+// and returns them in plain text, formatted as xxxxx-xxxxx.
+func (h *AuthHandler) newRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b)[:10])
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = h.signToken(recoveryPurpose, raw)
+	}
+
+	if err := h.recoveryRepo.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+type mfaChallengeClaims struct {
+	UserID int `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// mfaChallenge is issued after a correct password for an account with
+// two-factor authentication. It has no session, so JWTMiddleware never
+// accepts it as an access token.
+func (h *AuthHandler) mfaChallenge(user *models.User) (*MFAChallengeResponse, error) {
+	id, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	claims := &mfaChallengeClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   mfaChallengeSubject,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.jwtKey)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallengeResponse{MFARequired: true, MFAToken: token, ExpiresAt: expiresAt}, nil
+}
+
+func (h *AuthHandler) parseMFAChallenge(tokenString string) (*mfaChallengeClaims, error) {
+	claims := &mfaChallengeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return h.jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithSubject(mfaChallengeSubject), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid MFA token")
+	}
+	return claims, nil
+}
+
+// attemptLimiter counts code attempts per MFA challenge so the six digit
+// code cannot be brute forced within the challenge's lifetime. Entries are
+// kept until the challenge expires.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]attempt
+}
+
+type attempt struct {
+	count     int
+	expiresAt time.Time
+}
+
+func (l *attemptLimiter) allow(key string, expiresAt time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.attempts == nil {
+		l.attempts = make(map[string]attempt)
+	}
+	for k, a := range l.attempts {
+		if now.After(a.expiresAt) {
+			delete(l.attempts, k)
+		}
+	}
+
+	a := l.attempts[key]
+	if a.count >= maxMFAAttempts {
+		return false
+	}
+	l.attempts[key] = attempt{count: a.count + 1, expiresAt: expiresAt}
+	return true
+}
+
+func (l *attemptLimiter) exhaust(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a := l.attempts[key]
+	a.count = maxMFAAttempts
+	l.attempts[key] = a
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// RulesHandler manages the match and interest rules that pay bonuses into
+// a goal. The ledger pays matches as deposits land and the jobs package
+// pays interest each month. Setting up rules takes access.Manage, and
+// child accounts cannot, as they would be paying themselves.
+type RulesHandler struct {
+	ruleRepo GoalRuleRepository
+	access   Authorizer
+}
+
+type GoalRuleRepository interface {
+	Create(rule *models.GoalRule) error
+	GetByID(id int) (*models.GoalRule, error)
+	GetByGoalID(goalID int) ([]models.GoalRule, error)
+	Update(rule *models.GoalRule) error
+	Delete(id int) error
+}
+
+// RuleRequest sets a rule's rate, in hundredths of a percent (5000 is
+// 50%), and its optional cap per calendar month in the goal's currency.
+// Updating a rule replaces both.
+type RuleRequest struct {
+	RateBps    int           `json:"rate_bps" validate:"required,gt=0,lte=10000"`
+	MonthlyCap *models.Money `json:"monthly_cap"`
+}
+
+type CreateRuleRequest struct {
+	Kind string `json:"kind" validate:"required,oneof=match interest"`
+	RuleRequest
+}
+
+func NewRulesHandler(ruleRepo GoalRuleRepository, authorizer Authorizer) *RulesHandler {
+	return &RulesHandler{
+		ruleRepo: ruleRepo,
+		access:   authorizer,
+	}
+}
+
+func (h *RulesHandler) GetRules(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.View)
+	if goal == nil {
+		return err
+	}
+
+	rules, err := h.ruleRepo.GetByGoalID(goal.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get rules"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(rules, ""))
+}
+
+func (h *RulesHandler) CreateRule(c echo.Context) error {
+	goal, err := h.sponsoredGoal(c)
+	if goal == nil {
+		return err
+	}
+
+	var req CreateRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	rule := &models.GoalRule{
+		GoalID: goal.ID,
+		UserID: c.Get("user_id").(int),
+		Kind:   req.Kind,
+	}
+	if err := applyRuleRequest(rule, &req.RuleRequest, goal); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := h.ruleRepo.Create(rule); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create rule"})
+	}
+
+	return c.JSON(http.StatusCreated, rule)
+}
+
+func (h *RulesHandler) GetRule(c echo.Context) error {
+	rule, _, err := h.authorizedRule(c, false)
+	if rule == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rule)
+}
+
+func (h *RulesHandler) UpdateRule(c echo.Context) error {
+	rule, goal, err := h.authorizedRule(c, true)
+	if rule == nil {
+		return err
+	}
+
+	var req RuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := applyRuleRequest(rule, &req, goal); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := h.ruleRepo.Update(rule); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update rule"})
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule stops a rule. What it has paid stays in the goal.
+func (h *RulesHandler) DeleteRule(c echo.Context) error {
+	rule, _, err := h.authorizedRule(c, true)
+	if rule == nil {
+		return err
+	}
+
+	if err := h.ruleRepo.Delete(rule.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete rule"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Rule deleted successfully"})
+}
+
+// applyRuleRequest copies req into rule.
+func applyRuleRequest(rule *models.GoalRule, req *RuleRequest, goal *models.Goal) error {
+	rule.RateBps = req.RateBps
+	rule.MonthlyCap = nil
+	if req.MonthlyCap != nil {
+		if !req.MonthlyCap.IsPositive() {
+			return errors.New("monthly_cap must be greater than zero")
+		}
+		limit := models.NewMoney(req.MonthlyCap.Amount, goal.Currency)
+		rule.MonthlyCap = &limit
+	}
+	return nil
+}
+
+// sponsoredGoal loads the goal named by :id if the user may set up rules
+// on it: they need access.Manage and must not be a child account.
+// Otherwise it writes the error response and returns a nil goal.
+func (h *RulesHandler) sponsoredGoal(c echo.Context) (*models.Goal, error) {
+	goal, err := authorizedGoal(c, h.access, access.Manage)
+	if goal == nil {
+		return nil, err
+	}
+
+	err = h.access.Supervised(c.Get("user_id").(int))
+	if errors.Is(err, access.ErrApprovalRequired) {
+		return nil, c.JSON(http.StatusForbidden, map[string]string{"error": "Child accounts cannot set up rules"})
+	}
+	if err != nil {
+		return nil, accessFailed(c, err)
+	}
+	return goal, nil
+}
+
+// authorizedRule loads the rule named by :rule_id on the goal named by
+// :id. Members who may see the goal may see its rules; with edit, the
+// user must be allowed to set up rules on it. On failure it writes the
+// error response and returns a nil rule.
+func (h *RulesHandler) authorizedRule(c echo.Context, edit bool) (*models.GoalRule, *models.Goal, error) {
+	var (
+		goal *models.Goal
+		err  error
+	)
+	if edit {
+		goal, err = h.sponsoredGoal(c)
+	} else {
+		goal, err = authorizedGoal(c, h.access, access.View)
+	}
+	if goal == nil {
+		return nil, nil, err
+	}
+
+	ruleID, err := strconv.Atoi(c.Param("rule_id"))
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid rule ID"})
+	}
+
+	rule, err := h.ruleRepo.GetByID(ruleID)
+	if errors.Is(err, models.ErrRuleNotFound) || (err == nil && rule.GoalID != goal.ID) {
+		return nil, nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Rule not found"})
+	}
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get rule"})
+	}
+	return rule, goal, nil
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/schedule"
+)
+
+// SchedulesHandler manages standing orders on a goal. The jobs package
+// turns their occurrences into transactions.
+type SchedulesHandler struct {
+	scheduleRepo ScheduleRepository
+	access       Authorizer
+}
+
+type ScheduleRepository interface {
+	Create(s *models.RecurringContribution) error
+	GetByID(id int) (*models.RecurringContribution, error)
+	GetByGoalID(goalID int) ([]models.RecurringContribution, error)
+	Update(s *models.RecurringContribution) error
+	Delete(id int) error
+}
+
+// ScheduleRequest creates or replaces a schedule. Amount is in the goal's
+// currency. StartAt defaults to now; occurrences before now are never
+// run when a schedule is created, changed or resumed.
+type ScheduleRequest struct {
+	Amount      models.Money `json:"amount" validate:"required,gt=0"`
+	Description string       `json:"description" validate:"max=500"`
+	Rule        string       `json:"rule" validate:"required,rrule"`
+	StartAt     time.Time    `json:"start_at"`
+	EndAt       *time.Time   `json:"end_at"`
+}
+
+func NewSchedulesHandler(scheduleRepo ScheduleRepository, authorizer Authorizer) *SchedulesHandler {
+	return &SchedulesHandler{
+		scheduleRepo: scheduleRepo,
+		access:       authorizer,
+	}
+}
+
+func (h *SchedulesHandler) GetSchedules(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.View)
+	if goal == nil {
+		return err
+	}
+
+	schedules, err := h.scheduleRepo.GetByGoalID(goal.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get schedules"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(schedules, ""))
+}
+
+func (h *SchedulesHandler) CreateSchedule(c echo.Context) error {
+	goal, err := authorizedGoal(c, h.access, access.Contribute)
+	if goal == nil {
+		return err
+	}
+
+	var req ScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	s := &models.RecurringContribution{
+		UserID: goal.UserID,
+		GoalID: goal.ID,
+	}
+	if err := applyScheduleRequest(s, &req, goal, time.Now()); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := h.scheduleRepo.Create(s); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create schedule"})
+	}
+
+	return c.JSON(http.StatusCreated, s)
+}
+
+func (h *SchedulesHandler) GetSchedule(c echo.Context) error {
+	s, _, err := h.authorizedSchedule(c, false)
+	if s == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func (h *SchedulesHandler) UpdateSchedule(c echo.Context) error {
+	s, goal, err := h.authorizedSchedule(c, true)
+	if s == nil {
+		return err
+	}
+
+	var req ScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if err := applyScheduleRequest(s, &req, goal, time.Now()); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := h.scheduleRepo.Update(s); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update schedule"})
+	}
+
+	return c.JSON(http.StatusOK, s)
+}
+
+func (h *SchedulesHandler) DeleteSchedule(c echo.Context) error {
+	s, _, err := h.authorizedSchedule(c, true)
+	if s == nil {
+		return err
+	}
+
+	if err := h.scheduleRepo.Delete(s.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete schedule"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Schedule deleted successfully"})
+}
+
+func (h *SchedulesHandler) PauseSchedule(c echo.Context) error {
+	s, _, err := h.authorizedSchedule(c, true)
+	if s == nil {
+		return err
+	}
+
+	if !s.IsPaused() {
+		now := time.Now()
+		s.PausedAt = &now
+		if err := h.scheduleRepo.Update(s); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to pause schedule"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, s)
+}
+
+// ResumeSchedule restarts a paused schedule from now on. Occurrences that
+// fell in the paused period are skipped, not caught up.
+func (h *SchedulesHandler) ResumeSchedule(c echo.Context) error {
+	s, _, err := h.authorizedSchedule(c, true)
+	if s == nil {
+		return err
+	}
+
+	if s.IsPaused() {
+		rule, err := schedule.Parse(s.Rule)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Schedule has an invalid rule"})
+		}
+		s.PausedAt = nil
+		s.LastError = ""
+		s.NextRunAt = firstRun(rule, s, time.Now())
+		if err := h.scheduleRepo.Update(s); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to resume schedule"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, s)
+}
+
+// applyScheduleRequest copies req into s and works out its next run.
+func applyScheduleRequest(s *models.RecurringContribution, req *ScheduleRequest, goal *models.Goal, now time.Time) error {
+	rule, err := schedule.Parse(req.Rule)
+	if err != nil {
+		return err
+	}
+
+	start := req.StartAt
+	if start.IsZero() {
+		start = now
+	}
+	if req.EndAt != nil && !req.EndAt.After(start) {
+		return errors.New("end_at must be after start_at")
+	}
+
+	s.Amount = models.NewMoney(req.Amount.Amount, goal.Currency)
+	s.Currency = goal.Currency
+	s.Description = req.Description
+	s.Rule = rule.String()
+	s.StartAt = start
+	s.EndAt = req.EndAt
+	s.NextRunAt = firstRun(rule, s, now)
+	return nil
+}
+
+// firstRun is the first occurrence of s at or after both its start and
+// now, or nil if there is none left.
+func firstRun(rule schedule.Rule, s *models.RecurringContribution, now time.Time) *time.Time {
+	from := s.StartAt
+	if now.After(from) {
+		from = now
+	}
+	return rule.NextRun(s.StartAt, s.EndAt, s.RunCount, from.Add(-time.Nanosecond))
+}
+
+// authorizedSchedule loads the schedule named by :schedule_id on the goal
+// named by :id. Members who may see the goal may see its schedules; with
+// edit, changing a schedule takes access.Contribute, and access.Manage as
+// well for someone else's. On failure it writes the error response and
+// returns a nil schedule.
+func (h *SchedulesHandler) authorizedSchedule(c echo.Context, edit bool) (*models.RecurringContribution, *models.Goal, error) {
+	goal, err := authorizedGoal(c, h.access, access.View)
+	if goal == nil {
+		return nil, nil, err
+	}
+
+	scheduleID, err := strconv.Atoi(c.Param("schedule_id"))
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid schedule ID"})
+	}
+
+	s, err := h.scheduleRepo.GetByID(scheduleID)
+	if errors.Is(err, models.ErrScheduleNotFound) || (err == nil && s.GoalID != goal.ID) {
+		return nil, nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Schedule not found"})
+	}
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get schedule"})
+	}
+
+	if edit {
+		userID := c.Get("user_id").(int)
+		if _, err := h.access.Record(userID, goal.ID, s.UserID, access.Contribute); err != nil {
+			return nil, nil, accessFailed(c, err)
+		}
+	}
+	return s, goal, nil
+}
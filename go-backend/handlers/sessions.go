@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	GetByRefreshTokenHash(hash string) (*models.Session, error)
+	Rotate(session *models.Session, newHash string, expiresAt time.Time) error
+	ListActiveByUserID(userID int) ([]models.Session, error)
+	Revoke(userID int, publicID string) error
+	RevokeAllByUserID(userID int) error
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once; presenting one that was
+// already rotated means it leaked, so the whole session is revoked.
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	session, err := h.sessionRepo.GetByRefreshTokenHash(hashToken(req.RefreshToken))
+	if errors.Is(err, models.ErrRefreshTokenReused) {
+		h.sessionRepo.Revoke(session.UserID, session.PublicID)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Refresh token reuse detected, session revoked"})
+	}
+	if err != nil || !session.IsActive(time.Now()) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+	}
+
+	user, err := h.userRepo.GetByID(session.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+	}
+
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+	if err := h.sessionRepo.Rotate(session, hashToken(refreshToken), time.Now().Add(refreshTokenTTL)); err != nil {
+		// Another request rotated the same token first
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid refresh token"})
+	}
+
+	response, err := h.issueTokens(user, session, refreshToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the session the request was authenticated with.
+func (h *AuthHandler) Logout(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	sessionID, _ := c.Get("session_id").(string)
+
+	if err := h.sessionRepo.Revoke(userID, sessionID); err != nil && !errors.Is(err, models.ErrSessionNotFound) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to log out"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+func (h *AuthHandler) GetSessions(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+	currentID, _ := c.Get("session_id").(string)
+
+	sessions, err := h.sessionRepo.ListActiveByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get sessions"})
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.PublicID == currentID,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession signs out one device, for example a lost phone.
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	err := h.sessionRepo.Revoke(userID, c.Param("id"))
+	if errors.Is(err, models.ErrSessionNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session revoked successfully"})
+}
+
+// startSession records a new session for the device making the request
+// and issues its first pair of tokens.
+func (h *AuthHandler) startSession(c echo.Context, user *models.User) (*AuthResponse, error) {
+	publicID, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	device := c.Request().Header.Get("X-Device-Name")
+	if device == "" {
+		device = c.Request().UserAgent()
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		PublicID:         publicID,
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refreshToken),
+		Device:           device,
+		IPAddress:        c.RealIP(),
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(refreshTokenTTL),
+	}
+	if err := h.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+
+	return h.issueTokens(user, session, refreshToken)
+}
+
+func (h *AuthHandler) issueTokens(user *models.User, session *models.Session, refreshToken string) (*AuthResponse, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
+	token, err := h.generateToken(user.ID, session.PublicID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+		User:         *user,
+	}, nil
+}
+
+// newRandomToken returns 32 random bytes, URL-safe base64 encoded.
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how opaque tokens are stored: only the SHA-256 digest is
+// kept, so a database leak does not hand out working tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
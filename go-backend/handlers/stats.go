@@ -1,39 +1,64 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/forecast"
 	"github.com/oleksii-dukh/cashcandy/go-backend/models"
 )
 
 type StatsHandler struct {
 	goalRepo        GoalRepository
 	transactionRepo TransactionRepository
+	userRepo        UserRepository
+	rates           ExchangeRateProvider
 }
 
 type DashboardStats struct {
-	TotalSavings     float64                `json:"total_savings"`
-	TotalGoals       int                    `json:"total_goals"`
-	CompletedGoals   int                    `json:"completed_goals"`
-	AverageProgress  float64                `json:"average_progress"`
-	RecentGoals      []models.Goal          `json:"recent_goals"`
+	TotalSavings       models.Money         `json:"total_savings"`     // in BaseCurrency
+	TotalContributed   models.Money         `json:"total_contributed"` // in BaseCurrency, excluding transfers and earned money
+	TotalEarned        models.Money         `json:"total_earned"`      // in BaseCurrency, paid in by goal rules
+	BaseCurrency       string               `json:"base_currency"`
+	TotalGoals         int                  `json:"total_goals"`
+	CompletedGoals     int                  `json:"completed_goals"`
+	AverageProgress    float64              `json:"average_progress"`
+	RecentGoals        []models.Goal        `json:"recent_goals"`
 	RecentTransactions []models.Transaction `json:"recent_transactions"`
-	GoalProgress     []GoalProgressStats    `json:"goal_progress"`
+	GoalProgress       []GoalProgressStats  `json:"goal_progress"`
+	// Currencies left out of the totals for want of an exchange rate
+	MissingRates []string `json:"missing_rates"`
 }
 
 type GoalProgressStats struct {
 	Goal          models.Goal `json:"goal"`
-	Progress      float64     `json:"progress"`      // percentage (0-100)
+	Progress      float64     `json:"progress"` // percentage (0-100)
 	DaysRemaining int         `json:"days_remaining"`
 	IsCompleted   bool        `json:"is_completed"`
+
+	// Money put in by members and paid in by goal rules, in the goal's
+	// currency
+	Deposited models.Money `json:"deposited"`
+	Earned    models.Money `json:"earned"`
+
+	// Forecast from recent contributions, in the goal's currency
+	DailyVelocity       models.Money    `json:"daily_velocity"`
+	ProjectedCompletion *time.Time      `json:"projected_completion"`
+	RequiredDaily       models.Money    `json:"required_daily"`
+	RequiredWeekly      models.Money    `json:"required_weekly"`
+	RequiredMonthly     models.Money    `json:"required_monthly"`
+	Status              forecast.Status `json:"status"` // completed, on_track, at_risk or behind
 }
 
-func NewStatsHandler(goalRepo GoalRepository, transactionRepo TransactionRepository) *StatsHandler {
+func NewStatsHandler(goalRepo GoalRepository, transactionRepo TransactionRepository, userRepo UserRepository, rates ExchangeRateProvider) *StatsHandler {
 	return &StatsHandler{
 		goalRepo:        goalRepo,
 		transactionRepo: transactionRepo,
+		userRepo:        userRepo,
+		rates:           rates,
 	}
 }
 
@@ -43,6 +68,11 @@ func (h *StatsHandler) GetDashboardStats(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
 	}
 
+	user, err := h.userRepo.GetByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
 	// Get all user goals
 	goals, err := h.goalRepo.GetByUserID(userID)
 	if err != nil {
@@ -56,39 +86,75 @@ func (h *StatsHandler) GetDashboardStats(c echo.Context) error {
 	}
 
 	// Calculate statistics
-	stats := h.calculateStats(goals, transactions)
-
-	return c.JSON(http.StatusOK, stats)
+	return c.JSON(http.StatusOK, h.calculateStats(goals, transactions, user.BaseCurrency))
 }
 
-func (h *StatsHandler) calculateStats(goals []models.Goal, transactions []models.Transaction) DashboardStats {
+func (h *StatsHandler) calculateStats(goals []models.Goal, transactions []models.Transaction, baseCurrency string) DashboardStats {
 	stats := DashboardStats{
-		RecentGoals:      make([]models.Goal, 0),
+		BaseCurrency:       baseCurrency,
+		RecentGoals:        make([]models.Goal, 0),
 		RecentTransactions: make([]models.Transaction, 0),
-		GoalProgress:     make([]GoalProgressStats, 0),
+		GoalProgress:       make([]GoalProgressStats, 0),
 	}
 
-	totalSavings := 0.0
+	base := newBaseConverter(h.rates, baseCurrency)
+	totalSavings := models.NewMoney(0, baseCurrency)
 	totalProgress := 0.0
 	completedGoals := 0
 
+	// Contribution history per goal for the forecasts
+	history := make(map[int][]forecast.Contribution)
+	totalContributed := models.NewMoney(0, baseCurrency)
+	totalEarned := models.NewMoney(0, baseCurrency)
+	deposited := make(map[int]int64)
+	earned := make(map[int]int64)
+	for _, t := range transactions {
+		if !t.Counted() {
+			continue
+		}
+		if t.Type == "add" && t.TransferID == nil {
+			amount, ok := base.convert(t.Amount)
+			if t.Earned() {
+				if ok {
+					totalEarned = totalEarned.Add(amount)
+				}
+				earned[t.GoalID] += t.Amount.Amount
+			} else {
+				if ok {
+					totalContributed = totalContributed.Add(amount)
+				}
+				deposited[t.GoalID] += t.Amount.Amount
+			}
+		}
+
+		amount := t.Amount.Amount
+		if t.Type == "remove" {
+			amount = -amount
+		}
+		history[t.GoalID] = append(history[t.GoalID], forecast.Contribution{Amount: amount, At: t.CreatedAt})
+	}
+
 	// Process each goal
 	for _, goal := range goals {
-		totalSavings += goal.CurrentAmount
-		
-		// Calculate progress percentage
+		// Goals may be in different currencies, so convert each balance
+		// into the base currency before adding it up
+		if balance, ok := base.convert(goal.CurrentAmount); ok {
+			totalSavings = totalSavings.Add(balance)
+		}
+
+		// Calculate progress percentage from exact minor units
 		progress := 0.0
-		if goal.TargetAmount > 0 {
-			progress = (goal.CurrentAmount / goal.TargetAmount) * 100
+		if goal.TargetAmount.IsPositive() {
+			progress = float64(goal.CurrentAmount.Amount) * 100 / float64(goal.TargetAmount.Amount)
 			if progress > 100 {
 				progress = 100
 			}
 		}
-		
+
 		totalProgress += progress
-		
+
 		// Check if goal is completed
-		isCompleted := goal.CurrentAmount >= goal.TargetAmount
+		isCompleted := goal.CurrentAmount.Amount >= goal.TargetAmount.Amount
 		if isCompleted {
 			completedGoals++
 		}
@@ -99,12 +165,28 @@ func (h *StatsHandler) calculateStats(goals []models.Goal, transactions []models
 			daysRemaining = 0
 		}
 
+		outlook := forecast.Compute(forecast.Goal{
+			Target:    goal.TargetAmount.Amount,
+			Current:   goal.CurrentAmount.Amount,
+			CreatedAt: goal.CreatedAt,
+			Deadline:  goal.Deadline,
+			History:   history[goal.ID],
+		}, getCurrentTime())
+
 		// Add to goal progress stats
 		goalProgressStats := GoalProgressStats{
-			Goal:          goal,
-			Progress:      progress,
-			DaysRemaining: daysRemaining,
-			IsCompleted:   isCompleted,
+			Goal:                goal,
+			Progress:            progress,
+			DaysRemaining:       daysRemaining,
+			IsCompleted:         isCompleted,
+			Deposited:           models.NewMoney(deposited[goal.ID], goal.Currency),
+			Earned:              models.NewMoney(earned[goal.ID], goal.Currency),
+			DailyVelocity:       models.NewMoney(int64(math.Round(outlook.Velocity)), goal.Currency),
+			ProjectedCompletion: outlook.ProjectedCompletion,
+			RequiredDaily:       models.NewMoney(outlook.RequiredDaily, goal.Currency),
+			RequiredWeekly:      models.NewMoney(outlook.RequiredWeekly, goal.Currency),
+			RequiredMonthly:     models.NewMoney(outlook.RequiredMonthly, goal.Currency),
+			Status:              outlook.Status,
 		}
 		stats.GoalProgress = append(stats.GoalProgress, goalProgressStats)
 	}
@@ -116,8 +198,11 @@ func (h *StatsHandler) calculateStats(goals []models.Goal, transactions []models
 
 	// Set basic stats
 	stats.TotalSavings = totalSavings
+	stats.TotalContributed = totalContributed
+	stats.TotalEarned = totalEarned
 	stats.TotalGoals = len(goals)
 	stats.CompletedGoals = completedGoals
+	stats.MissingRates = base.missingRates()
 
 	// Get recent goals (last 5)
 	if len(goals) > 5 {
@@ -136,6 +221,52 @@ func (h *StatsHandler) calculateStats(goals []models.Goal, transactions []models
 	return stats
 }
 
+// toCurrency converts m into currency at today's rate.
+func toCurrency(rates ExchangeRateProvider, m models.Money, currency string) (models.Money, error) {
+	if m.Currency == currency {
+		return m, nil
+	}
+	rate, err := rates.Rate(m.Currency, currency, getCurrentTime())
+	if err != nil {
+		return models.Money{}, err
+	}
+	converted, _, err := m.Convert(rate, currency)
+	return converted, err
+}
+
+// baseConverter converts amounts into a base currency at today's rates.
+// Amounts in a currency without a rate are left out of totals instead of
+// failing the whole response, and the currency is reported as missing.
+type baseConverter struct {
+	rates    ExchangeRateProvider
+	currency string
+	missing  map[string]bool
+}
+
+func newBaseConverter(rates ExchangeRateProvider, currency string) *baseConverter {
+	return &baseConverter{rates: rates, currency: currency, missing: make(map[string]bool)}
+}
+
+// convert returns m in the base currency, or false if there is no rate.
+func (b *baseConverter) convert(m models.Money) (models.Money, bool) {
+	converted, err := toCurrency(b.rates, m, b.currency)
+	if err != nil {
+		b.missing[m.Currency] = true
+		return models.Money{}, false
+	}
+	return converted, true
+}
+
+// missingRates lists the currencies convert had no rate for, sorted.
+func (b *baseConverter) missingRates() []string {
+	missing := make([]string, 0, len(b.missing))
+	for currency := range b.missing {
+		missing = append(missing, currency)
+	}
+	sort.Strings(missing)
+	return missing
+}
+
 // Helper function to get current time (can be mocked for testing)
 func getCurrentTime() time.Time {
 	return time.Now()
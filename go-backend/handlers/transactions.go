@@ -1,36 +1,92 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"math/big"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
 	"github.com/oleksii-dukh/cashcandy/go-backend/models"
 )
 
 type TransactionsHandler struct {
 	transactionRepo TransactionRepository
-	goalRepo        GoalRepository
+	eventRepo       TransactionEventRepository
+	access          Authorizer
+	ledger          Ledger
+	rates           ExchangeRateProvider
+	withdrawals     WithdrawalRequester
 }
 
 type TransactionRepository interface {
 	Create(transaction *models.Transaction) error
+	GetByID(id int) (*models.Transaction, error)
+	GetByTransferID(transferID int) ([]models.Transaction, error)
 	GetByGoalID(goalID int) ([]models.Transaction, error)
 	GetByUserID(userID int) ([]models.Transaction, error)
-	GetTotalByGoalID(goalID int) (float64, error)
+	ListByGoalID(goalID int, opts models.ListOptions) ([]models.Transaction, string, error)
+	ListByUserID(userID int, opts models.ListOptions) ([]models.Transaction, string, error)
+	GetTotalByGoalID(goalID int) (models.Money, error)
+	History(userID int, granularity string, to time.Time) ([]models.HistoryBucket, error)
+}
+
+type TransactionEventRepository interface {
+	GetByTransactionID(transactionID int) ([]models.TransactionEvent, error)
+}
+
+type Ledger interface {
+	Record(transaction *models.Transaction) error
+	Amend(transaction *models.Transaction, userID int, amount models.Money, description string) (*models.TransactionEvent, error)
+	Void(transaction *models.Transaction, userID int) ([]*models.Transaction, error)
+}
+
+// WithdrawalRequester turns a child's "remove" into a request for a
+// parent to approve; see ChildrenHandler.
+type WithdrawalRequester interface {
+	RequestWithdrawal(c echo.Context, transaction *models.Transaction, goal *models.Goal) error
+}
+
+type ExchangeRateProvider interface {
+	Rate(from, to string, at time.Time) (*big.Rat, error)
 }
 
 type CreateTransactionRequest struct {
-	GoalID      int     `json:"goal_id" validate:"required"`
-	Amount      float64 `json:"amount" validate:"required,min=0.01"`
-	Description string  `json:"description"`
-	Type        string  `json:"type" validate:"required,oneof=add remove"`
+	GoalID      int          `json:"goal_id" validate:"required,gt=0"`
+	Amount      models.Money `json:"amount" validate:"required,gt=0"`
+	Currency    string       `json:"currency" validate:"omitempty,currency"` // defaults to the goal's currency
+	Description string       `json:"description" validate:"max=500"`
+	Type        string       `json:"type" validate:"required,oneof=add remove"`
 }
 
-func NewTransactionsHandler(transactionRepo TransactionRepository, goalRepo GoalRepository) *TransactionsHandler {
+// AmendTransactionRequest corrects a transaction. Omitted fields keep
+// their value.
+type AmendTransactionRequest struct {
+	Amount      models.Money `json:"amount" validate:"omitempty,gt=0"` // in the goal's currency
+	Description *string      `json:"description" validate:"omitempty,max=500"`
+}
+
+type VoidTransactionResponse struct {
+	Transaction *models.Transaction   `json:"transaction"`
+	Reversals   []*models.Transaction `json:"reversals"` // one per voided transaction
+}
+
+type TransactionHistoryResponse struct {
+	Transaction *models.Transaction       `json:"transaction"`
+	Events      []models.TransactionEvent `json:"events"`
+}
+
+func NewTransactionsHandler(transactionRepo TransactionRepository, eventRepo TransactionEventRepository, authorizer Authorizer, ledger Ledger, rates ExchangeRateProvider, withdrawals WithdrawalRequester) *TransactionsHandler {
 	return &TransactionsHandler{
 		transactionRepo: transactionRepo,
-		goalRepo:        goalRepo,
+		eventRepo:       eventRepo,
+		access:          authorizer,
+		ledger:          ledger,
+		rates:           rates,
+		withdrawals:     withdrawals,
 	}
 }
 
@@ -44,45 +100,67 @@ func (h *TransactionsHandler) CreateTransaction(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
-
-	// Verify goal exists and belongs to user
-	goal, err := h.goalRepo.GetByID(req.GoalID)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
 	}
 
-	if goal.UserID != userID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+	// Children may ask to take money out wherever their role would let
+	// them, but a parent decides. Taking money out of a locked goal waits
+	// out its cooling-off period.
+	goal, err := h.access.Goal(userID, req.GoalID, transactionPermission(req.Type))
+	needsApproval := errors.Is(err, access.ErrApprovalRequired)
+	if needsApproval {
+		goal, err = h.access.Goal(userID, req.GoalID, access.View)
+	}
+	if err != nil {
+		return accessFailed(c, err)
 	}
 
-	// Check if removing money doesn't make current amount negative
-	if req.Type == "remove" && goal.CurrentAmount < req.Amount {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+	currency, err := models.NormalizeCurrency(req.Currency)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid currency"})
 	}
 
 	transaction := &models.Transaction{
 		UserID:      userID,
 		GoalID:      req.GoalID,
-		Amount:      req.Amount,
+		Amount:      models.NewMoney(req.Amount.Amount, goal.Currency),
 		Description: req.Description,
 		Type:        req.Type,
 	}
 
-	if err := h.transactionRepo.Create(transaction); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create transaction"})
+	// Contributions in another currency are converted into the goal's
+	// currency at today's rate, and the rate is recorded with the transaction
+	if currency != "" && currency != goal.Currency {
+		rate, err := h.rates.Rate(currency, goal.Currency, time.Now())
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Exchange rate not available"})
+		}
+
+		original := models.NewMoney(req.Amount.Amount, currency)
+		transaction.Amount, transaction.ExchangeRate, err = original.Convert(rate, goal.Currency)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Amount too large to convert"})
+		}
+		transaction.OriginalAmount = &original
 	}
 
-	// Update goal's current amount
-	newAmount := goal.CurrentAmount
-	if req.Type == "add" {
-		newAmount += req.Amount
-	} else {
-		newAmount -= req.Amount
+	if needsApproval || (req.Type == "remove" && goal.Locked(time.Now())) {
+		return h.withdrawals.RequestWithdrawal(c, transaction, goal)
 	}
 
-	goal.CurrentAmount = newAmount
-	if err := h.goalRepo.Update(goal); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update goal amount"})
+	// Insert the transaction and move the goal balance atomically.
+	// The ledger refuses removals that would make the balance negative.
+	if err := h.ledger.Record(transaction); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientFunds):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		case errors.Is(err, models.ErrInvalidAmount):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid amount"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create transaction"})
 	}
 
 	return c.JSON(http.StatusCreated, transaction)
@@ -99,22 +177,24 @@ func (h *TransactionsHandler) GetTransactionsByGoal(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid goal ID"})
 	}
 
-	// Verify goal belongs to user
-	goal, err := h.goalRepo.GetByID(goalID)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+	if _, err := h.access.Goal(userID, goalID, access.View); err != nil {
+		return accessFailed(c, err)
 	}
 
-	if goal.UserID != userID {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+	var query TransactionListQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
 	}
 
-	transactions, err := h.transactionRepo.GetByGoalID(goalID)
+	transactions, next, err := h.transactionRepo.ListByGoalID(goalID, query.options())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transactions"})
+		return listFailed(c, err, "Failed to get transactions")
 	}
 
-	return c.JSON(http.StatusOK, transactions)
+	return c.JSON(http.StatusOK, listResponse(transactions, next))
 }
 
 func (h *TransactionsHandler) GetUserTransactions(c echo.Context) error {
@@ -123,10 +203,205 @@ func (h *TransactionsHandler) GetUserTransactions(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
 	}
 
-	transactions, err := h.transactionRepo.GetByUserID(userID)
+	var query TransactionListQuery
+	if err := c.Bind(&query); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid query parameters"})
+	}
+	if err := c.Validate(&query); err != nil {
+		return validationFailed(c, err)
+	}
+
+	transactions, next, err := h.transactionRepo.ListByUserID(userID, query.options())
+	if err != nil {
+		return listFailed(c, err, "Failed to get transactions")
+	}
+
+	return c.JSON(http.StatusOK, listResponse(transactions, next))
+}
+
+// AmendTransaction corrects the amount or description of a transaction.
+// The old values are kept in its history.
+func (h *TransactionsHandler) AmendTransaction(c echo.Context) error {
+	transaction, err := h.authorizedTransaction(c, true)
+	if transaction == nil {
+		return err
+	}
+
+	var req AmendTransactionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+
+	switch {
+	case transaction.VoidedAt != nil:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Transaction is voided"})
+	case transaction.ReversalOf != nil:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Reversals cannot be changed"})
+	case transaction.TransferID != nil:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Transfers cannot be amended; void the transfer instead"})
+	case transaction.Earned():
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Earned money cannot be amended; void it instead"})
+	}
+
+	amount := transaction.Amount
+	if !req.Amount.IsZero() {
+		amount = models.NewMoney(req.Amount.Amount, transaction.Currency)
+	}
+	// The recorded exchange rate would no longer match a new amount
+	if transaction.OriginalAmount != nil && amount.Amount != transaction.Amount.Amount {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Converted contributions cannot change amount; void and create a new one instead"})
+	}
+	// Raising it could move more than the surplus holds
+	if transaction.Source == models.SourceSurplus && amount.Amount > transaction.Amount.Amount {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Surplus deposits cannot be raised; move more surplus instead"})
+	}
+	description := transaction.Description
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	// Lowering a contribution or raising a withdrawal takes money out
+	userID := c.Get("user_id").(int)
+	takesOut := amount.Amount < transaction.Amount.Amount
+	if transaction.Type == "remove" {
+		takesOut = amount.Amount > transaction.Amount.Amount
+	}
+	if takesOut {
+		if err := h.access.TakeOut(userID, transaction.GoalID); err != nil {
+			return accessFailed(c, err)
+		}
+	}
+	if _, err := h.ledger.Amend(transaction, userID, amount, description); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientFunds):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		case errors.Is(err, models.ErrTransactionMatched):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Matched contributions cannot change amount; void and create a new one instead"})
+		case errors.Is(err, models.ErrTransactionVoided), errors.Is(err, models.ErrTransactionChanged):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Transaction has changed, please reload it"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to amend transaction"})
+	}
+
+	return c.JSON(http.StatusOK, transaction)
+}
+
+// VoidTransaction cancels a transaction with a reversal entry. Voiding a
+// transfer leg voids the whole transfer, and voiding a contribution voids
+// what match rules paid on it.
+func (h *TransactionsHandler) VoidTransaction(c echo.Context) error {
+	transaction, err := h.authorizedTransaction(c, true)
+	if transaction == nil {
+		return err
+	}
+
+	switch {
+	case transaction.VoidedAt != nil:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Transaction is already voided"})
+	case transaction.ReversalOf != nil:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Reversals cannot be changed"})
+	}
+
+	// Voiding a contribution takes the money back out
+	userID := c.Get("user_id").(int)
+	if transaction.Type == "add" {
+		if err := h.access.TakeOut(userID, transaction.GoalID); err != nil {
+			return accessFailed(c, err)
+		}
+	}
+
+	// The other leg of a transfer is voided too, so the user must be
+	// allowed to change that one as well, and take the money back out of
+	// its goal if it was added there
+	if transaction.TransferID != nil {
+		legs, err := h.transactionRepo.GetByTransferID(*transaction.TransferID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transfer"})
+		}
+		for _, leg := range legs {
+			_, err := h.access.Record(userID, leg.GoalID, leg.UserID, transactionPermission(leg.Type))
+			if err == nil && leg.Type == "add" && leg.ID != transaction.ID {
+				err = h.access.TakeOut(userID, leg.GoalID)
+			}
+			if err != nil {
+				return accessFailed(c, err)
+			}
+		}
+	}
+
+	reversals, err := h.ledger.Void(transaction, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientFunds):
+			// The money added has already been spent
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		case errors.Is(err, models.ErrTransactionVoided), errors.Is(err, models.ErrTransactionChanged):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Transaction is already voided"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to void transaction"})
+	}
+
+	return c.JSON(http.StatusOK, VoidTransactionResponse{Transaction: transaction, Reversals: reversals})
+}
+
+// GetTransactionHistory returns a transaction with every amendment and
+// void made to it, oldest first.
+func (h *TransactionsHandler) GetTransactionHistory(c echo.Context) error {
+	transaction, err := h.authorizedTransaction(c, false)
+	if transaction == nil {
+		return err
+	}
+
+	events, err := h.eventRepo.GetByTransactionID(transaction.ID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transactions"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transaction history"})
 	}
 
-	return c.JSON(http.StatusOK, transactions)
+	return c.JSON(http.StatusOK, TransactionHistoryResponse{Transaction: transaction, Events: events})
+}
+
+// authorizedTransaction loads the transaction named by :id and checks that
+// the user may see it or, with edit, amend or void it. Members may edit
+// their own transactions if they could record them; editing someone
+// else's takes access.Manage. If the transaction doesn't exist, belongs
+// to a goal in the trash or the check fails, it writes the error response
+// and returns a nil transaction.
+func (h *TransactionsHandler) authorizedTransaction(c echo.Context, edit bool) (*models.Transaction, error) {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+	}
+
+	transaction, err := h.transactionRepo.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transaction"})
+	}
+
+	perm := access.View
+	if edit {
+		perm = transactionPermission(transaction.Type)
+	}
+	_, err = h.access.Record(userID, transaction.GoalID, transaction.UserID, perm)
+	if errors.Is(err, access.ErrGoalNotFound) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
+	}
+	if err != nil {
+		return nil, accessFailed(c, err)
+	}
+	return transaction, nil
 }
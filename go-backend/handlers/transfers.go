@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+type TransfersHandler struct {
+	transferRepo TransferRepository
+	access       Authorizer
+	ledger       TransferLedger
+	rates        ExchangeRateProvider
+}
+
+type TransferRepository interface {
+	GetByUserID(userID int) ([]models.Transfer, error)
+}
+
+type TransferLedger interface {
+	Transfer(transfer *models.Transfer) (*models.Transaction, *models.Transaction, error)
+}
+
+// CreateTransferRequest moves Amount, in the source goal's currency, to
+// another goal. It is converted if the goals' currencies differ.
+type CreateTransferRequest struct {
+	FromGoalID  int          `json:"from_goal_id" validate:"required,gt=0"`
+	ToGoalID    int          `json:"to_goal_id" validate:"required,gt=0"`
+	Amount      models.Money `json:"amount" validate:"required,gt=0"`
+	Description string       `json:"description" validate:"max=500"` // defaults to naming both goals
+}
+
+type TransferResponse struct {
+	Transfer *models.Transfer    `json:"transfer"`
+	From     *models.Transaction `json:"from"`
+	To       *models.Transaction `json:"to"`
+}
+
+func NewTransfersHandler(transferRepo TransferRepository, authorizer Authorizer, ledger TransferLedger, rates ExchangeRateProvider) *TransfersHandler {
+	return &TransfersHandler{
+		transferRepo: transferRepo,
+		access:       authorizer,
+		ledger:       ledger,
+		rates:        rates,
+	}
+}
+
+func (h *TransfersHandler) CreateTransfer(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	var req CreateTransferRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return validationFailed(c, err)
+	}
+	if req.FromGoalID == req.ToGoalID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot transfer to the same goal"})
+	}
+
+	from, err := h.access.Goal(userID, req.FromGoalID, access.Withdraw)
+	if err != nil {
+		return accessFailed(c, err)
+	}
+	if err := h.access.TakeOut(userID, from.ID); err != nil {
+		return accessFailed(c, err)
+	}
+	to, err := h.access.Goal(userID, req.ToGoalID, access.Contribute)
+	if err != nil {
+		return accessFailed(c, err)
+	}
+
+	transfer := &models.Transfer{
+		UserID:          userID,
+		FromGoalID:      from.ID,
+		ToGoalID:        to.ID,
+		Amount:          models.NewMoney(req.Amount.Amount, from.Currency),
+		ConvertedAmount: models.NewMoney(req.Amount.Amount, from.Currency),
+		Description:     req.Description,
+	}
+	if transfer.Description == "" {
+		transfer.Description = "Transfer from " + from.Title + " to " + to.Title
+	}
+
+	// Like contributions, transfers between currencies use today's rate
+	if from.Currency != to.Currency {
+		rate, err := h.rates.Rate(from.Currency, to.Currency, time.Now())
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Exchange rate not available"})
+		}
+		transfer.ConvertedAmount, transfer.ExchangeRate, err = transfer.Amount.Convert(rate, to.Currency)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Amount too large to convert"})
+		}
+	}
+
+	fromLeg, toLeg, err := h.ledger.Transfer(transfer)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientFunds):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Insufficient funds in goal"})
+		case errors.Is(err, models.ErrGoalNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Goal not found"})
+		case errors.Is(err, models.ErrInvalidAmount):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Amount too small to convert"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create transfer"})
+	}
+
+	return c.JSON(http.StatusCreated, TransferResponse{Transfer: transfer, From: fromLeg, To: toLeg})
+}
+
+func (h *TransfersHandler) GetTransfers(c echo.Context) error {
+	userID, ok := c.Get("user_id").(int)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	transfers, err := h.transferRepo.GetByUserID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get transfers"})
+	}
+
+	return c.JSON(http.StatusOK, listResponse(transfers, ""))
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/validation"
+)
+
+// ValidationErrorResponse is the 422 body for a request that failed
+// validation, with one entry per failing field and rule.
+type ValidationErrorResponse struct {
+	Error  string                  `json:"error"`
+	Fields []validation.FieldError `json:"fields"`
+}
+
+// validationFailed writes the response for an error from c.Validate.
+func validationFailed(c echo.Context, err error) error {
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		c.Logger().Errorf("failed to validate request: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to validate request"})
+	}
+	return c.JSON(http.StatusUnprocessableEntity, ValidationErrorResponse{
+		Error:  "Validation failed",
+		Fields: verr.Fields,
+	})
+}
@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Mapping says where a CSV file keeps each field. A column is named by
+// its header, case-insensitively, or by its 1-based position. Without a
+// mapping the columns "date", "amount" and "description" are used.
+type Mapping struct {
+	Date        string `json:"date"`
+	Amount      string `json:"amount"`
+	Description string `json:"description"` // optional
+	// DateFormat is a Go time layout such as "02/01/2006". By default
+	// ISO dates (2006-01-02), RFC 3339 times and US dates (01/02/2006)
+	// are accepted.
+	DateFormat   string `json:"date_format"`
+	Delimiter    string `json:"delimiter"` // a single character, "," by default
+	DecimalComma bool   `json:"decimal_comma"`
+	NoHeader     bool   `json:"no_header"` // the first row is data
+}
+
+var defaultDateFormats = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "01/02/2006", "1/2/2006"}
+
+// ParseCSV reads the records of a CSV file. Rows that cannot be read are
+// returned with Err set; only a file that is not CSV at all, or whose
+// mapping does not fit it, fails as a whole.
+func ParseCSV(r io.Reader, m Mapping) ([]Record, error) {
+	if m.Date == "" {
+		m.Date = "date"
+	}
+	if m.Amount == "" {
+		m.Amount = "amount"
+	}
+	if m.Description == "" && !m.NoHeader {
+		m.Description = "description"
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(m.Delimiter)
+		if size != len(m.Delimiter) {
+			return nil, errors.New("delimiter must be a single character")
+		}
+		reader.Comma = delimiter
+	}
+
+	var header []string
+	if !m.NoHeader {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil, errors.New("the file is empty")
+		}
+		if err != nil {
+			return nil, err
+		}
+		header = row
+		// Spreadsheets often save a byte order mark
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+	}
+
+	dateColumn, err := column(m.Date, header, true)
+	if err != nil {
+		return nil, err
+	}
+	amountColumn, err := column(m.Amount, header, true)
+	if err != nil {
+		return nil, err
+	}
+	// The default description column is optional
+	descriptionColumn, err := column(m.Description, header, m.Description != "description")
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			records = append(records, Record{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		if isBlank(row) {
+			continue
+		}
+		if len(records) == MaxRecords {
+			return nil, ErrTooManyRecords
+		}
+
+		line, _ := reader.FieldPos(0)
+		record := Record{Line: line}
+		field := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		record.Description = field(descriptionColumn)
+		if record.Date, err = parseDate(field(dateColumn), m.DateFormat); err != nil {
+			record.Err = err
+		} else if record.Amount, err = parseAmount(field(amountColumn), m.DecimalComma); err != nil {
+			record.Err = err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// column finds the index of a column named by header or position. It
+// returns -1 for an empty name, or for a missing column that is not
+// required.
+func column(name string, header []string, required bool) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 {
+		return n - 1, nil
+	}
+	if !required {
+		return -1, nil
+	}
+	return -1, fmt.Errorf("column %q not found", name)
+}
+
+func parseDate(s, layout string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("date is empty")
+	}
+	layouts := defaultDateFormats
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+func isBlank(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
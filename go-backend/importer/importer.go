@@ -0,0 +1,72 @@
+// Package importer reads contributions from spreadsheet (CSV) and bank
+// (OFX/QFX) exports. It only parses; deciding which records to keep and
+// recording them is up to the caller.
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+// MaxRecords is the most records one file may hold.
+const MaxRecords = 5000
+
+var ErrTooManyRecords = fmt.Errorf("a file may hold at most %d records", MaxRecords)
+
+// Record is one row of an import. Amount is in signed minor units:
+// negative amounts are withdrawals. Currency is empty unless the file
+// names one. A record that could not be read has Err set and only Line is
+// meaningful.
+type Record struct {
+	Line        int
+	Date        time.Time
+	Amount      int64
+	Currency    string
+	Description string
+	Err         error
+}
+
+// Hash fingerprints the record's date, amount and description, so that a
+// row imported before can be recognised. Case and repeated spaces in the
+// description do not matter.
+func (r Record) Hash() string {
+	description := strings.Join(strings.Fields(strings.ToLower(r.Description)), " ")
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", r.Date.Format("2006-01-02"), r.Amount, description)))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAmount reads amounts the way spreadsheets and banks write them:
+// with currency symbols, thousands separators, a decimal comma if
+// decimalComma is set, or in parentheses for negative amounts.
+func parseAmount(s string, decimalComma bool) (int64, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '-', r == '+':
+			b.WriteRune(r)
+		case r == '.' && !decimalComma, r == ',' && decimalComma:
+			b.WriteByte('.')
+		}
+	}
+
+	amount, err := models.ParseMoney(b.String(), "")
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		return -amount.Amount, nil
+	}
+	return amount.Amount, nil
+}
@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOFXSize bounds how much of an OFX file is read.
+const maxOFXSize = 10 << 20
+
+// ParseOFX reads the statement transactions (STMTTRN) of an OFX or QFX
+// file. Both the SGML form of OFX 1.x, where leaf elements are not
+// closed, and the XML form of OFX 2.x are understood. Each record takes
+// the currency of its statement (CURDEF), and its Line is the line of its
+// STMTTRN element.
+func ParseOFX(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxOFXSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxOFXSize {
+		return nil, fmt.Errorf("OFX files may be at most %d MB", maxOFXSize>>20)
+	}
+	text := string(data)
+	if !strings.Contains(strings.ToUpper(text), "<OFX>") {
+		return nil, errors.New("not an OFX file")
+	}
+
+	var (
+		records  []Record
+		currency string
+		current  map[string]string // fields of the open STMTTRN
+		line     int
+	)
+	for pos := 0; ; {
+		start := strings.IndexByte(text[pos:], '<')
+		if start < 0 {
+			break
+		}
+		start += pos
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			break
+		}
+		end += start
+		tag := strings.ToUpper(strings.TrimSpace(text[start+1 : end]))
+
+		// The element's value runs up to the next tag
+		next := strings.IndexByte(text[end+1:], '<')
+		if next < 0 {
+			next = len(text) - end - 1
+		}
+		value := strings.TrimSpace(text[end+1 : end+1+next])
+		pos = end + 1
+
+		switch {
+		case strings.HasPrefix(tag, "?"), strings.HasPrefix(tag, "!"):
+			// XML declarations and comments
+		case tag == "STMTTRN":
+			current = make(map[string]string)
+			line = strings.Count(text[:start], "\n") + 1
+		case tag == "/STMTTRN":
+			if current != nil {
+				if len(records) == MaxRecords {
+					return nil, ErrTooManyRecords
+				}
+				records = append(records, ofxRecord(current, currency, line))
+				current = nil
+			}
+		case tag == "CURDEF":
+			currency = strings.ToUpper(value)
+		case current != nil && !strings.HasPrefix(tag, "/") && value != "":
+			current[tag] = unescapeOFX(value)
+		}
+	}
+	return records, nil
+}
+
+func ofxRecord(fields map[string]string, currency string, line int) Record {
+	record := Record{Line: line, Currency: currency}
+
+	// NAME is the payee; MEMO usually adds detail
+	record.Description = fields["NAME"]
+	if memo := fields["MEMO"]; memo != "" && memo != record.Description {
+		if record.Description != "" {
+			record.Description += " - "
+		}
+		record.Description += memo
+	}
+
+	date, err := parseOFXDate(fields["DTPOSTED"])
+	if err != nil {
+		record.Err = err
+		return record
+	}
+	record.Date = date
+
+	amount := fields["TRNAMT"]
+	// Some banks write a decimal comma
+	decimalComma := strings.Contains(amount, ",") && !strings.Contains(amount, ".")
+	if record.Amount, err = parseAmount(amount, decimalComma); err != nil {
+		record.Err = err
+	}
+	return record
+}
+
+// parseOFXDate reads OFX datetimes: YYYYMMDD, optionally followed by
+// HHMMSS, fractional seconds and a time zone such as "[-5:EST]". Without
+// a zone the time is UTC.
+func parseOFXDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("date is empty")
+	}
+	value, zone, _ := strings.Cut(s, "[")
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+
+	var (
+		t   time.Time
+		err error
+	)
+	switch len(value) {
+	case 8:
+		t, err = time.Parse("20060102", value)
+	case 12:
+		t, err = time.Parse("200601021504", value)
+	case 14:
+		t, err = time.Parse("20060102150405", value)
+	default:
+		err = errors.New("unexpected length")
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	if zone != "" {
+		offset, name, _ := strings.Cut(strings.TrimSuffix(zone, "]"), ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone in date %q", s)
+		}
+		if name == "" {
+			name = offset
+		}
+		location := time.FixedZone(name, int(hours*3600))
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, location)
+	}
+	return t, nil
+}
+
+var ofxEntities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ")
+
+func unescapeOFX(s string) string {
+	return ofxEntities.Replace(s)
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type IdempotencyKeyStore interface {
+	DeleteExpired(now time.Time) (int, error)
+}
+
+// PurgeIdempotencyKeys removes stored responses whose replay window has
+// passed.
+type PurgeIdempotencyKeys struct {
+	store IdempotencyKeyStore
+}
+
+func NewPurgeIdempotencyKeys(store IdempotencyKeyStore) *PurgeIdempotencyKeys {
+	return &PurgeIdempotencyKeys{store: store}
+}
+
+func (j *PurgeIdempotencyKeys) Run(ctx context.Context, now time.Time) error {
+	deleted, err := j.store.DeleteExpired(now)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("deleted %d expired idempotency keys", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+type RuleStore interface {
+	GetDue(now time.Time, limit int) ([]models.GoalRule, error)
+	PayInterest(rule *models.GoalRule) (*models.Transaction, error)
+}
+
+// GoalInterest pays interest rules on the first of each month. Like
+// RecurringContributions, each run catches up on every month missed since
+// the last one and dates each payment when it was due.
+type GoalInterest struct {
+	store RuleStore
+}
+
+func NewGoalInterest(store RuleStore) *GoalInterest {
+	return &GoalInterest{store: store}
+}
+
+func (j *GoalInterest) Run(ctx context.Context, now time.Time) error {
+	for ctx.Err() == nil {
+		due, err := j.store.GetDue(now, dueBatchSize)
+		if err != nil {
+			return err
+		}
+
+		progressed := false
+		for i := range due {
+			ran, err := j.catchUp(ctx, &due[i], now)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", due[i].ID, err)
+			}
+			progressed = progressed || ran
+		}
+
+		// A short batch means nothing else is due
+		if len(due) < dueBatchSize || !progressed {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// catchUp pays every month of rule due at or before now and reports
+// whether it got through any.
+func (j *GoalInterest) catchUp(ctx context.Context, rule *models.GoalRule, now time.Time) (bool, error) {
+	ran := false
+	for rule.NextRunAt != nil && !rule.NextRunAt.After(now) && ctx.Err() == nil {
+		_, err := j.store.PayInterest(rule)
+		switch {
+		case err == nil:
+			ran = true
+		case errors.Is(err, models.ErrRuleChanged):
+			// Deleted or paid elsewhere in the meantime
+			return ran, nil
+		default:
+			return ran, err
+		}
+	}
+	return ran, nil
+}
@@ -0,0 +1,27 @@
+// Package jobs runs the server's periodic background work.
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn right away and then every interval until ctx is done.
+// Errors are logged; the next run happens as usual.
+func Every(ctx context.Context, interval time.Duration, name string, fn func(ctx context.Context, now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("job %s failed: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/schedule"
+)
+
+// dueBatchSize is how many due schedules are loaded at a time.
+const dueBatchSize = 100
+
+type ScheduleStore interface {
+	GetDue(now time.Time, limit int) ([]models.RecurringContribution, error)
+	Materialize(s *models.RecurringContribution, next *time.Time) (*models.Transaction, error)
+	PauseWithError(id int, reason string) error
+}
+
+// RecurringContributions turns due standing orders into transactions.
+// Each run catches up on every occurrence missed since the last one, for
+// example while the server was down, and records each with the time it
+// was due.
+type RecurringContributions struct {
+	store ScheduleStore
+}
+
+func NewRecurringContributions(store ScheduleStore) *RecurringContributions {
+	return &RecurringContributions{store: store}
+}
+
+func (j *RecurringContributions) Run(ctx context.Context, now time.Time) error {
+	for ctx.Err() == nil {
+		due, err := j.store.GetDue(now, dueBatchSize)
+		if err != nil {
+			return err
+		}
+
+		progressed := false
+		for i := range due {
+			ran, err := j.catchUp(ctx, &due[i], now)
+			if err != nil {
+				return fmt.Errorf("schedule %d: %v", due[i].ID, err)
+			}
+			progressed = progressed || ran
+		}
+
+		// A short batch means nothing else is due
+		if len(due) < dueBatchSize || !progressed {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// catchUp runs every occurrence of s due at or before now and reports
+// whether it recorded any.
+func (j *RecurringContributions) catchUp(ctx context.Context, s *models.RecurringContribution, now time.Time) (bool, error) {
+	rule, err := schedule.Parse(s.Rule)
+	if err != nil {
+		return false, j.store.PauseWithError(s.ID, err.Error())
+	}
+
+	ran := false
+	for s.NextRunAt != nil && !s.NextRunAt.After(now) && ctx.Err() == nil {
+		next := rule.NextRun(s.StartAt, s.EndAt, s.RunCount+1, *s.NextRunAt)
+
+		_, err := j.store.Materialize(s, next)
+		switch {
+		case err == nil:
+			ran = true
+		case errors.Is(err, models.ErrScheduleChanged):
+			// Paused, edited or run elsewhere in the meantime
+			return ran, nil
+		case errors.Is(err, models.ErrGoalNotFound), errors.Is(err, models.ErrCurrencyMismatch):
+			log.Printf("pausing schedule %d: %v", s.ID, err)
+			return ran, j.store.PauseWithError(s.ID, err.Error())
+		default:
+			return ran, err
+		}
+	}
+	return ran, nil
+}
@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type TrashStore interface {
+	PurgeDeletedBefore(cutoff time.Time) (int, error)
+}
+
+// PurgeTrash permanently removes goals that have been in the trash for
+// longer than the retention period, together with their transactions.
+type PurgeTrash struct {
+	store     TrashStore
+	retention time.Duration
+}
+
+func NewPurgeTrash(store TrashStore, retention time.Duration) *PurgeTrash {
+	return &PurgeTrash{store: store, retention: retention}
+}
+
+func (j *PurgeTrash) Run(ctx context.Context, now time.Time) error {
+	purged, err := j.store.PurgeDeletedBefore(now.Add(-j.retention))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("purged %d goals from the trash", purged)
+	}
+	return nil
+}
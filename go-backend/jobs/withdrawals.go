@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+type WithdrawalQueue interface {
+	GetReleasable(now time.Time, afterID, limit int) ([]models.WithdrawalRequest, error)
+	Fail(w *models.WithdrawalRequest, reason string) error
+}
+
+type WithdrawalLedger interface {
+	ApproveWithdrawal(w *models.WithdrawalRequest, decidedBy int) (*models.Transaction, error)
+}
+
+// ReleaseWithdrawals records withdrawals from locked goals once their
+// cooling-off period is over, on behalf of the member who asked. A
+// withdrawal the goal can no longer cover fails instead. A request that
+// can't be released for any other reason is logged and tried again on the
+// next run, without holding up the ones behind it.
+type ReleaseWithdrawals struct {
+	queue  WithdrawalQueue
+	ledger WithdrawalLedger
+}
+
+func NewReleaseWithdrawals(queue WithdrawalQueue, ledger WithdrawalLedger) *ReleaseWithdrawals {
+	return &ReleaseWithdrawals{queue: queue, ledger: ledger}
+}
+
+func (j *ReleaseWithdrawals) Run(ctx context.Context, now time.Time) error {
+	afterID := 0
+	for ctx.Err() == nil {
+		due, err := j.queue.GetReleasable(now, afterID, dueBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range due {
+			if err := j.release(&due[i]); err != nil {
+				log.Printf("releasing withdrawal request %d: %v", due[i].ID, err)
+			}
+			afterID = due[i].ID
+		}
+
+		// A short batch means nothing else is due
+		if len(due) < dueBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+func (j *ReleaseWithdrawals) release(w *models.WithdrawalRequest) error {
+	_, err := j.ledger.ApproveWithdrawal(w, w.UserID)
+	var reason string
+	switch {
+	case err == nil, errors.Is(err, models.ErrWithdrawalDecided):
+		// Cancelled in the meantime, if not released
+		return nil
+	case errors.Is(err, models.ErrInsufficientFunds):
+		reason = "Insufficient funds in goal"
+	case errors.Is(err, models.ErrGoalNotFound):
+		reason = "Goal not found"
+	default:
+		return err
+	}
+
+	if err := j.queue.Fail(w, reason); err != nil && !errors.Is(err, models.ErrWithdrawalDecided) {
+		return err
+	}
+	return nil
+}
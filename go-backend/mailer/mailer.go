@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format renders the message as an RFC 5322 email.
+func (m Message) format(from string, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+	return b.Bytes()
+}
+
+// SMTPMailer delivers mail through an SMTP server. Credentials are
+// optional; when set, PLAIN auth is used, which net/smtp only allows over
+// TLS or to localhost.
+type SMTPMailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	return &SMTPMailer{addr: addr, from: from, username: username, password: password}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %v", m.addr, err)
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+	return smtp.SendMail(m.addr, auth, envelopeAddress(m.from), []string{msg.To}, msg.format(m.from, time.Now()))
+}
+
+// envelopeAddress extracts the bare address from "Name <address>".
+func envelopeAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return from
+}
+
+// LogMailer writes every message to the log instead of sending it. It is
+// the default in development.
+type LogMailer struct {
+	from   string
+	logger *log.Logger
+}
+
+func NewLogMailer(from string, logger *log.Logger) *LogMailer {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &LogMailer{from: from, logger: logger}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.logger.Printf("mail to %s:\n%s", msg.To, msg.format(m.from, time.Now()))
+	return nil
+}
+
+// FileMailer saves every message as an .eml file in a directory, where it
+// can be opened with a mail client or read by tests.
+type FileMailer struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %v", err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9@._-]+`)
+
+func (m *FileMailer) Send(msg Message) error {
+	now := time.Now()
+	name := fmt.Sprintf("%s-%03d-%s.eml",
+		now.Format("20060102T150405"), m.seq.Add(1)%1000, unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	return os.WriteFile(filepath.Join(m.dir, name), msg.format(m.from, now), 0o644)
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/oleksii-dukh/cashcandy/go-backend/access"
+	"github.com/oleksii-dukh/cashcandy/go-backend/config"
 	"github.com/oleksii-dukh/cashcandy/go-backend/database"
 	"github.com/oleksii-dukh/cashcandy/go-backend/handlers"
+	"github.com/oleksii-dukh/cashcandy/go-backend/jobs"
+	"github.com/oleksii-dukh/cashcandy/go-backend/mailer"
 	authmiddleware "github.com/oleksii-dukh/cashcandy/go-backend/middleware"
 	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/rates"
+	"github.com/oleksii-dukh/cashcandy/go-backend/validation"
 )
 
 // Handler
@@ -18,63 +28,228 @@ func hello(c echo.Context) error {
 }
 
 func main() {
+	// Load configuration from environment, config file and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize database
-	db, err := database.InitDB()
+	dialect, err := database.ParseDialect(cfg.Database.Driver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.InitDB(dialect, cfg.Database.DSN)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	// Create tables
-	if err := database.CreateTables(db); err != nil {
-		log.Fatal("Failed to create tables:", err)
+	// "server migrate ..." manages the schema and exits
+	if len(cfg.Args) > 0 && cfg.Args[0] == "migrate" {
+		code := runMigrate(db, cfg.Args[1:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// Apply pending schema migrations
+	if err := database.Migrate(db); err != nil {
+		log.Fatal("Failed to migrate database:", err)
 	}
 
 	// Initialize repositories
 	userRepo := models.NewUserRepository(db)
 	goalRepo := models.NewGoalRepository(db)
 	transactionRepo := models.NewTransactionRepository(db)
+	sessionRepo := models.NewSessionRepository(db)
+	userTokenRepo := models.NewUserTokenRepository(db)
+	recoveryCodeRepo := models.NewRecoveryCodeRepository(db)
+	scheduleRepo := models.NewRecurringContributionRepository(db)
+	transferRepo := models.NewTransferRepository(db)
+	transactionEventRepo := models.NewTransactionEventRepository(db)
+	idempotencyKeyRepo := models.NewIdempotencyKeyRepository(db)
+	goalMemberRepo := models.NewGoalMemberRepository(db)
+	goalInvitationRepo := models.NewGoalInvitationRepository(db)
+	childLimitsRepo := models.NewChildLimitsRepository(db)
+	withdrawalRequestRepo := models.NewWithdrawalRequestRepository(db)
+	goalRuleRepo := models.NewGoalRuleRepository(db)
+	categoryRepo := models.NewCategoryRepository(db)
+	entryRepo := models.NewEntryRepository(db)
+	budgetRepo := models.NewBudgetRepository(db)
+	authorizer := access.NewService(goalRepo, goalMemberRepo, userRepo)
+	ledger := models.NewLedger(db)
+
+	// Exchange rates are read from a local file so conversions work offline
+	exchangeRates, err := rates.LoadCSV(cfg.RatesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("%s not found, only same-currency amounts can be converted", cfg.RatesFile)
+		exchangeRates = rates.NewCSVProvider()
+	} else if err != nil {
+		log.Fatal("Failed to load exchange rates:", err)
+	}
+
+	// Outgoing email
+	var mail mailer.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mail = mailer.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.From, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword)
+	case "file":
+		if mail, err = mailer.NewFileMailer(cfg.Mail.Dir, cfg.Mail.From); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		mail = mailer.NewLogMailer(cfg.Mail.From, nil)
+	}
 
 	// Initialize handlers
-	jwtKey := []byte("your-secret-key-change-this-in-production")
-	authHandler := handlers.NewAuthHandler(userRepo, jwtKey)
-	goalsHandler := handlers.NewGoalsHandler(goalRepo)
-	transactionsHandler := handlers.NewTransactionsHandler(transactionRepo, goalRepo)
-	statsHandler := handlers.NewStatsHandler(goalRepo, transactionRepo)
+	jwtKey := []byte(cfg.JWTSecret)
+	authHandler := handlers.NewAuthHandler(userRepo, sessionRepo, userTokenRepo, recoveryCodeRepo, mail, jwtKey, cfg.AppURL)
+	goalsHandler := handlers.NewGoalsHandler(goalRepo, authorizer, cfg.TrashRetention())
+	childrenHandler := handlers.NewChildrenHandler(userRepo, childLimitsRepo, withdrawalRequestRepo, ledger, exchangeRates)
+	transactionsHandler := handlers.NewTransactionsHandler(transactionRepo, transactionEventRepo, authorizer, ledger, exchangeRates, childrenHandler)
+	statsHandler := handlers.NewStatsHandler(goalRepo, transactionRepo, userRepo, exchangeRates)
+	schedulesHandler := handlers.NewSchedulesHandler(scheduleRepo, authorizer)
+	rulesHandler := handlers.NewRulesHandler(goalRuleRepo, authorizer)
+	importHandler := handlers.NewImportHandler(authorizer, transactionRepo, ledger)
+	exportHandler := handlers.NewExportHandler(goalRepo, transactionRepo, userRepo)
+	transfersHandler := handlers.NewTransfersHandler(transferRepo, authorizer, ledger, exchangeRates)
+	budgetsHandler := handlers.NewBudgetsHandler(categoryRepo, entryRepo, budgetRepo, userRepo, authorizer, ledger, exchangeRates)
+	membersHandler := handlers.NewMembersHandler(authorizer, goalMemberRepo, goalInvitationRepo, transactionRepo, userRepo, mail, cfg.AppURL)
 
 	// Echo instance
 	e := echo.New()
+	e.Validator = validation.New(time.Now)
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: cfg.CORS.AllowedOrigins,
+		}))
+	}
 
 	// Public routes
 	e.GET("/", hello)
 	e.POST("/api/auth/register", authHandler.Register)
 	e.POST("/api/auth/login", authHandler.Login)
+	e.POST("/api/auth/login/mfa", authHandler.LoginMFA)
+	e.POST("/api/auth/refresh", authHandler.Refresh)
+	e.POST("/api/auth/password/forgot", authHandler.ForgotPassword)
+	e.POST("/api/auth/password/reset", authHandler.ResetPassword)
+	e.POST("/api/auth/email/verify", authHandler.VerifyEmail)
 
 	// Protected routes
 	protected := e.Group("/api")
-	protected.Use(authmiddleware.JWTMiddleware(jwtKey))
+	protected.Use(authmiddleware.JWTMiddleware(jwtKey, sessionRepo))
+	protected.Use(authmiddleware.RequireVerifiedEmail(userRepo, cfg.Auth.UnverifiedRoutes))
+	protected.Use(authmiddleware.Idempotency(idempotencyKeyRepo, cfg.IdempotencyTTL(), cfg.IdempotencyLease()))
+
+	// Session routes
+	protected.POST("/auth/logout", authHandler.Logout)
+	protected.GET("/auth/sessions", authHandler.GetSessions)
+	protected.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+	protected.POST("/auth/email/resend", authHandler.ResendVerification)
+
+	// Two-factor authentication routes
+	protected.GET("/auth/mfa", authHandler.GetMFAStatus)
+	protected.POST("/auth/mfa/enroll", authHandler.EnrollMFA)
+	protected.POST("/auth/mfa/confirm", authHandler.ConfirmMFA)
+	protected.POST("/auth/mfa/disable", authHandler.DisableMFA)
+	protected.POST("/auth/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
 	
 	// Goals routes
 	protected.GET("/goals", goalsHandler.GetGoals)
 	protected.POST("/goals", goalsHandler.CreateGoal)
+	protected.GET("/goals/trash", goalsHandler.GetTrash)
+	protected.POST("/goals/trash/:id/restore", goalsHandler.RestoreGoal)
+	protected.DELETE("/goals/trash/:id", goalsHandler.PurgeGoal)
 	protected.GET("/goals/:id", goalsHandler.GetGoal)
 	protected.PUT("/goals/:id", goalsHandler.UpdateGoal)
 	protected.DELETE("/goals/:id", goalsHandler.DeleteGoal)
+	protected.PUT("/goals/:id/lock", goalsHandler.SetLock)
+	protected.DELETE("/goals/:id/lock", goalsHandler.RemoveLock)
+
+	// Goal sharing routes
+	protected.GET("/goals/:id/members", membersHandler.GetMembers)
+	protected.PUT("/goals/:id/members/:user_id", membersHandler.UpdateMember)
+	protected.DELETE("/goals/:id/members/:user_id", membersHandler.RemoveMember)
+	protected.GET("/goals/:id/invitations", membersHandler.GetInvitations)
+	protected.POST("/goals/:id/invitations", membersHandler.CreateInvitation)
+	protected.DELETE("/goals/:id/invitations/:invitation_id", membersHandler.RevokeInvitation)
+	protected.GET("/invitations", membersHandler.GetMyInvitations)
+	protected.POST("/invitations/:id/accept", membersHandler.AcceptInvitation)
+	protected.POST("/invitations/:id/decline", membersHandler.DeclineInvitation)
+
+	// Child account routes
+	protected.POST("/children", childrenHandler.CreateChild)
+	protected.GET("/children", childrenHandler.GetChildren)
+	protected.PUT("/children/:id/limits", childrenHandler.SetLimits)
+	protected.GET("/withdrawal-requests", childrenHandler.GetWithdrawalRequests)
+	protected.POST("/withdrawal-requests/:id/approve", childrenHandler.ApproveWithdrawal)
+	protected.POST("/withdrawal-requests/:id/reject", childrenHandler.RejectWithdrawal)
+	protected.POST("/withdrawal-requests/:id/cancel", childrenHandler.CancelWithdrawal)
+
+	// Recurring contribution routes
+	protected.GET("/goals/:id/schedules", schedulesHandler.GetSchedules)
+	protected.POST("/goals/:id/schedules", schedulesHandler.CreateSchedule)
+	protected.GET("/goals/:id/schedules/:schedule_id", schedulesHandler.GetSchedule)
+	protected.PUT("/goals/:id/schedules/:schedule_id", schedulesHandler.UpdateSchedule)
+	protected.DELETE("/goals/:id/schedules/:schedule_id", schedulesHandler.DeleteSchedule)
+	protected.POST("/goals/:id/schedules/:schedule_id/pause", schedulesHandler.PauseSchedule)
+	protected.POST("/goals/:id/schedules/:schedule_id/resume", schedulesHandler.ResumeSchedule)
+	protected.GET("/goals/:id/rules", rulesHandler.GetRules)
+	protected.POST("/goals/:id/rules", rulesHandler.CreateRule)
+	protected.GET("/goals/:id/rules/:rule_id", rulesHandler.GetRule)
+	protected.PUT("/goals/:id/rules/:rule_id", rulesHandler.UpdateRule)
+	protected.DELETE("/goals/:id/rules/:rule_id", rulesHandler.DeleteRule)
 	
 	// Transactions routes
 	protected.POST("/transactions", transactionsHandler.CreateTransaction)
 	protected.GET("/transactions", transactionsHandler.GetUserTransactions)
+	protected.PUT("/transactions/:id", transactionsHandler.AmendTransaction)
+	protected.POST("/transactions/:id/void", transactionsHandler.VoidTransaction)
+	protected.GET("/transactions/:id/history", transactionsHandler.GetTransactionHistory)
 	protected.GET("/goals/:goal_id/transactions", transactionsHandler.GetTransactionsByGoal)
+	protected.POST("/import", importHandler.Import)
+
+	// Transfers routes
+	protected.POST("/transfers", transfersHandler.CreateTransfer)
+	protected.GET("/transfers", transfersHandler.GetTransfers)
+	protected.GET("/export", exportHandler.Export)
 	
 	// Stats routes
 	protected.GET("/dashboard", statsHandler.GetDashboardStats)
+	protected.GET("/stats/history", statsHandler.GetHistory)
+
+	// Budgets routes
+	protected.GET("/categories", budgetsHandler.GetCategories)
+	protected.POST("/categories", budgetsHandler.CreateCategory)
+	protected.PUT("/categories/:id", budgetsHandler.RenameCategory)
+	protected.DELETE("/categories/:id", budgetsHandler.DeleteCategory)
+	protected.GET("/entries", budgetsHandler.GetEntries)
+	protected.POST("/entries", budgetsHandler.CreateEntry)
+	protected.DELETE("/entries/:id", budgetsHandler.DeleteEntry)
+	protected.GET("/budgets", budgetsHandler.GetBudgets)
+	protected.POST("/budgets/surplus", budgetsHandler.MoveSurplus)
+	protected.PUT("/budgets/:category_id", budgetsHandler.SetBudget)
+	protected.DELETE("/budgets/:category_id", budgetsHandler.DeleteBudget)
+
+	// Background jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recurring := jobs.NewRecurringContributions(scheduleRepo)
+	go jobs.Every(ctx, cfg.JobsInterval(), "recurring contributions", recurring.Run)
+	interest := jobs.NewGoalInterest(goalRuleRepo)
+	go jobs.Every(ctx, cfg.JobsInterval(), "goal interest", interest.Run)
+	releaseWithdrawals := jobs.NewReleaseWithdrawals(withdrawalRequestRepo, ledger)
+	go jobs.Every(ctx, cfg.JobsInterval(), "release withdrawals", releaseWithdrawals.Run)
+	purgeTrash := jobs.NewPurgeTrash(goalRepo, cfg.TrashRetention())
+	go jobs.Every(ctx, cfg.JobsInterval(), "purge trash", purgeTrash.Run)
+	purgeIdempotencyKeys := jobs.NewPurgeIdempotencyKeys(idempotencyKeyRepo)
+	go jobs.Every(ctx, cfg.JobsInterval(), "purge idempotency keys", purgeIdempotencyKeys.Run)
 
 	// Start server
-	log.Println("Server starting on :1323")
-	e.Logger.Fatal(e.Start(":1323"))
+	log.Printf("Server starting on %s (%s mode)", cfg.ListenAddr, cfg.Env)
+	e.Logger.Fatal(e.Start(cfg.ListenAddr))
 }
\ No newline at end of file
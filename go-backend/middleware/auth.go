@@ -3,9 +3,11 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
 )
 
 type Claims struct {
@@ -13,7 +15,15 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func JWTMiddleware(jwtKey []byte) echo.MiddlewareFunc {
+// SessionStore looks up the session named by a token's jti claim.
+type SessionStore interface {
+	GetByPublicID(publicID string) (*models.Session, error)
+}
+
+// JWTMiddleware accepts a bearer token only while the session it was
+// issued for is still active, so logging out takes effect immediately
+// instead of when the access token expires.
+func JWTMiddleware(jwtKey []byte, sessions SessionStore) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -39,7 +49,13 @@ func JWTMiddleware(jwtKey []byte) echo.MiddlewareFunc {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
 			}
 
+			session, err := sessions.GetByPublicID(claims.ID)
+			if err != nil || session.UserID != claims.UserID || !session.IsActive(time.Now()) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Session expired or revoked"})
+			}
+
 			c.Set("user_id", claims.UserID)
+			c.Set("session_id", session.PublicID)
 			return next(c)
 		}
 	}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+type IdempotencyKeyStore interface {
+	Reserve(key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	Complete(key *models.IdempotencyKey) error
+	Release(key *models.IdempotencyKey) error
+}
+
+// Idempotency makes mutating requests that carry an Idempotency-Key header
+// safe to retry. The first request with a key runs as usual and its
+// response is stored for ttl; a retry with the same key, method, path and
+// body gets the stored response back without running again. Reusing a key
+// for a different request, or while the first is still running, is a 409.
+// Server errors and panics are not stored, so those requests can be
+// retried for real. A running request holds its key for lease only, so
+// one that never finishes, say because the server died, doesn't lock out
+// retries for the whole ttl. It must run after JWTMiddleware, as keys are
+// per user.
+func Idempotency(store IdempotencyKeyStore, ttl, lease time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			header := req.Header.Get(HeaderIdempotencyKey)
+			if header == "" || !mutating(req.Method) {
+				return next(c)
+			}
+			if len(header) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Idempotency-Key must be at most 255 characters"})
+			}
+
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := &models.IdempotencyKey{
+				UserID:      userID,
+				Key:         header,
+				Fingerprint: fingerprint(req, body),
+				ExpiresAt:   time.Now().Add(lease),
+			}
+			existing, err := store.Reserve(key)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check Idempotency-Key"})
+			}
+			if existing != nil {
+				switch {
+				case existing.Fingerprint != key.Fingerprint:
+					return c.JSON(http.StatusConflict, map[string]string{"error": "Idempotency-Key was already used for a different request"})
+				case existing.StatusCode == 0:
+					return c.JSON(http.StatusConflict, map[string]string{"error": "A request with this Idempotency-Key is still in progress"})
+				}
+				c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+				return c.Blob(existing.StatusCode, existing.ContentType, existing.Body)
+			}
+
+			res := c.Response()
+			recorder := &responseRecorder{ResponseWriter: res.Writer}
+			res.Writer = recorder
+
+			// Deferred so that it also runs when the handler panics, and
+			// the panic carries on up to Recover
+			stored := false
+			defer func() {
+				res.Writer = recorder.ResponseWriter
+				if stored {
+					return
+				}
+				if err := store.Release(key); err != nil {
+					log.Printf("failed to release idempotency key %d: %v", key.ID, err)
+				}
+			}()
+
+			if err := next(c); err != nil {
+				return err
+			}
+			if !res.Committed || res.Status >= http.StatusInternalServerError {
+				return nil
+			}
+
+			key.StatusCode = res.Status
+			key.ContentType = res.Header().Get(echo.HeaderContentType)
+			key.Body = recorder.body.Bytes()
+			key.ExpiresAt = time.Now().Add(ttl)
+			if err := store.Complete(key); err != nil {
+				// The response has gone out already; a retry will see the
+				// key as still in progress until its lease runs out
+				log.Printf("failed to store response for idempotency key %d: %v", key.ID, err)
+			}
+			stored = true
+			return nil
+		}
+	}
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint identifies a request by its method, path, query and body.
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, req.Method+" "+req.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of everything written to the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
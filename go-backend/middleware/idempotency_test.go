@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Main(m))
+}
+
+// newIdempotentServer serves handler at POST /things behind Recover and
+// Idempotency, as the user it creates and returns.
+func newIdempotentServer(t *testing.T, db *database.DB, handler echo.HandlerFunc) (*echo.Echo, int) {
+	t.Helper()
+	user := &models.User{Name: "Retrier", Email: fmt.Sprintf("retrier%d@example.com", time.Now().UnixNano()), PasswordHash: "x"}
+	if err := models.NewUserRepository(db).Create(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(echomiddleware.Recover())
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user_id", user.ID)
+			return next(c)
+		}
+	})
+	e.Use(Idempotency(models.NewIdempotencyKeyRepository(db), time.Hour, time.Minute))
+	e.POST("/things", handler)
+	return e, user.ID
+}
+
+func post(e *echo.Echo, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(HeaderIdempotencyKey, key)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplaysCompletedResponse(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		var calls atomic.Int64
+		e, _ := newIdempotentServer(t, db, func(c echo.Context) error {
+			n := calls.Add(1)
+			return c.JSON(http.StatusCreated, map[string]int64{"id": n})
+		})
+
+		first := post(e, "k1", `{"amount":100}`)
+		second := post(e, "k1", `{"amount":100}`)
+
+		if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+			t.Fatalf("status = %d then %d, want 201 twice", first.Code, second.Code)
+		}
+		if second.Body.String() != first.Body.String() {
+			t.Errorf("replayed body = %q, want %q", second.Body.String(), first.Body.String())
+		}
+		if second.Header().Get(HeaderIdempotentReplayed) != "true" {
+			t.Errorf("replay lacks the %s header", HeaderIdempotentReplayed)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("handler ran %d times, want 1", n)
+		}
+
+		if rec := post(e, "k1", `{"amount":200}`); rec.Code != http.StatusConflict {
+			t.Errorf("key reused for another body: status = %d, want 409", rec.Code)
+		}
+	})
+}
+
+func TestIdempotencyConcurrentDuplicate(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		var calls atomic.Int64
+		e, _ := newIdempotentServer(t, db, func(c echo.Context) error {
+			calls.Add(1)
+			close(started)
+			<-finish
+			return c.JSON(http.StatusCreated, map[string]string{"status": "done"})
+		})
+
+		var wg sync.WaitGroup
+		var first *httptest.ResponseRecorder
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first = post(e, "k1", `{}`)
+		}()
+		<-started
+
+		if rec := post(e, "k1", `{}`); rec.Code != http.StatusConflict {
+			t.Errorf("duplicate while running: status = %d, want 409", rec.Code)
+		}
+
+		close(finish)
+		wg.Wait()
+		if first.Code != http.StatusCreated {
+			t.Errorf("first request: status = %d, want 201", first.Code)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("handler ran %d times, want 1", n)
+		}
+	})
+}
+
+func TestIdempotencyReleasesKey(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(c echo.Context) error
+	}{
+		{"error", func(c echo.Context) error { return echo.NewHTTPError(http.StatusBadGateway, "upstream down") }},
+		{"server error response", func(c echo.Context) error {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "boom"})
+		}},
+		{"panic", func(c echo.Context) error { panic("boom") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+				var calls atomic.Int64
+				e, _ := newIdempotentServer(t, db, func(c echo.Context) error {
+					if calls.Add(1) == 1 {
+						return tt.fail(c)
+					}
+					return c.JSON(http.StatusCreated, map[string]string{"status": "done"})
+				})
+
+				if rec := post(e, "k1", `{}`); rec.Code < http.StatusInternalServerError {
+					t.Fatalf("failing request: status = %d, want a server error", rec.Code)
+				}
+				rec := post(e, "k1", `{}`)
+				if rec.Code != http.StatusCreated {
+					t.Fatalf("retry: status = %d, want 201", rec.Code)
+				}
+				if rec.Header().Get(HeaderIdempotentReplayed) != "" {
+					t.Error("retry was replayed instead of run")
+				}
+				if n := calls.Load(); n != 2 {
+					t.Errorf("handler ran %d times, want 2", n)
+				}
+			})
+		})
+	}
+}
+
+func TestIdempotencyLeaseExpires(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		e, userID := newIdempotentServer(t, db, func(c echo.Context) error {
+			return c.JSON(http.StatusCreated, map[string]string{"status": "done"})
+		})
+
+		// A reservation left behind by a request that died with the server
+		stale := &models.IdempotencyKey{
+			UserID:      userID,
+			Key:         "k1",
+			Fingerprint: "abandoned",
+			ExpiresAt:   time.Now().Add(-time.Second),
+		}
+		if existing, err := models.NewIdempotencyKeyRepository(db).Reserve(stale); err != nil || existing != nil {
+			t.Fatalf("Reserve = %v, %v", existing, err)
+		}
+
+		if rec := post(e, "k1", `{}`); rec.Code != http.StatusCreated {
+			t.Fatalf("retry after the lease: status = %d, want 201", rec.Code)
+		}
+	})
+}
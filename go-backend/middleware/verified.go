@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+)
+
+type UserStore interface {
+	GetByID(id int) (*models.User, error)
+}
+
+// RequireVerifiedEmail limits accounts with an unverified email address
+// to the given routes. A route is a path as registered with Echo, such as
+// "/api/goals/:id", optionally prefixed by an HTTP method ("GET /api/goals").
+// A trailing "*" matches any suffix. It must run after JWTMiddleware.
+func RequireVerifiedEmail(users UserStore, allowedRoutes []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if routeAllowed(allowedRoutes, c.Request().Method, c.Path()) {
+				return next(c)
+			}
+
+			userID, ok := c.Get("user_id").(int)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+			}
+			user, err := users.GetByID(userID)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+			}
+			if !user.IsEmailVerified() {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Email address is not verified"})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func routeAllowed(patterns []string, method, path string) bool {
+	for _, pattern := range patterns {
+		if m, p, found := strings.Cut(pattern, " "); found {
+			if !strings.EqualFold(m, method) {
+				continue
+			}
+			pattern = strings.TrimSpace(p)
+		}
+
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const migrateUsage = `Usage: server [server flags] migrate [flags] <command>
+
+Commands:
+  up       apply all pending migrations
+  down     roll back the last migration (see -steps)
+  status   list migrations and whether they are applied
+  verify   check applied migrations against their checksums
+
+Flags:
+`
+
+// runMigrate implements the "migrate" subcommand and returns the exit code.
+func runMigrate(db *database.DB, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the SQL instead of running it")
+	steps := flags.Int("steps", 1, "number of migrations to roll back with down")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), migrateUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	migrator, err := database.NewMigrator(db, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	migrator.DryRun = *dryRun
+
+	switch flags.Arg(0) {
+	case "up":
+		var applied []database.Migration
+		applied, err = migrator.Up()
+		if err == nil && len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+	case "down":
+		_, err = migrator.Down(*steps)
+	case "status":
+		var statuses []database.MigrationStatus
+		statuses, err = migrator.Status()
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, state)
+		}
+		if errors.Is(err, database.ErrNotInitialised) {
+			fmt.Println("database is not initialised; run \"migrate up\" to create it")
+			err = nil
+		}
+	case "verify":
+		err = migrator.Verify()
+		if err == nil {
+			fmt.Println("all applied migrations match their checksums")
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
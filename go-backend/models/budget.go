@@ -0,0 +1,138 @@
+package models
+
+import (
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	// RolloverNone starts every month afresh
+	RolloverNone = "none"
+	// RolloverUnspent carries what is left of a month into the next
+	RolloverUnspent = "unspent"
+	// RolloverAll carries overspending into the next month as well
+	RolloverAll = "all"
+)
+
+// SourceSurplus marks a deposit moved into a goal from the user's budget
+// surplus; see EntryRepository.Surplus.
+const SourceSurplus = "surplus"
+
+// Budget is what the user plans to spend in a category each calendar
+// month, UTC, from the month it was set in until the category's budget is
+// next changed. Changes take effect from the month they are made in, so
+// earlier months keep the budget they had.
+type Budget struct {
+	CategoryID int       `json:"category_id" db:"category_id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Currency   string    `json:"currency" db:"currency"`
+	Amount     Money     `json:"amount" db:"amount"`
+	Rollover   string    `json:"rollover" db:"rollover"`
+	StartsAt   time.Time `json:"starts_at" db:"starts_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// BudgetHistory is every version of one category's budget, oldest first.
+type BudgetHistory []Budget
+
+// At returns the budget in effect in the month starting at month, or nil
+// if the category had none yet.
+func (h BudgetHistory) At(month time.Time) *Budget {
+	for i := len(h) - 1; i >= 0; i-- {
+		if !h[i].StartsAt.After(month) {
+			return &h[i]
+		}
+	}
+	return nil
+}
+
+// CarriedOver is what earlier months pass on to the month starting at
+// month, each under the budget it had then, given what was spent in each
+// month in the currency of that month's budget, keyed by the start of the
+// month. A month under RolloverNone, or with a budget in another currency
+// than month's, passes nothing on. The amount is in the currency of
+// month's budget and negative if overspending is carried.
+func (h BudgetHistory) CarriedOver(month time.Time, spent map[time.Time]int64) Money {
+	current := h.At(month)
+	if current == nil {
+		return Money{}
+	}
+	carried := int64(0)
+	if current.Rollover == RolloverNone {
+		return NewMoney(carried, current.Currency)
+	}
+	for m := MonthStart(h[0].StartsAt); m.Before(month); m = NextMonth(m) {
+		b := h.At(m)
+		if b.Rollover == RolloverNone || b.Currency != current.Currency {
+			carried = 0
+			continue
+		}
+		carried += b.Amount.Amount - spent[m]
+		if carried < 0 && b.Rollover == RolloverUnspent {
+			carried = 0
+		}
+	}
+	return NewMoney(carried, current.Currency)
+}
+
+type BudgetRepository struct {
+	db *database.DB
+}
+
+func NewBudgetRepository(db *database.DB) *BudgetRepository {
+	return &BudgetRepository{db: db}
+}
+
+// GetByUserID returns the history of each of the user's budgets, keyed by
+// category ID.
+func (r *BudgetRepository) GetByUserID(userID int) (map[int]BudgetHistory, error) {
+	query := `
+		SELECT category_id, user_id, currency, amount, rollover, starts_at, updated_at
+		FROM budget_periods
+		WHERE user_id = ?
+		ORDER BY category_id, starts_at
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make(map[int]BudgetHistory)
+	for rows.Next() {
+		var b Budget
+		err := rows.Scan(&b.CategoryID, &b.UserID, &b.Currency, &b.Amount, &b.Rollover, &b.StartsAt, &b.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		b.Amount.Currency = b.Currency
+		histories[b.CategoryID] = append(histories[b.CategoryID], b)
+	}
+	return histories, rows.Err()
+}
+
+// Set changes the category's budget from this month on. Setting it again
+// in the same month replaces that month's version.
+func (r *BudgetRepository) Set(b *Budget) error {
+	b.Currency = b.Amount.Currency
+	b.UpdatedAt = time.Now()
+	b.StartsAt = MonthStart(b.UpdatedAt)
+	query := `
+		INSERT INTO budget_periods (category_id, user_id, starts_at, currency, amount, rollover, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (category_id, starts_at) DO UPDATE SET
+			currency = excluded.currency,
+			amount = excluded.amount,
+			rollover = excluded.rollover,
+			updated_at = excluded.updated_at
+	`
+	_, err := r.db.Exec(query, b.CategoryID, b.UserID, b.StartsAt, b.Currency, b.Amount, b.Rollover, b.UpdatedAt)
+	return err
+}
+
+// Delete removes the category's budget, including its history.
+func (r *BudgetRepository) Delete(categoryID int) error {
+	_, err := r.db.Exec(`DELETE FROM budget_periods WHERE category_id = ?`, categoryID)
+	return err
+}
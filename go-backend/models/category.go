@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryExists   = errors.New("a category with this name already exists")
+)
+
+// Category groups a user's income and spending, and is what budgets are
+// set for. Names are unique per user, ignoring case.
+type Category struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CategoryRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewCategoryRepository(db *database.DB) *CategoryRepository {
+	return &CategoryRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+// Create stores a category, or fails with ErrCategoryExists.
+func (r *CategoryRepository) Create(category *Category) error {
+	category.CreatedAt = time.Now()
+
+	return r.uow.Do(func(tx DBTX) error {
+		if err := checkCategoryName(tx, category); err != nil {
+			return err
+		}
+		query := `INSERT INTO categories (user_id, name, created_at) VALUES (?, ?, ?) RETURNING id`
+		return tx.QueryRow(query, category.UserID, category.Name, category.CreatedAt).Scan(&category.ID)
+	})
+}
+
+func (r *CategoryRepository) GetByID(id int) (*Category, error) {
+	category := &Category{}
+	query := `SELECT id, user_id, name, created_at FROM categories WHERE id = ?`
+	err := r.db.QueryRow(query, id).Scan(&category.ID, &category.UserID, &category.Name, &category.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+func (r *CategoryRepository) GetByUserID(userID int) ([]Category, error) {
+	query := `SELECT id, user_id, name, created_at FROM categories WHERE user_id = ? ORDER BY name, id`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.UserID, &category.Name, &category.CreatedAt); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// Rename changes the category's name, or fails with ErrCategoryExists.
+func (r *CategoryRepository) Rename(category *Category) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if err := checkCategoryName(tx, category); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE categories SET name = ? WHERE id = ?`, category.Name, category.ID)
+		return err
+	})
+}
+
+// Delete removes the category and its budget. Its entries stay, without a
+// category.
+func (r *CategoryRepository) Delete(id int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if _, err := tx.Exec(`UPDATE entries SET category_id = NULL WHERE category_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM budget_periods WHERE category_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM categories WHERE id = ?`, id)
+		return err
+	})
+}
+
+// checkCategoryName returns ErrCategoryExists if the user has another
+// category by the same name.
+func checkCategoryName(q DBTX, category *Category) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM categories WHERE user_id = ? AND LOWER(name) = ? AND id <> ?)`
+	err := q.QueryRow(query, category.UserID, strings.ToLower(category.Name), category.ID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrCategoryExists
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+// ChildLimits caps what a child account may ask to withdraw, in Currency.
+// A nil limit is no limit. Requests beyond a limit are refused before a
+// parent ever sees them.
+type ChildLimits struct {
+	ChildID            int       `json:"child_id" db:"child_id"`
+	Currency           string    `json:"currency" db:"currency"`
+	MaxWithdrawal      *Money    `json:"max_withdrawal" db:"max_withdrawal"`           // per request
+	MonthlyWithdrawals *Money    `json:"monthly_withdrawals" db:"monthly_withdrawals"` // pending and approved, per calendar month
+	UpdatedAt          time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ChildLimitsRepository struct {
+	db *database.DB
+}
+
+func NewChildLimitsRepository(db *database.DB) *ChildLimitsRepository {
+	return &ChildLimitsRepository{db: db}
+}
+
+// Get returns the child's limits, or nil if the parent has set none.
+func (r *ChildLimitsRepository) Get(childID int) (*ChildLimits, error) {
+	var (
+		limits  = &ChildLimits{ChildID: childID}
+		max     sql.NullInt64
+		monthly sql.NullInt64
+	)
+	query := `SELECT currency, max_withdrawal, monthly_withdrawals, updated_at FROM child_limits WHERE child_id = ?`
+	err := r.db.QueryRow(query, childID).Scan(&limits.Currency, &max, &monthly, &limits.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	limits.MaxWithdrawal = nullMoneyPtr(max, limits.Currency)
+	limits.MonthlyWithdrawals = nullMoneyPtr(monthly, limits.Currency)
+	return limits, nil
+}
+
+// Set replaces the child's limits.
+func (r *ChildLimitsRepository) Set(limits *ChildLimits) error {
+	limits.UpdatedAt = time.Now()
+	query := `
+		INSERT INTO child_limits (child_id, currency, max_withdrawal, monthly_withdrawals, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (child_id) DO UPDATE SET
+			currency = excluded.currency,
+			max_withdrawal = excluded.max_withdrawal,
+			monthly_withdrawals = excluded.monthly_withdrawals,
+			updated_at = excluded.updated_at
+	`
+	_, err := r.db.Exec(query, limits.ChildID, limits.Currency, moneyOrNull(limits.MaxWithdrawal),
+		moneyOrNull(limits.MonthlyWithdrawals), limits.UpdatedAt)
+	return err
+}
+
+func nullMoneyPtr(n sql.NullInt64, currency string) *Money {
+	if !n.Valid {
+		return nil
+	}
+	m := NewMoney(n.Int64, currency)
+	return &m
+}
+
+func moneyOrNull(m *Money) sql.NullInt64 {
+	if m == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: m.Amount, Valid: true}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	EntryIncome  = "income"
+	EntryExpense = "expense"
+)
+
+var (
+	ErrEntryNotFound = errors.New("entry not found")
+	// ErrInsufficientSurplus means a move would take more than the user's
+	// surplus; see Ledger.MoveSurplus.
+	ErrInsufficientSurplus = errors.New("insufficient surplus")
+)
+
+// Entry is money a user earned or spent outside of their goals. What
+// they earn beyond what they spend is their surplus, which they can move
+// into goals; see SourceSurplus.
+type Entry struct {
+	ID           int       `json:"id" db:"id"`
+	UserID       int       `json:"user_id" db:"user_id"`
+	CategoryID   *int      `json:"category_id" db:"category_id"` // nil if uncategorized
+	CategoryName string    `json:"category_name,omitempty" db:"category_name"`
+	Type         string    `json:"type" db:"type"` // EntryIncome or EntryExpense
+	Currency     string    `json:"currency" db:"currency"`
+	Amount       Money     `json:"amount" db:"amount"`
+	Description  string    `json:"description" db:"description"`
+	OccurredAt   time.Time `json:"occurred_at" db:"occurred_at"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+const entrySelect = `
+	SELECT e.id, e.user_id, e.category_id, c.name, e.type, e.currency, e.amount, e.description,
+		e.occurred_at, e.created_at
+	FROM entries e
+	LEFT JOIN categories c ON c.id = e.category_id
+`
+
+func scanEntry(row rowScanner, e *Entry) error {
+	var (
+		categoryID   sql.NullInt64
+		categoryName sql.NullString
+		description  sql.NullString
+	)
+	err := row.Scan(&e.ID, &e.UserID, &categoryID, &categoryName, &e.Type, &e.Currency, &e.Amount,
+		&description, &e.OccurredAt, &e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	e.Amount.Currency = e.Currency
+	if categoryID.Valid {
+		id := int(categoryID.Int64)
+		e.CategoryID = &id
+	}
+	e.CategoryName = categoryName.String
+	e.Description = description.String
+	return nil
+}
+
+type EntryRepository struct {
+	db *database.DB
+}
+
+func NewEntryRepository(db *database.DB) *EntryRepository {
+	return &EntryRepository{db: db}
+}
+
+func (r *EntryRepository) Create(e *Entry) error {
+	e.Currency = e.Amount.Currency
+	e.CreatedAt = time.Now()
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = e.CreatedAt
+	}
+
+	query := `
+		INSERT INTO entries (user_id, category_id, type, currency, amount, description, occurred_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRow(query, e.UserID, e.CategoryID, e.Type, e.Currency, e.Amount, e.Description,
+		e.OccurredAt, e.CreatedAt).Scan(&e.ID)
+}
+
+func (r *EntryRepository) GetByID(id int) (*Entry, error) {
+	e := &Entry{}
+	err := scanEntry(r.db.QueryRow(entrySelect+`WHERE e.id = ?`, id), e)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetBetween returns the user's entries that occurred at or after from
+// and before to, newest first.
+func (r *EntryRepository) GetBetween(userID int, from, to time.Time) ([]Entry, error) {
+	query := entrySelect + `
+		WHERE e.user_id = ? AND e.occurred_at >= ? AND e.occurred_at < ?
+		ORDER BY e.occurred_at DESC, e.id DESC
+	`
+	rows, err := r.db.Query(query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (r *EntryRepository) Delete(id int) error {
+	_, err := r.db.Exec(`DELETE FROM entries WHERE id = ?`, id)
+	return err
+}
+
+// Surplus is what the user has earned beyond what they spent, less what
+// they have moved into goals since, one total per currency. Totals may be
+// negative.
+func (r *EntryRepository) Surplus(userID int) ([]Money, error) {
+	return surplus(r.db, userID)
+}
+
+func surplus(q DBTX, userID int) ([]Money, error) {
+	query := `
+		SELECT currency, SUM(amount)
+		FROM (
+			SELECT currency, amount FROM entries WHERE user_id = ? AND type = ?
+			UNION ALL
+			SELECT currency, -amount FROM entries WHERE user_id = ? AND type = ?
+			UNION ALL
+			SELECT currency, -amount FROM transactions WHERE user_id = ? AND source = ? AND voided_at IS NULL
+		) s
+		GROUP BY currency
+	`
+	rows, err := q.Query(query, userID, EntryIncome, userID, EntryExpense, userID, SourceSurplus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []Money{}
+	for rows.Next() {
+		var total Money
+		if err := rows.Scan(&total.Currency, &total); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
@@ -2,54 +2,177 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var ErrGoalNotInTrash = errors.New("goal is not in the trash")
+
+const (
+	LockUntilDeadline = "deadline"
+	LockUntilTarget   = "target"
 )
 
 type Goal struct {
 	ID            int       `json:"id" db:"id"`
-	UserID        int       `json:"user_id" db:"user_id"`
+	UserID        int       `json:"user_id" db:"user_id"` // who created it; see GoalMember for access
 	Title         string    `json:"title" db:"title"`
-	TargetAmount  float64   `json:"target_amount" db:"target_amount"`
-	CurrentAmount float64   `json:"current_amount" db:"current_amount"`
+	Currency      string    `json:"currency" db:"currency"`
+	TargetAmount  Money     `json:"target_amount" db:"target_amount"`
+	CurrentAmount Money     `json:"current_amount" db:"current_amount"`
 	Deadline      time.Time `json:"deadline" db:"deadline"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	// DeletedAt is set while the goal is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Lock      *GoalLock  `json:"lock,omitempty" db:"-"`
+}
+
+// GoalLock keeps the money in a goal until its deadline passes or it
+// reaches its target, depending on Until. Until then, money only leaves
+// through a withdrawal request that waits out the cooling-off period, and
+// PenaltyBps of it moves to the penalty goal, if there is one.
+type GoalLock struct {
+	Until           string `json:"until"`
+	CoolingOffHours int    `json:"cooling_off_hours"`
+	PenaltyBps      int    `json:"penalty_bps,omitempty"` // hundredths of a percent
+	PenaltyGoalID   *int   `json:"penalty_goal_id,omitempty"`
+}
+
+// CoolingOff is how long a withdrawal from the locked goal waits.
+func (l *GoalLock) CoolingOff() time.Duration {
+	return time.Duration(l.CoolingOffHours) * time.Hour
+}
+
+// Penalty is the part of a withdrawal of amount that goes to the penalty
+// goal.
+func (l *GoalLock) Penalty(amount Money) Money {
+	if l.PenaltyGoalID == nil {
+		return NewMoney(0, amount.Currency)
+	}
+	return NewMoney(amount.Amount*int64(l.PenaltyBps)/10000, amount.Currency)
+}
+
+// Locked reports whether the goal's lock is in force at now.
+func (g *Goal) Locked(now time.Time) bool {
+	if g.Lock == nil {
+		return false
+	}
+	if g.Lock.Until == LockUntilTarget {
+		return g.CurrentAmount.Amount < g.TargetAmount.Amount
+	}
+	return now.Before(g.Deadline)
 }
 
+const goalColumns = `id, user_id, title, currency, target_amount, current_amount, deadline, created_at, deleted_at,
+	lock_until, cooling_off_hours, penalty_bps, penalty_goal_id`
+
+// memberGoals restricts a query on a table with a goal_id column to the
+// goals that the user given as its argument is a member of and that are
+// not in the trash.
+const memberGoals = `goal_id IN (
+	SELECT m.goal_id FROM goal_members m JOIN goals g ON g.id = m.goal_id
+	WHERE m.user_id = ? AND g.deleted_at IS NULL)`
+
+// isMember restricts a query on goals to those the user given as its
+// argument is a member of.
+const isMember = `id IN (SELECT goal_id FROM goal_members WHERE user_id = ?)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanGoal(row rowScanner, goal *Goal) error {
+	var (
+		deletedAt                                sql.NullTime
+		lockUntil                                sql.NullString
+		coolingOffHours, penaltyBps, penaltyGoal sql.NullInt64
+	)
+	err := row.Scan(
+		&goal.ID, &goal.UserID, &goal.Title, &goal.Currency, &goal.TargetAmount,
+		&goal.CurrentAmount, &goal.Deadline, &goal.CreatedAt, &deletedAt,
+		&lockUntil, &coolingOffHours, &penaltyBps, &penaltyGoal,
+	)
+	if err != nil {
+		return err
+	}
+	goal.TargetAmount.Currency = goal.Currency
+	goal.CurrentAmount.Currency = goal.Currency
+	goal.DeletedAt = nullTimePtr(deletedAt)
+	goal.Lock = nil
+	if lockUntil.Valid {
+		goal.Lock = &GoalLock{
+			Until:           lockUntil.String,
+			CoolingOffHours: int(coolingOffHours.Int64),
+			PenaltyBps:      int(penaltyBps.Int64),
+		}
+		if penaltyGoal.Valid {
+			id := int(penaltyGoal.Int64)
+			goal.Lock.PenaltyGoalID = &id
+		}
+	}
+	return nil
+}
+
+// GoalRepository reads and writes goals. Goals in the trash are left out
+// of everything but the trash methods.
 type GoalRepository struct {
-	db *sql.DB
+	db  *database.DB
+	uow *UnitOfWork
 }
 
-func NewGoalRepository(db *sql.DB) *GoalRepository {
-	return &GoalRepository{db: db}
+func NewGoalRepository(db *database.DB) *GoalRepository {
+	return &GoalRepository{db: db, uow: NewUnitOfWork(db)}
 }
 
+// Create stores a new goal and makes its creator the owner.
 func (r *GoalRepository) Create(goal *Goal) error {
 	query := `
-		INSERT INTO goals (user_id, title, target_amount, current_amount, deadline, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO goals (user_id, title, currency, target_amount, current_amount, deadline, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
 	`
-	result, err := r.db.Exec(query, goal.UserID, goal.Title, goal.TargetAmount, goal.CurrentAmount, goal.Deadline, time.Now())
-	if err != nil {
-		return err
+	if goal.Currency == "" {
+		goal.Currency = DefaultCurrency
 	}
-	
-	id, err := result.LastInsertId()
-	if err != nil {
+	goal.TargetAmount.Currency = goal.Currency
+	goal.CurrentAmount.Currency = goal.Currency
+	goal.CreatedAt = time.Now()
+
+	return r.uow.Do(func(tx DBTX) error {
+		err := tx.QueryRow(query, goal.UserID, goal.Title, goal.Currency, goal.TargetAmount, goal.CurrentAmount, goal.Deadline, goal.CreatedAt).Scan(&goal.ID)
+		if err != nil {
+			return err
+		}
+		if err := insertGoalMember(tx, goal.ID, goal.UserID, RoleOwner, goal.CreatedAt); err != nil {
+			return err
+		}
+
+		// A child's parent owns the goal with them, to look after it and
+		// set up rules that reward saving
+		query := `
+			INSERT INTO goal_members (goal_id, user_id, role, created_at)
+			SELECT ?, parent_id, ?, ? FROM users WHERE id = ? AND parent_id IS NOT NULL
+		`
+		_, err = tx.Exec(query, goal.ID, RoleOwner, goal.CreatedAt, goal.UserID)
 		return err
-	}
-	
-	goal.ID = int(id)
-	return nil
+	})
 }
 
 func (r *GoalRepository) GetByUserID(userID int) ([]Goal, error) {
 	query := `
-		SELECT id, user_id, title, target_amount, current_amount, deadline, created_at
+		SELECT ` + goalColumns + `
 		FROM goals
-		WHERE user_id = ?
+		WHERE ` + isMember + ` AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`
-	rows, err := r.db.Query(query, userID)
+	return r.query(query, userID)
+}
+
+func (r *GoalRepository) query(query string, args ...interface{}) ([]Goal, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -58,30 +181,97 @@ func (r *GoalRepository) GetByUserID(userID int) ([]Goal, error) {
 	var goals []Goal
 	for rows.Next() {
 		var goal Goal
-		err := rows.Scan(
-			&goal.ID, &goal.UserID, &goal.Title, &goal.TargetAmount,
-			&goal.CurrentAmount, &goal.Deadline, &goal.CreatedAt,
-		)
-		if err != nil {
+		if err := scanGoal(rows, &goal); err != nil {
 			return nil, err
 		}
 		goals = append(goals, goal)
 	}
-	return goals, nil
+	return goals, rows.Err()
+}
+
+var goalSortColumns = map[string]sortKind{
+	"created_at":     sortTime,
+	"deadline":       sortTime,
+	"target_amount":  sortInt,
+	"current_amount": sortInt,
+	"title":          sortString,
+}
+
+// ListByUserID returns one page of the goals the user is a member of and
+// the cursor of the
+// next page, which is empty on the last page. The amount filters apply to
+// the target amount and the search to the title.
+func (r *GoalRepository) ListByUserID(userID int, opts ListOptions) ([]Goal, string, error) {
+	q := &listQuery{table: "goals", columns: goalColumns, sortable: goalSortColumns}
+	q.filter(isMember+" AND deleted_at IS NULL", userID)
+	if !opts.CreatedFrom.IsZero() {
+		q.filter("created_at >= ?", opts.CreatedFrom)
+	}
+	if !opts.CreatedTo.IsZero() {
+		q.filter("created_at < ?", opts.CreatedTo)
+	}
+	if opts.MinAmount != nil {
+		q.filter("target_amount >= ?", *opts.MinAmount)
+	}
+	if opts.MaxAmount != nil {
+		q.filter("target_amount <= ?", *opts.MaxAmount)
+	}
+	if opts.Search != "" {
+		q.search("title", opts.Search)
+	}
+
+	query, args, err := q.build(&opts)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		var goal Goal
+		if err := scanGoal(rows, &goal); err != nil {
+			return nil, "", err
+		}
+		goals = append(goals, goal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(goals) <= opts.Limit {
+		return goals, "", nil
+	}
+
+	goals = goals[:opts.Limit]
+	last := goals[len(goals)-1]
+	var value interface{}
+	switch opts.SortBy {
+	case "deadline":
+		value = last.Deadline
+	case "target_amount":
+		value = last.TargetAmount.Amount
+	case "current_amount":
+		value = last.CurrentAmount.Amount
+	case "title":
+		value = last.Title
+	default:
+		value = last.CreatedAt
+	}
+	next, err := encodeCursor(&opts, value, last.ID)
+	return goals, next, err
 }
 
 func (r *GoalRepository) GetByID(id int) (*Goal, error) {
 	goal := &Goal{}
 	query := `
-		SELECT id, user_id, title, target_amount, current_amount, deadline, created_at
+		SELECT ` + goalColumns + `
 		FROM goals
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
-	err := r.db.QueryRow(query, id).Scan(
-		&goal.ID, &goal.UserID, &goal.Title, &goal.TargetAmount,
-		&goal.CurrentAmount, &goal.Deadline, &goal.CreatedAt,
-	)
-	if err != nil {
+	if err := scanGoal(r.db.QueryRow(query, id), goal); err != nil {
 		return nil, err
 	}
 	return goal, nil
@@ -89,22 +279,133 @@ func (r *GoalRepository) GetByID(id int) (*Goal, error) {
 
 func (r *GoalRepository) Update(goal *Goal) error {
 	query := `
-		UPDATE goals 
+		UPDATE goals
 		SET title = ?, target_amount = ?, deadline = ?
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
 	_, err := r.db.Exec(query, goal.Title, goal.TargetAmount, goal.Deadline, goal.ID)
 	return err
 }
 
-func (r *GoalRepository) Delete(id int) error {
-	query := `DELETE FROM goals WHERE id = ?`
-	_, err := r.db.Exec(query, id)
+// SetLock replaces the goal's lock. A nil lock unlocks it.
+func (r *GoalRepository) SetLock(id int, lock *GoalLock) error {
+	var (
+		until                  sql.NullString
+		coolingOff, penaltyBps sql.NullInt64
+		penaltyGoalID          *int
+	)
+	if lock != nil {
+		until = sql.NullString{String: lock.Until, Valid: true}
+		coolingOff = sql.NullInt64{Int64: int64(lock.CoolingOffHours), Valid: true}
+		penaltyBps = sql.NullInt64{Int64: int64(lock.PenaltyBps), Valid: true}
+		penaltyGoalID = lock.PenaltyGoalID
+	}
+
+	query := `
+		UPDATE goals
+		SET lock_until = ?, cooling_off_hours = ?, penalty_bps = ?, penalty_goal_id = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := r.db.Exec(query, until, coolingOff, penaltyBps, penaltyGoalID, id)
 	return err
 }
 
-func (r *GoalRepository) UpdateCurrentAmount(id int, amount float64) error {
-	query := `UPDATE goals SET current_amount = ? WHERE id = ?`
-	_, err := r.db.Exec(query, amount, id)
+// Delete moves the goal to the trash. Its transactions and schedules are
+// kept until it is purged.
+func (r *GoalRepository) Delete(id int) error {
+	query := `UPDATE goals SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`
+	_, err := r.db.Exec(query, time.Now(), id)
 	return err
 }
+
+// GetDeletedByUserID returns the goals in the trash that the user owns,
+// most recently deleted first.
+func (r *GoalRepository) GetDeletedByUserID(userID int) ([]Goal, error) {
+	query := `
+		SELECT ` + goalColumns + `
+		FROM goals
+		WHERE id IN (SELECT goal_id FROM goal_members WHERE user_id = ? AND role = ?)
+			AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`
+	return r.query(query, userID, RoleOwner)
+}
+
+// GetDeletedByID returns a goal in the trash.
+func (r *GoalRepository) GetDeletedByID(id int) (*Goal, error) {
+	goal := &Goal{}
+	query := `
+		SELECT ` + goalColumns + `
+		FROM goals
+		WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	if err := scanGoal(r.db.QueryRow(query, id), goal); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+// Restore takes a goal out of the trash.
+func (r *GoalRepository) Restore(id int) error {
+	result, err := r.db.Exec(`UPDATE goals SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrGoalNotInTrash
+	}
+	return nil
+}
+
+// Purge permanently removes a goal in the trash with its transactions and
+// schedules.
+func (r *GoalRepository) Purge(id int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		n, err := purgeGoals(tx, "id = ? AND deleted_at IS NOT NULL", id)
+		if err == nil && n == 0 {
+			return ErrGoalNotInTrash
+		}
+		return err
+	})
+}
+
+// PurgeDeletedBefore permanently removes every goal put in the trash
+// before the cutoff, as Purge does, and returns how many there were.
+func (r *GoalRepository) PurgeDeletedBefore(cutoff time.Time) (int, error) {
+	var purged int
+	err := r.uow.Do(func(tx DBTX) error {
+		var err error
+		purged, err = purgeGoals(tx, "deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+		return err
+	})
+	return purged, err
+}
+
+// purgeGoals deletes the goals matching the condition and every row that
+// belongs to them, children first so foreign keys hold throughout. Locks
+// and withdrawals that would pay a penalty into them no longer do.
+func purgeGoals(tx DBTX, condition string, args ...interface{}) (int, error) {
+	goals := `SELECT id FROM goals WHERE ` + condition
+	for _, table := range []string{"goals", "withdrawal_requests"} {
+		query := `UPDATE ` + table + ` SET penalty_goal_id = NULL WHERE penalty_goal_id IN (` + goals + `)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return 0, err
+		}
+	}
+	for _, table := range []string{"withdrawal_requests", "transactions", "goal_rules", "recurring_contributions", "goal_members", "goal_invitations"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE goal_id IN (`+goals+`)`, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM goals WHERE `+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
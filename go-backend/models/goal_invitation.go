@@ -0,0 +1,209 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationPending  = errors.New("an invitation to this address is already pending")
+	ErrAlreadyMember      = errors.New("the user is already a member of the goal")
+)
+
+// GoalInvitation asks whoever owns Email to join a goal with Role. It can
+// be answered until ExpiresAt by the account with that email address.
+type GoalInvitation struct {
+	ID          int        `json:"id" db:"id"`
+	GoalID      int        `json:"goal_id" db:"goal_id"`
+	GoalTitle   string     `json:"goal_title" db:"goal_title"`
+	InviterID   int        `json:"inviter_id" db:"inviter_id"`
+	InviterName string     `json:"inviter_name" db:"inviter_name"`
+	Email       string     `json:"email" db:"email"`
+	Role        string     `json:"role" db:"role"`
+	Status      string     `json:"status" db:"status"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty" db:"responded_at"`
+}
+
+const invitationSelect = `
+	SELECT i.id, i.goal_id, g.title, i.inviter_id, u.name, i.email, i.role, i.status,
+		i.created_at, i.expires_at, i.responded_at
+	FROM goal_invitations i
+	JOIN goals g ON g.id = i.goal_id
+	JOIN users u ON u.id = i.inviter_id
+`
+
+func scanInvitation(row rowScanner, inv *GoalInvitation) error {
+	var respondedAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.GoalID, &inv.GoalTitle, &inv.InviterID, &inv.InviterName, &inv.Email,
+		&inv.Role, &inv.Status, &inv.CreatedAt, &inv.ExpiresAt, &respondedAt)
+	if err != nil {
+		return err
+	}
+	inv.RespondedAt = nullTimePtr(respondedAt)
+	return nil
+}
+
+type GoalInvitationRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewGoalInvitationRepository(db *database.DB) *GoalInvitationRepository {
+	return &GoalInvitationRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+// Create stores a pending invitation. It fails with ErrAlreadyMember if
+// the address belongs to a member of the goal, and ErrInvitationPending if
+// the address has an unanswered invitation to it already.
+func (r *GoalInvitationRepository) Create(inv *GoalInvitation) error {
+	inv.Status = InvitationPending
+	inv.CreatedAt = time.Now()
+
+	return r.uow.Do(func(tx DBTX) error {
+		var exists bool
+		query := `
+			SELECT EXISTS (
+				SELECT 1 FROM goal_members m JOIN users u ON u.id = m.user_id
+				WHERE m.goal_id = ? AND LOWER(u.email) = ?
+			)
+		`
+		if err := tx.QueryRow(query, inv.GoalID, inv.Email).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyMember
+		}
+
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM goal_invitations
+				WHERE goal_id = ? AND email = ? AND status = ? AND expires_at > ?
+			)
+		`
+		if err := tx.QueryRow(query, inv.GoalID, inv.Email, InvitationPending, inv.CreatedAt).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrInvitationPending
+		}
+
+		query = `
+			INSERT INTO goal_invitations (goal_id, inviter_id, email, role, status, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`
+		return tx.QueryRow(query, inv.GoalID, inv.InviterID, inv.Email, inv.Role, inv.Status,
+			inv.CreatedAt, inv.ExpiresAt).Scan(&inv.ID)
+	})
+}
+
+func (r *GoalInvitationRepository) GetByID(id int) (*GoalInvitation, error) {
+	inv := &GoalInvitation{}
+	err := scanInvitation(r.db.QueryRow(invitationSelect+`WHERE i.id = ?`, id), inv)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetPendingByGoalID returns the goal's unanswered invitations that have
+// not expired, newest first.
+func (r *GoalInvitationRepository) GetPendingByGoalID(goalID int, now time.Time) ([]GoalInvitation, error) {
+	query := invitationSelect + `
+		WHERE i.goal_id = ? AND i.status = ? AND i.expires_at > ?
+		ORDER BY i.created_at DESC
+	`
+	return r.query(query, goalID, InvitationPending, now)
+}
+
+// GetPendingByEmail returns the unanswered invitations to an address that
+// have not expired, newest first. Invitations to goals in the trash are
+// left out.
+func (r *GoalInvitationRepository) GetPendingByEmail(email string, now time.Time) ([]GoalInvitation, error) {
+	query := invitationSelect + `
+		WHERE i.email = ? AND i.status = ? AND i.expires_at > ? AND g.deleted_at IS NULL
+		ORDER BY i.created_at DESC
+	`
+	return r.query(query, email, InvitationPending, now)
+}
+
+func (r *GoalInvitationRepository) query(query string, args ...interface{}) ([]GoalInvitation, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []GoalInvitation{}
+	for rows.Next() {
+		var inv GoalInvitation
+		if err := scanInvitation(rows, &inv); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// Accept marks a pending invitation accepted and makes the user a member
+// of the goal with the invitation's role.
+func (r *GoalInvitationRepository) Accept(inv *GoalInvitation, userID int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		now := time.Now()
+		if err := respond(tx, inv, InvitationAccepted, now); err != nil {
+			return err
+		}
+		return insertGoalMember(tx, inv.GoalID, userID, inv.Role, now)
+	})
+}
+
+// Decline marks a pending invitation declined.
+func (r *GoalInvitationRepository) Decline(inv *GoalInvitation) error {
+	return respond(r.db, inv, InvitationDeclined, time.Now())
+}
+
+// Revoke withdraws a pending invitation.
+func (r *GoalInvitationRepository) Revoke(inv *GoalInvitation) error {
+	return respond(r.db, inv, InvitationRevoked, time.Now())
+}
+
+// respond moves a pending, unexpired invitation to status. It returns
+// ErrInvitationNotFound if the invitation has been answered or has
+// expired in the meantime.
+func respond(q DBTX, inv *GoalInvitation, status string, now time.Time) error {
+	query := `
+		UPDATE goal_invitations
+		SET status = ?, responded_at = ?
+		WHERE id = ? AND status = ? AND expires_at > ?
+	`
+	result, err := q.Exec(query, status, now, inv.ID, InvitationPending, now)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvitationNotFound
+	}
+	inv.Status = status
+	inv.RespondedAt = &now
+	return nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	RoleOwner       = "owner"
+	RoleContributor = "contributor"
+	RoleViewer      = "viewer"
+)
+
+var (
+	ErrMemberNotFound = errors.New("member not found")
+	ErrLastOwner      = errors.New("a goal must keep at least one owner")
+)
+
+// GoalMember is a user who shares a goal. Name and Email are the user's.
+type GoalMember struct {
+	GoalID    int       `json:"goal_id" db:"goal_id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	Email     string    `json:"email" db:"email"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"joined_at" db:"created_at"`
+}
+
+type GoalMemberRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewGoalMemberRepository(db *database.DB) *GoalMemberRepository {
+	return &GoalMemberRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+// GetRole returns the user's role on the goal, or "" if the user is not a
+// member.
+func (r *GoalMemberRepository) GetRole(goalID, userID int) (string, error) {
+	var role string
+	err := r.db.QueryRow(`SELECT role FROM goal_members WHERE goal_id = ? AND user_id = ?`, goalID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetByGoalID returns the goal's members, owners first, then in the order
+// they joined.
+func (r *GoalMemberRepository) GetByGoalID(goalID int) ([]GoalMember, error) {
+	query := `
+		SELECT m.goal_id, m.user_id, u.name, u.email, m.role, m.created_at
+		FROM goal_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.goal_id = ?
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 ELSE 1 END, m.created_at, m.user_id
+	`
+	rows, err := r.db.Query(query, goalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []GoalMember{}
+	for rows.Next() {
+		var m GoalMember
+		if err := rows.Scan(&m.GoalID, &m.UserID, &m.Name, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// UpdateRole changes a member's role. The last owner cannot step down, and
+// a member who becomes a viewer loses their schedules on the goal.
+func (r *GoalMemberRepository) UpdateRole(goalID, userID int, role string) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if err := checkOwnerRemains(tx, goalID, userID, role); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE goal_members SET role = ? WHERE goal_id = ? AND user_id = ?`, role, goalID, userID); err != nil {
+			return err
+		}
+		if role == RoleViewer {
+			return deleteMemberSchedules(tx, goalID, userID)
+		}
+		return nil
+	})
+}
+
+// Remove takes a member off the goal together with their schedules on it.
+// What they contributed stays. The last owner cannot be removed.
+func (r *GoalMemberRepository) Remove(goalID, userID int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if err := checkOwnerRemains(tx, goalID, userID, ""); err != nil {
+			return err
+		}
+		if err := deleteMemberSchedules(tx, goalID, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM goal_members WHERE goal_id = ? AND user_id = ?`, goalID, userID)
+		return err
+	})
+}
+
+// checkOwnerRemains returns ErrLastOwner if giving the member newRole, or
+// removing them when newRole is "", would leave the goal without an owner.
+func checkOwnerRemains(tx DBTX, goalID, userID int, newRole string) error {
+	var role string
+	err := tx.QueryRow(`SELECT role FROM goal_members WHERE goal_id = ? AND user_id = ?`, goalID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMemberNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if role != RoleOwner || newRole == RoleOwner {
+		return nil
+	}
+
+	var owners int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM goal_members WHERE goal_id = ? AND role = ?`, goalID, RoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func deleteMemberSchedules(tx DBTX, goalID, userID int) error {
+	_, err := tx.Exec(`DELETE FROM recurring_contributions WHERE goal_id = ? AND user_id = ?`, goalID, userID)
+	return err
+}
+
+func insertGoalMember(q DBTX, goalID, userID int, role string, at time.Time) error {
+	query := `
+		INSERT INTO goal_members (goal_id, user_id, role, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (goal_id, user_id) DO NOTHING
+	`
+	_, err := q.Exec(query, goalID, userID, role, at)
+	return err
+}
@@ -0,0 +1,307 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	RuleMatch    = "match"
+	RuleInterest = "interest"
+)
+
+var (
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrRuleChanged means an interest payment was made or the rule
+	// deleted since it was read.
+	ErrRuleChanged = errors.New("rule has changed")
+)
+
+// GoalRule pays money into a goal on behalf of UserID, the member who set
+// it up. A match rule adds RateBps of every deposit other members make; an
+// interest rule adds RateBps of the balance on the first of each month,
+// UTC. Either pays at most MonthlyCap per calendar month, if set.
+type GoalRule struct {
+	ID         int        `json:"id" db:"id"`
+	GoalID     int        `json:"goal_id" db:"goal_id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Kind       string     `json:"kind" db:"kind"`
+	RateBps    int        `json:"rate_bps" db:"rate_bps"` // hundredths of a percent
+	MonthlyCap *Money     `json:"monthly_cap" db:"monthly_cap"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty" db:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty" db:"last_run_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Bonus is what the rule pays on amount, before any cap.
+func (r *GoalRule) Bonus(amount Money) Money {
+	return NewMoney(amount.Amount*int64(r.RateBps)/10000, amount.Currency)
+}
+
+// Describe is the description of the transactions the rule pays in.
+func (r *GoalRule) Describe() string {
+	rate := fmt.Sprintf("%d.%02d%%", r.RateBps/100, r.RateBps%100)
+	if r.Kind == RuleInterest {
+		return rate + " monthly interest"
+	}
+	return rate + " match"
+}
+
+// NextMonth is the first instant of the calendar month after t, in UTC.
+// Interest rules run then.
+func NextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// MonthStart is the first instant of the calendar month of t, in UTC.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// goalRuleSelect reads rules with their goal's currency, which their cap
+// is in.
+const goalRuleSelect = `
+	SELECT r.id, r.goal_id, r.user_id, r.kind, r.rate_bps, r.monthly_cap, r.next_run_at, r.last_run_at,
+		r.created_at, g.currency
+	FROM goal_rules r
+	JOIN goals g ON g.id = r.goal_id
+`
+
+func queryGoalRules(q DBTX, query string, args ...interface{}) ([]GoalRule, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []GoalRule{}
+	for rows.Next() {
+		var (
+			rule                 GoalRule
+			monthlyCap           sql.NullInt64
+			nextRunAt, lastRunAt sql.NullTime
+			currency             string
+		)
+		err := rows.Scan(&rule.ID, &rule.GoalID, &rule.UserID, &rule.Kind, &rule.RateBps, &monthlyCap,
+			&nextRunAt, &lastRunAt, &rule.CreatedAt, &currency)
+		if err != nil {
+			return nil, err
+		}
+		rule.MonthlyCap = nullMoneyPtr(monthlyCap, currency)
+		rule.NextRunAt = nullTimePtr(nextRunAt)
+		rule.LastRunAt = nullTimePtr(lastRunAt)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+type GoalRuleRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewGoalRuleRepository(db *database.DB) *GoalRuleRepository {
+	return &GoalRuleRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+// Create stores a rule. Interest rules first pay at the start of next
+// month.
+func (r *GoalRuleRepository) Create(rule *GoalRule) error {
+	rule.CreatedAt = time.Now()
+	if rule.Kind == RuleInterest {
+		next := NextMonth(rule.CreatedAt)
+		rule.NextRunAt = &next
+	}
+
+	query := `
+		INSERT INTO goal_rules (goal_id, user_id, kind, rate_bps, monthly_cap, next_run_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRow(query, rule.GoalID, rule.UserID, rule.Kind, rule.RateBps,
+		moneyOrNull(rule.MonthlyCap), rule.NextRunAt, rule.CreatedAt).Scan(&rule.ID)
+}
+
+func (r *GoalRuleRepository) GetByID(id int) (*GoalRule, error) {
+	rules, err := queryGoalRules(r.db, goalRuleSelect+`WHERE r.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrRuleNotFound
+	}
+	return &rules[0], nil
+}
+
+func (r *GoalRuleRepository) GetByGoalID(goalID int) ([]GoalRule, error) {
+	return queryGoalRules(r.db, goalRuleSelect+`WHERE r.goal_id = ? ORDER BY r.created_at, r.id`, goalID)
+}
+
+// Update changes the rate and cap of a rule. What it paid already stays.
+func (r *GoalRuleRepository) Update(rule *GoalRule) error {
+	query := `UPDATE goal_rules SET rate_bps = ?, monthly_cap = ? WHERE id = ?`
+	_, err := r.db.Exec(query, rule.RateBps, moneyOrNull(rule.MonthlyCap), rule.ID)
+	return err
+}
+
+// Delete removes the rule. Money it paid stays, still marked as earned,
+// but no longer points at it.
+func (r *GoalRuleRepository) Delete(id int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if _, err := tx.Exec(`UPDATE transactions SET rule_id = NULL WHERE rule_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM goal_rules WHERE id = ?`, id)
+		return err
+	})
+}
+
+// GetDue returns interest rules whose next payment is due at or before
+// now, earliest first.
+func (r *GoalRuleRepository) GetDue(now time.Time, limit int) ([]GoalRule, error) {
+	query := goalRuleSelect + `
+		WHERE r.kind = ? AND r.next_run_at <= ?
+		ORDER BY r.next_run_at, r.id
+		LIMIT ?
+	`
+	return queryGoalRules(r.db, query, RuleInterest, now, limit)
+}
+
+// PayInterest pays the interest due at rule.NextRunAt on the goal's
+// balance and moves the rule on to the next month, in one database
+// transaction. The rule only advances if its next run is still the one
+// that was read, so a month is never paid twice. Goals in the trash earn
+// nothing; the month is skipped. The transaction is nil when nothing was
+// paid.
+func (r *GoalRuleRepository) PayInterest(rule *GoalRule) (*Transaction, error) {
+	due := *rule.NextRunAt
+	next := NextMonth(due)
+
+	var transaction *Transaction
+	err := r.uow.Do(func(tx DBTX) error {
+		query := `UPDATE goal_rules SET next_run_at = ?, last_run_at = ? WHERE id = ? AND next_run_at = ?`
+		result, err := tx.Exec(query, next, due, rule.ID, due)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrRuleChanged
+		}
+
+		var (
+			amount   int64
+			currency string
+		)
+		err = tx.QueryRow(`SELECT current_amount, currency FROM goals WHERE id = ? AND deleted_at IS NULL`, rule.GoalID).
+			Scan(&amount, &currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		interest, err := capBonus(tx, rule, rule.Bonus(NewMoney(amount, currency)), due)
+		if err != nil || !interest.IsPositive() {
+			return err
+		}
+		transaction = &Transaction{
+			UserID:      rule.UserID,
+			GoalID:      rule.GoalID,
+			Amount:      interest,
+			Description: rule.Describe(),
+			Type:        "add",
+			RuleID:      &rule.ID,
+			Source:      rule.Kind,
+			CreatedAt:   due,
+		}
+		return RecordTx(tx, transaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rule.NextRunAt = &next
+	rule.LastRunAt = &due
+	return transaction, nil
+}
+
+// applyMatchRules pays the goal's match rules on a deposit just recorded
+// and adds what they paid to deposit.Bonuses. Only plain deposits count:
+// not transfers, reversals or money a rule paid, and not deposits by the
+// rule's own member or dated before the rule was set up.
+func applyMatchRules(q DBTX, deposit *Transaction) error {
+	if deposit.Type != "add" || deposit.TransferID != nil || deposit.ReversalOf != nil || deposit.Earned() {
+		return nil
+	}
+
+	query := goalRuleSelect + `
+		WHERE r.goal_id = ? AND r.kind = ? AND r.user_id <> ? AND r.created_at <= ?
+		ORDER BY r.id
+	`
+	rules, err := queryGoalRules(q, query, deposit.GoalID, RuleMatch, deposit.UserID, deposit.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		amount, err := capBonus(q, rule, rule.Bonus(deposit.Amount), deposit.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if !amount.IsPositive() {
+			continue
+		}
+
+		bonus := &Transaction{
+			UserID:      rule.UserID,
+			GoalID:      deposit.GoalID,
+			Amount:      amount,
+			Description: rule.Describe(),
+			Type:        "add",
+			RuleID:      &rule.ID,
+			Source:      rule.Kind,
+			MatchOf:     &deposit.ID,
+			CreatedAt:   deposit.CreatedAt,
+		}
+		if err := RecordTx(q, bonus); err != nil {
+			return err
+		}
+		deposit.Bonuses = append(deposit.Bonuses, bonus)
+	}
+	return nil
+}
+
+// capBonus cuts amount down to what is left of the rule's monthly cap in
+// the calendar month of at.
+func capBonus(q DBTX, rule *GoalRule, amount Money, at time.Time) (Money, error) {
+	if rule.MonthlyCap == nil {
+		return amount, nil
+	}
+
+	var paid Money
+	query := `
+		SELECT COALESCE(SUM(amount), 0)
+		FROM transactions
+		WHERE rule_id = ? AND voided_at IS NULL AND created_at >= ? AND created_at < ?
+	`
+	if err := q.QueryRow(query, rule.ID, MonthStart(at), NextMonth(at)).Scan(&paid); err != nil {
+		return Money{}, err
+	}
+
+	if left := rule.MonthlyCap.Amount - paid.Amount; amount.Amount > left {
+		amount.Amount = left
+	}
+	return amount, nil
+}
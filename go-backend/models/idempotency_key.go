@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+// IdempotencyKey is a client-chosen key for one mutating request and the
+// response it got. StatusCode is 0 while the request is still running, and
+// ExpiresAt is then the end of its lease rather than of the replay window.
+type IdempotencyKey struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	Key         string    `json:"key" db:"key"`
+	Fingerprint string    `json:"-" db:"fingerprint"` // hash of the request the key was first used with
+	StatusCode  int       `json:"status_code" db:"status_code"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Body        []byte    `json:"-" db:"body"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type IdempotencyKeyRepository struct {
+	db *database.DB
+}
+
+func NewIdempotencyKeyRepository(db *database.DB) *IdempotencyKeyRepository {
+	return &IdempotencyKeyRepository{db: db}
+}
+
+// Reserve claims key.Key for key.UserID. If the user has already used the
+// key and it hasn't expired, nothing is written and the earlier entry is
+// returned instead; otherwise key is stored as running and nil is
+// returned.
+func (r *IdempotencyKeyRepository) Reserve(key *IdempotencyKey) (*IdempotencyKey, error) {
+	now := time.Now()
+	_, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND expires_at <= ?`,
+		key.UserID, key.Key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	key.CreatedAt = now
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, key) DO NOTHING
+		RETURNING id
+	`
+	err = r.db.QueryRow(query, key.UserID, key.Key, key.Fingerprint, key.CreatedAt, key.ExpiresAt).Scan(&key.ID)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Someone got there first
+	query = `
+		SELECT id, user_id, key, fingerprint, status_code, content_type, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+	`
+	var (
+		existing   IdempotencyKey
+		statusCode sql.NullInt64
+	)
+	err = r.db.QueryRow(query, key.UserID, key.Key).Scan(&existing.ID, &existing.UserID, &existing.Key,
+		&existing.Fingerprint, &statusCode, &existing.ContentType, &existing.Body, &existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	existing.StatusCode = int(statusCode.Int64)
+	return &existing, nil
+}
+
+// Complete stores the response of a reserved key for replay until
+// key.ExpiresAt.
+func (r *IdempotencyKeyRepository) Complete(key *IdempotencyKey) error {
+	query := `UPDATE idempotency_keys SET status_code = ?, content_type = ?, body = ?, expires_at = ? WHERE id = ?`
+	_, err := r.db.Exec(query, key.StatusCode, key.ContentType, key.Body, key.ExpiresAt, key.ID)
+	return err
+}
+
+// Release forgets a reserved key, so that the request can be retried.
+func (r *IdempotencyKeyRepository) Release(key *IdempotencyKey) error {
+	_, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE id = ?`, key.ID)
+	return err
+}
+
+// DeleteExpired removes the keys that expired before now and returns how
+// many there were.
+func (r *IdempotencyKeyRepository) DeleteExpired(now time.Time) (int, error) {
+	result, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
@@ -0,0 +1,473 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds in goal")
+	ErrGoalNotFound      = errors.New("goal not found")
+	ErrCurrencyMismatch  = errors.New("amount currency does not match goal currency")
+	ErrTransactionVoided = errors.New("transaction is voided")
+	// ErrTransactionChanged means the transaction was amended or voided
+	// by someone else since it was read.
+	ErrTransactionChanged = errors.New("transaction has changed")
+	// ErrTransactionMatched means a match rule paid on the deposit, and
+	// the match would no longer fit a new amount.
+	ErrTransactionMatched = errors.New("transaction has been matched")
+)
+
+// Ledger moves money in and out of goals. Every movement writes the
+// transaction row and adjusts the goal balance in the same database
+// transaction, so the two can never drift apart.
+type Ledger struct {
+	uow *UnitOfWork
+}
+
+func NewLedger(db *database.DB) *Ledger {
+	return &Ledger{uow: NewUnitOfWork(db)}
+}
+
+// Record applies a single "add" or "remove" transaction to its goal.
+func (l *Ledger) Record(transaction *Transaction) error {
+	return l.uow.Do(func(tx DBTX) error {
+		return RecordTx(tx, transaction)
+	})
+}
+
+// RecordAll applies several transactions in one database transaction:
+// either all of them are recorded or, if one fails, none is.
+func (l *Ledger) RecordAll(transactions []*Transaction) error {
+	return l.uow.Do(func(tx DBTX) error {
+		for _, transaction := range transactions {
+			if err := RecordTx(tx, transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Transfer records a transfer and its two legs in one database
+// transaction, so money never leaves one goal without reaching the other.
+// The legs are returned source first.
+func (l *Ledger) Transfer(transfer *Transfer) (*Transaction, *Transaction, error) {
+	var from, to *Transaction
+	err := l.uow.Do(func(tx DBTX) error {
+		var err error
+		from, to, err = transferTx(tx, transfer)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+func transferTx(q DBTX, transfer *Transfer) (*Transaction, *Transaction, error) {
+	if transfer.FromGoalID == transfer.ToGoalID {
+		return nil, nil, ErrSameGoal
+	}
+	transfer.CreatedAt = time.Now()
+
+	from := &Transaction{
+		UserID:      transfer.UserID,
+		GoalID:      transfer.FromGoalID,
+		Amount:      transfer.Amount,
+		Description: transfer.Description,
+		Type:        "remove",
+		CreatedAt:   transfer.CreatedAt,
+	}
+	to := &Transaction{
+		UserID:      transfer.UserID,
+		GoalID:      transfer.ToGoalID,
+		Amount:      transfer.ConvertedAmount,
+		Description: transfer.Description,
+		Type:        "add",
+		CreatedAt:   transfer.CreatedAt,
+	}
+	if transfer.ExchangeRate != "" {
+		original := transfer.Amount
+		to.OriginalAmount = &original
+		to.ExchangeRate = transfer.ExchangeRate
+	}
+
+	query := `
+		INSERT INTO transfers (user_id, from_goal_id, to_goal_id, currency, amount,
+			converted_currency, converted_amount, exchange_rate, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	exchangeRate := sql.NullString{String: transfer.ExchangeRate, Valid: transfer.ExchangeRate != ""}
+	err := q.QueryRow(query, transfer.UserID, transfer.FromGoalID, transfer.ToGoalID,
+		transfer.Amount.Currency, transfer.Amount, transfer.ConvertedAmount.Currency, transfer.ConvertedAmount,
+		exchangeRate, transfer.Description, transfer.CreatedAt).Scan(&transfer.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from.TransferID = &transfer.ID
+	to.TransferID = &transfer.ID
+	if err := RecordTx(q, from); err != nil {
+		return nil, nil, err
+	}
+	if err := RecordTx(q, to); err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+// Amend changes a transaction's amount and description in place, moves
+// the goal balance by the difference and keeps the old values as an
+// "amend" event. transaction must be as last read: if it has changed
+// since, nothing is written and ErrTransactionChanged is returned.
+func (l *Ledger) Amend(transaction *Transaction, userID int, amount Money, description string) (*TransactionEvent, error) {
+	if transaction.VoidedAt != nil {
+		return nil, ErrTransactionVoided
+	}
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	if amount.Currency != transaction.Currency {
+		return nil, ErrCurrencyMismatch
+	}
+
+	event := &TransactionEvent{
+		TransactionID: transaction.ID,
+		UserID:        userID,
+		Action:        EventAmend,
+		Changes:       make(map[string]Change),
+	}
+	if amount.Amount != transaction.Amount.Amount {
+		event.Changes["amount"] = Change{From: transaction.Amount.String(), To: amount.String()}
+	}
+	if description != transaction.Description {
+		event.Changes["description"] = Change{From: transaction.Description, To: description}
+	}
+	if len(event.Changes) == 0 {
+		return nil, nil
+	}
+
+	err := l.uow.Do(func(tx DBTX) error {
+		if _, ok := event.Changes["amount"]; ok {
+			var matched bool
+			query := `SELECT EXISTS (SELECT 1 FROM transactions WHERE match_of = ? AND voided_at IS NULL)`
+			if err := tx.QueryRow(query, transaction.ID).Scan(&matched); err != nil {
+				return err
+			}
+			if matched {
+				return ErrTransactionMatched
+			}
+		}
+
+		query := `
+			UPDATE transactions
+			SET amount = ?, description = ?
+			WHERE id = ? AND amount = ? AND description = ? AND voided_at IS NULL
+		`
+		result, err := tx.Exec(query, amount, description, transaction.ID, transaction.Amount, transaction.Description)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrTransactionChanged
+		}
+
+		delta := amount.Amount - transaction.Amount.Amount
+		if transaction.Type == "remove" {
+			delta = -delta
+		}
+		if delta != 0 {
+			if err := adjustGoalBalance(tx, transaction.GoalID, transaction.Currency, delta); err != nil {
+				return err
+			}
+		}
+		return insertTransactionEvent(tx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transaction.Amount = amount
+	transaction.Description = description
+	return event, nil
+}
+
+// Void cancels a transaction by recording a reversal entry of the opposite
+// type, leaving the original in place marked as voided. Voiding either leg
+// of a transfer voids both, and voiding a deposit voids the matches paid
+// on it. The reversal entries are returned.
+func (l *Ledger) Void(transaction *Transaction, userID int) ([]*Transaction, error) {
+	if transaction.VoidedAt != nil {
+		return nil, ErrTransactionVoided
+	}
+
+	var reversals []*Transaction
+	err := l.uow.Do(func(tx DBTX) error {
+		legs, err := voidLegs(tx, transaction)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, leg := range legs {
+			result, err := tx.Exec(`UPDATE transactions SET voided_at = ? WHERE id = ? AND voided_at IS NULL`, now, leg.ID)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return ErrTransactionChanged
+			}
+
+			reversal := &Transaction{
+				UserID:      leg.UserID,
+				GoalID:      leg.GoalID,
+				Amount:      leg.Amount,
+				Description: leg.Description,
+				Type:        "add",
+				ReversalOf:  &leg.ID,
+				CreatedAt:   now,
+			}
+			if leg.Type == "add" {
+				reversal.Type = "remove"
+			}
+			if err := RecordTx(tx, reversal); err != nil {
+				return err
+			}
+
+			event := &TransactionEvent{
+				TransactionID: leg.ID,
+				UserID:        userID,
+				Action:        EventVoid,
+				ReversalID:    &reversal.ID,
+				CreatedAt:     now,
+			}
+			if err := insertTransactionEvent(tx, event); err != nil {
+				return err
+			}
+			leg.VoidedAt = &now
+			reversals = append(reversals, reversal)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversals, nil
+}
+
+// ApproveWithdrawal records the "remove" asked for and marks the request
+// approved by decidedBy, in one database transaction. Any penalty is
+// transferred to the penalty goal instead, or stays in the goal if that
+// is gone. If the goal can no longer cover the amount, the request stays
+// pending.
+func (l *Ledger) ApproveWithdrawal(w *WithdrawalRequest, decidedBy int) (*Transaction, error) {
+	if w.CoolingOff(time.Now()) {
+		return nil, ErrWithdrawalCoolingOff
+	}
+
+	amount := w.Amount
+	if w.Penalty != nil {
+		amount = amount.Sub(*w.Penalty)
+	}
+	transaction := &Transaction{
+		UserID:      w.UserID,
+		GoalID:      w.GoalID,
+		Amount:      amount,
+		Description: w.Description,
+		Type:        "remove",
+	}
+	err := l.uow.Do(func(tx DBTX) error {
+		if err := RecordTx(tx, transaction); err != nil {
+			return err
+		}
+		if err := payPenalty(tx, w); err != nil {
+			return err
+		}
+		return decideWithdrawal(tx, w, WithdrawalApproved, decidedBy, "", &transaction.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// MoveSurplus deposits some of the user's budget surplus into a goal. In
+// the same database transaction it reads what surplus is left, after any
+// other move by the user has committed, and passes it to check, which
+// vetoes the deposit by returning an error (see ErrInsufficientSurplus).
+func (l *Ledger) MoveSurplus(transaction *Transaction, check func(surplus []Money) error) error {
+	transaction.Source = SourceSurplus
+	return l.uow.Do(func(tx DBTX) error {
+		if err := lockUser(tx, transaction.UserID); err != nil {
+			return err
+		}
+		totals, err := surplus(tx, transaction.UserID)
+		if err != nil {
+			return err
+		}
+		if err := check(totals); err != nil {
+			return err
+		}
+		return RecordTx(tx, transaction)
+	})
+}
+
+// payPenalty transfers the request's penalty to its penalty goal, unless
+// that is in the trash or gone.
+func payPenalty(q DBTX, w *WithdrawalRequest) error {
+	if w.Penalty == nil || w.PenaltyGoalID == nil {
+		return nil
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM goals WHERE id = ? AND currency = ? AND deleted_at IS NULL)`
+	if err := q.QueryRow(query, *w.PenaltyGoalID, w.Penalty.Currency).Scan(&exists); err != nil || !exists {
+		return err
+	}
+
+	transfer := &Transfer{
+		UserID:          w.UserID,
+		FromGoalID:      w.GoalID,
+		ToGoalID:        *w.PenaltyGoalID,
+		Amount:          *w.Penalty,
+		ConvertedAmount: *w.Penalty,
+		Description:     "Early withdrawal penalty",
+	}
+	_, _, err := transferTx(q, transfer)
+	return err
+}
+
+// voidLegs returns transaction followed by everything voiding it takes
+// along: the other legs of its transfer, if it is part of one, and the
+// matches paid on it that are still in effect.
+func voidLegs(q DBTX, transaction *Transaction) ([]*Transaction, error) {
+	legs := []*Transaction{transaction}
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE (match_of = ? AND voided_at IS NULL)`
+	args := []interface{}{transaction.ID}
+	if transaction.TransferID != nil {
+		query += ` OR (transfer_id = ? AND id <> ?)`
+		args = append(args, *transaction.TransferID, transaction.ID)
+	}
+	rows, err := q.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var leg Transaction
+		if err := scanTransaction(rows, &leg); err != nil {
+			return nil, err
+		}
+		legs = append(legs, &leg)
+	}
+	return legs, rows.Err()
+}
+
+// RecordTx is Record for callers that already hold a transaction and need
+// to combine several movements into one unit of work. Deposits collect the
+// goal's matches as part of it; see applyMatchRules.
+func RecordTx(q DBTX, transaction *Transaction) error {
+	if !transaction.Amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+
+	delta := transaction.Amount.Amount
+	if transaction.Type == "remove" {
+		delta = -delta
+	}
+
+	if err := adjustGoalBalance(q, transaction.GoalID, transaction.Amount.Currency, delta); err != nil {
+		return err
+	}
+	if err := insertTransaction(q, transaction); err != nil {
+		return err
+	}
+	return applyMatchRules(q, transaction)
+}
+
+// adjustGoalBalance changes current_amount with a single conditional UPDATE.
+// The balance check and the write happen in one statement, so two
+// concurrent withdrawals cannot both pass the check against a stale value.
+func adjustGoalBalance(q DBTX, goalID int, currency string, delta int64) error {
+	query := `
+		UPDATE goals
+		SET current_amount = current_amount + ?
+		WHERE id = ? AND currency = ? AND current_amount + ? >= 0 AND deleted_at IS NULL
+	`
+	result, err := q.Exec(query, delta, goalID, currency, delta)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 1 {
+		return nil
+	}
+
+	// Nothing was updated: the goal is gone or in the trash, in another
+	// currency, or the balance is too low
+	var goalCurrency string
+	err = q.QueryRow(`SELECT currency FROM goals WHERE id = ? AND deleted_at IS NULL`, goalID).Scan(&goalCurrency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrGoalNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if goalCurrency != currency {
+		return ErrCurrencyMismatch
+	}
+	return ErrInsufficientFunds
+}
+
+func insertTransaction(q DBTX, transaction *Transaction) error {
+	query := `
+		INSERT INTO transactions (user_id, goal_id, currency, amount, description, type,
+			original_amount, original_currency, exchange_rate, schedule_id, import_hash, transfer_id, reversal_of,
+			rule_id, source, match_of, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	transaction.Currency = transaction.Amount.Currency
+
+	var (
+		originalAmount   sql.NullInt64
+		originalCurrency sql.NullString
+		exchangeRate     sql.NullString
+		importHash       sql.NullString
+		source           sql.NullString
+	)
+	if transaction.ImportHash != "" {
+		importHash = sql.NullString{String: transaction.ImportHash, Valid: true}
+	}
+	if transaction.Source != "" {
+		source = sql.NullString{String: transaction.Source, Valid: true}
+	}
+	if transaction.OriginalAmount != nil {
+		originalAmount = sql.NullInt64{Int64: transaction.OriginalAmount.Amount, Valid: true}
+		originalCurrency = sql.NullString{String: transaction.OriginalAmount.Currency, Valid: true}
+		exchangeRate = sql.NullString{String: transaction.ExchangeRate, Valid: true}
+		transaction.OriginalCurrency = transaction.OriginalAmount.Currency
+	}
+
+	return q.QueryRow(query, transaction.UserID, transaction.GoalID, transaction.Currency,
+		transaction.Amount, transaction.Description, transaction.Type,
+		originalAmount, originalCurrency, exchangeRate, transaction.ScheduleID, importHash, transaction.TransferID, transaction.ReversalOf,
+		transaction.RuleID, source, transaction.MatchOf, transaction.CreatedAt).Scan(&transaction.ID)
+}
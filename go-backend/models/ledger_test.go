@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+// newTestGoal creates a user and a USD goal holding balance.
+func newTestGoal(t *testing.T, db *database.DB, balance int64) *Goal {
+	t.Helper()
+	user := &User{Name: "Saver", Email: fmt.Sprintf("saver%d@example.com", time.Now().UnixNano()), PasswordHash: "x"}
+	if err := NewUserRepository(db).Create(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	goal := &Goal{
+		UserID:        user.ID,
+		Title:         "Bike",
+		Currency:      "USD",
+		TargetAmount:  NewMoney(100000, "USD"),
+		CurrentAmount: NewMoney(balance, "USD"),
+		Deadline:      time.Now().AddDate(1, 0, 0),
+	}
+	if err := NewGoalRepository(db).Create(goal); err != nil {
+		t.Fatalf("create goal: %v", err)
+	}
+	return goal
+}
+
+func goalBalance(t *testing.T, db *database.DB, goalID int) int64 {
+	t.Helper()
+	goal, err := NewGoalRepository(db).GetByID(goalID)
+	if err != nil {
+		t.Fatalf("get goal: %v", err)
+	}
+	return goal.CurrentAmount.Amount
+}
+
+func TestCreateReturnsID(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		first := newTestGoal(t, db, 0)
+		second := newTestGoal(t, db, 0)
+		if first.ID == 0 || first.UserID == 0 {
+			t.Fatalf("IDs not returned: goal %d, user %d", first.ID, first.UserID)
+		}
+		if second.ID == first.ID || second.UserID == first.UserID {
+			t.Fatalf("IDs repeated: goals %d and %d, users %d and %d", first.ID, second.ID, first.UserID, second.UserID)
+		}
+
+		transaction := &Transaction{UserID: first.UserID, GoalID: first.ID, Amount: NewMoney(250, "USD"), Type: "add"}
+		if err := NewLedger(db).Record(transaction); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		if transaction.ID == 0 {
+			t.Fatal("transaction ID not returned")
+		}
+	})
+}
+
+func TestAdjustGoalBalance(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		goal := newTestGoal(t, db, 500)
+
+		tests := []struct {
+			name     string
+			goalID   int
+			currency string
+			delta    int64
+			wantErr  error
+			balance  int64
+		}{
+			{"deposit", goal.ID, "USD", 300, nil, 800},
+			{"overdraw", goal.ID, "USD", -801, ErrInsufficientFunds, 800},
+			{"withdraw everything", goal.ID, "USD", -800, nil, 0},
+			{"other currency", goal.ID, "EUR", 100, ErrCurrencyMismatch, 0},
+			{"missing goal", goal.ID + 1000, "USD", 100, ErrGoalNotFound, 0},
+		}
+		for _, tt := range tests {
+			err := adjustGoalBalance(db, tt.goalID, tt.currency, tt.delta)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if got := goalBalance(t, db, goal.ID); got != tt.balance {
+				t.Errorf("%s: balance = %d, want %d", tt.name, got, tt.balance)
+			}
+		}
+	})
+}
+
+func TestConcurrentWithdrawalsCannotOverdraw(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		goal := newTestGoal(t, db, 1000)
+		ledger := NewLedger(db)
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := ledger.Record(&Transaction{UserID: goal.UserID, GoalID: goal.ID, Amount: NewMoney(300, "USD"), Type: "remove"})
+				if err != nil && !errors.Is(err, ErrInsufficientFunds) {
+					t.Errorf("Record: %v", err)
+				}
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 3 {
+			t.Errorf("%d withdrawals went through, want 3", succeeded)
+		}
+		if got := goalBalance(t, db, goal.ID); got != 100 {
+			t.Errorf("balance = %d, want 100", got)
+		}
+	})
+}
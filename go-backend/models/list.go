@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions pages, filters and orders a listing. Zero values mean "no
+// filter"; the filters that do not apply to a listing are ignored.
+type ListOptions struct {
+	Limit  int
+	Cursor string // from a previous page's next cursor
+
+	SortBy string // a column from the listing's sortable set
+	Desc   bool
+
+	// CreatedFrom is inclusive, CreatedTo exclusive
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Amount bounds in minor units, both inclusive
+	MinAmount *int64
+	MaxAmount *int64
+	Type      string
+	Search    string
+}
+
+// sortKind says how a sort column's values are carried in a cursor.
+type sortKind int
+
+const (
+	sortTime sortKind = iota
+	sortInt
+	sortString
+)
+
+// cursor is the position after the last row of a page: the sort value and
+// id of that row. It is encoded as opaque base64 JSON.
+type cursor struct {
+	SortBy string          `json:"s"`
+	Desc   bool            `json:"d"`
+	Value  json.RawMessage `json:"v"`
+	ID     int             `json:"id"`
+}
+
+// listQuery builds the SELECT for a keyset-paginated listing.
+type listQuery struct {
+	table    string
+	columns  string
+	sortable map[string]sortKind
+	where    []string
+	args     []interface{}
+}
+
+func (q *listQuery) filter(condition string, args ...interface{}) {
+	q.where = append(q.where, condition)
+	q.args = append(q.args, args...)
+}
+
+// search adds a case-insensitive substring match on column.
+func (q *listQuery) search(column, text string) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(text))
+	q.filter("LOWER("+column+") LIKE ? ESCAPE '\\'", "%"+escaped+"%")
+}
+
+// build returns the query and its arguments. It fetches one row more than
+// the limit so the caller can tell whether another page follows.
+func (q *listQuery) build(opts *ListOptions) (string, []interface{}, error) {
+	if opts.SortBy == "" {
+		opts.SortBy = "created_at"
+		opts.Desc = true
+	}
+	kind, ok := q.sortable[opts.SortBy]
+	if !ok {
+		return "", nil, fmt.Errorf("cannot sort by %q", opts.SortBy)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+
+	if opts.Cursor != "" {
+		after, err := decodeCursor(opts.Cursor, opts.SortBy, opts.Desc, kind)
+		if err != nil {
+			return "", nil, err
+		}
+		op := ">"
+		if opts.Desc {
+			op = "<"
+		}
+		q.filter(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", opts.SortBy, op),
+			after.value, after.value, after.id)
+	}
+
+	direction := "ASC"
+	if opts.Desc {
+		direction = "DESC"
+	}
+
+	query := "SELECT " + q.columns + " FROM " + q.table
+	if len(q.where) > 0 {
+		query += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", opts.SortBy, direction, direction, opts.Limit+1)
+	return query, q.args, nil
+}
+
+type cursorPosition struct {
+	value interface{}
+	id    int
+}
+
+func encodeCursor(opts *ListOptions, value interface{}, id int) (string, error) {
+	if t, ok := value.(time.Time); ok {
+		// Keep the offset, SQLite compares timestamps as text
+		value = t.Format(time.RFC3339Nano)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cursor{SortBy: opts.SortBy, Desc: opts.Desc, Value: raw, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor rejects cursors from a listing with a different order,
+// since the position would be meaningless.
+func decodeCursor(encoded, sortBy string, desc bool, kind sortKind) (cursorPosition, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursorPosition{}, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.SortBy != sortBy || c.Desc != desc {
+		return cursorPosition{}, ErrInvalidCursor
+	}
+
+	switch kind {
+	case sortTime:
+		var s string
+		if err := json.Unmarshal(c.Value, &s); err != nil {
+			return cursorPosition{}, ErrInvalidCursor
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return cursorPosition{}, ErrInvalidCursor
+		}
+		return cursorPosition{value: t, id: c.ID}, nil
+	case sortInt:
+		var n int64
+		if err := json.Unmarshal(c.Value, &n); err != nil {
+			return cursorPosition{}, ErrInvalidCursor
+		}
+		return cursorPosition{value: n, id: c.ID}, nil
+	default:
+		var s string
+		if err := json.Unmarshal(c.Value, &s); err != nil {
+			return cursorPosition{}, ErrInvalidCursor
+		}
+		return cursorPosition{value: s, id: c.ID}, nil
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"os"
+	"testing"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Main(m))
+}
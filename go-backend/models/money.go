@@ -0,0 +1,232 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used wherever an amount has no explicit currency.
+const DefaultCurrency = "USD"
+
+// minorUnitDigits is the number of decimal places stored in minor units.
+// All supported currencies are divided into cents; see currencies.
+const minorUnitDigits = 2
+
+const minorUnitsPerMajor = 100
+
+// rateDecimals is the precision exchange rates are recorded with.
+const rateDecimals = 8
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrInvalidCurrency = errors.New("invalid currency code")
+)
+
+// Money is an exact amount of money stored as an integer number of minor
+// units (cents) plus an ISO 4217 currency code.
+//
+// In JSON a Money is written as a plain decimal number such as 12.34, so
+// existing clients keep working; the currency travels in a separate field
+// of the enclosing object. In the database it is stored as an INTEGER
+// column of minor units.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func NewMoney(minorUnits int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: minorUnits, Currency: currency}
+}
+
+// ParseMoney parses a decimal string like "12.34" or "-0.5" without going
+// through float64. More than two decimal places is an error.
+func ParseMoney(s string, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Money{}, ErrInvalidAmount
+	}
+	if len(frac) > minorUnitDigits {
+		// Allow trailing zeros such as "1.500"
+		if strings.Trim(frac[minorUnitDigits:], "0") != "" {
+			return Money{}, fmt.Errorf("%w: more than %d decimal places", ErrInvalidAmount, minorUnitDigits)
+		}
+		frac = frac[:minorUnitDigits]
+	}
+	frac += strings.Repeat("0", minorUnitDigits-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	if negative {
+		minor = -minor
+	}
+	return NewMoney(minor, currency), nil
+}
+
+// currencies are the ISO 4217 codes with two decimal places, the only
+// ones Money can hold at the right scale. Currencies such as JPY (none)
+// or KWD (three) are left out.
+var currencies = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true,
+	"AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BMD": true, "BND": true,
+	"BOB": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true,
+	"CDF": true, "CHF": true, "CNY": true, "COP": true, "CRC": true, "CUP": true, "CVE": true, "CZK": true,
+	"DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true,
+	"FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true, "GTQ": true, "GYD": true,
+	"HKD": true, "HNL": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "IRR": true,
+	"JMD": true, "KES": true, "KGS": true, "KHR": true, "KPW": true, "KYD": true, "KZT": true, "LAK": true,
+	"LBP": true, "LKR": true, "LRD": true, "LSL": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true,
+	"MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true,
+	"MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true,
+	"PAB": true, "PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true, "QAR": true, "RON": true,
+	"RSD": true, "RUB": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true,
+	"SZL": true, "THB": true, "TJS": true, "TMT": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true,
+	"TZS": true, "UAH": true, "USD": true, "UYU": true, "UZS": true, "VES": true, "WST": true, "XCD": true,
+	"YER": true, "ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+// NormalizeCurrency upper-cases a currency code and checks that it is one
+// of the supported currencies. An empty code is returned unchanged.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "", nil
+	}
+	if !currencies[code] {
+		return "", ErrInvalidCurrency
+	}
+	return code, nil
+}
+
+// Convert converts m into currency at rate. The rate is first rounded to
+// the precision it is recorded with, and the returned string is that
+// recorded rate, so the stored conversion can always be reproduced.
+// The result is rounded half away from zero to whole minor units. It is an
+// ErrInvalidAmount if the result does not fit.
+func (m Money) Convert(rate *big.Rat, currency string) (Money, string, error) {
+	recorded := rate.FloatString(rateDecimals)
+	recorded = strings.TrimRight(strings.TrimRight(recorded, "0"), ".")
+
+	rounded, _ := new(big.Rat).SetString(recorded)
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rounded)
+
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), product.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(product.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(product.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return Money{}, recorded, fmt.Errorf("%w: too large to convert", ErrInvalidAmount)
+	}
+	return NewMoney(quotient.Int64(), currency), recorded, nil
+}
+
+// String formats the amount as a decimal with two places, e.g. "-12.05".
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/minorUnitsPerMajor, amount%minorUnitsPerMajor)
+}
+
+// Float returns the amount in major units. Use it only for display and
+// ratios, never for arithmetic that gets stored.
+func (m Money) Float() float64 {
+	return float64(m.Amount) / minorUnitsPerMajor
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) Add(other Money) Money {
+	return NewMoney(m.Amount+other.Amount, m.Currency)
+}
+
+func (m Money) Sub(other Money) Money {
+	return NewMoney(m.Amount-other.Amount, m.Currency)
+}
+
+func (m Money) Neg() Money {
+	return NewMoney(-m.Amount, m.Currency)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts both a JSON number (12.34) and a JSON string
+// ("12.34"). The literal is parsed as text, so no precision is lost.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.TrimSpace(string(data))
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if strings.ContainsAny(s, "eE") {
+		return fmt.Errorf("%w: exponent notation is not supported", ErrInvalidAmount)
+	}
+
+	parsed, err := ParseMoney(s, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads an INTEGER column of minor units. PostgreSQL returns SUM()
+// over BIGINT as NUMERIC, which arrives as text.
+func (m *Money) Scan(src interface{}) error {
+	currency := m.Currency
+	switch v := src.(type) {
+	case int64:
+		*m = NewMoney(v, currency)
+	case []byte:
+		minor, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot scan %q into Money", v)
+		}
+		*m = NewMoney(minor, currency)
+	case nil:
+		*m = NewMoney(0, currency)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// Value stores the amount as an integer number of minor units.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
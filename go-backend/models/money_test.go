@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		code    string
+		want    string
+		wantErr error
+	}{
+		{"usd", "USD", nil},
+		{" eur ", "EUR", nil},
+		{"", "", nil},
+		{"JPY", "", ErrInvalidCurrency}, // no minor units
+		{"KWD", "", ErrInvalidCurrency}, // three decimal places
+		{"ABC", "", ErrInvalidCurrency},
+		{"US", "", ErrInvalidCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeCurrency(tt.code)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v; want %q, %v", tt.code, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		rate     string
+		want     int64
+		recorded string
+		wantErr  error
+	}{
+		{"rounds half away from zero", 1005, "0.5", 503, "0.5", nil},
+		{"negative", -1005, "0.5", -503, "0.5", nil},
+		{"rate rounded to 8 places", 10000, "1.123456789", 11235, "1.12345679", nil},
+		{"overflow", math.MaxInt64 / 2, "3", 0, "3", ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		got, recorded, err := NewMoney(tt.amount, "USD").Convert(rate, "EUR")
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got.Amount != tt.want || recorded != tt.recorded {
+			t.Errorf("%s: Convert = %d at %s, want %d at %s", tt.name, got.Amount, recorded, tt.want, tt.recorded)
+		}
+	}
+}
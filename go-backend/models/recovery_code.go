@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or already used")
+
+// RecoveryCodeRepository stores the hashes of a user's single-use
+// two-factor recovery codes.
+type RecoveryCodeRepository struct {
+	db *database.DB
+}
+
+func NewRecoveryCodeRepository(db *database.DB) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{db: db}
+}
+
+// Replace discards the user's existing codes and stores a new set.
+func (r *RecoveryCodeRepository) Replace(userID int, codeHashes []string) error {
+	now := time.Now()
+	return NewUnitOfWork(r.db).Do(func(tx DBTX) error {
+		if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		for _, hash := range codeHashes {
+			query := `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`
+			if _, err := tx.Exec(query, userID, hash, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Use marks one of the user's unused codes as used.
+func (r *RecoveryCodeRepository) Use(userID int, codeHash string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := r.db.Exec(query, time.Now(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func (r *RecoveryCodeRepository) CountUnused(userID int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`
+	err := r.db.QueryRow(query, userID).Scan(&count)
+	return count, err
+}
+
+func (r *RecoveryCodeRepository) DeleteByUserID(userID int) error {
+	_, err := r.db.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	return err
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrScheduleChanged means the schedule was run, paused or edited by
+	// someone else since it was read.
+	ErrScheduleChanged = errors.New("schedule changed concurrently")
+)
+
+// RecurringContribution is a standing order that adds Amount to a goal on
+// every occurrence of Rule, a recurrence rule in the schedule package's
+// RRULE syntax.
+type RecurringContribution struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	GoalID      int        `json:"goal_id" db:"goal_id"`
+	Currency    string     `json:"currency" db:"currency"` // always the goal's currency
+	Amount      Money      `json:"amount" db:"amount"`
+	Description string     `json:"description" db:"description"`
+	Rule        string     `json:"rule" db:"rule"`
+	StartAt     time.Time  `json:"start_at" db:"start_at"`
+	EndAt       *time.Time `json:"end_at" db:"end_at"`
+	// NextRunAt is nil once the schedule has run its last occurrence
+	NextRunAt *time.Time `json:"next_run_at" db:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at" db:"last_run_at"`
+	RunCount  int        `json:"run_count" db:"run_count"`
+	PausedAt  *time.Time `json:"paused_at" db:"paused_at"`
+	// LastError explains why the scheduler paused the schedule by itself
+	LastError string    `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (s *RecurringContribution) IsPaused() bool {
+	return s.PausedAt != nil
+}
+
+const recurringContributionColumns = `id, user_id, goal_id, currency, amount, description, rule,
+	start_at, end_at, next_run_at, last_run_at, run_count, paused_at, last_error, created_at`
+
+func scanRecurringContribution(row rowScanner, s *RecurringContribution) error {
+	var endAt, nextRunAt, lastRunAt, pausedAt sql.NullTime
+	err := row.Scan(
+		&s.ID, &s.UserID, &s.GoalID, &s.Currency, &s.Amount, &s.Description, &s.Rule,
+		&s.StartAt, &endAt, &nextRunAt, &lastRunAt, &s.RunCount, &pausedAt, &s.LastError, &s.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	s.Amount.Currency = s.Currency
+	s.EndAt = nullTimePtr(endAt)
+	s.NextRunAt = nullTimePtr(nextRunAt)
+	s.LastRunAt = nullTimePtr(lastRunAt)
+	s.PausedAt = nullTimePtr(pausedAt)
+	return nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+type RecurringContributionRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewRecurringContributionRepository(db *database.DB) *RecurringContributionRepository {
+	return &RecurringContributionRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+func (r *RecurringContributionRepository) Create(s *RecurringContribution) error {
+	query := `
+		INSERT INTO recurring_contributions (user_id, goal_id, currency, amount, description, rule,
+			start_at, end_at, next_run_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	s.Currency = s.Amount.Currency
+	s.CreatedAt = time.Now()
+
+	return r.db.QueryRow(query, s.UserID, s.GoalID, s.Currency, s.Amount, s.Description, s.Rule,
+		s.StartAt, s.EndAt, s.NextRunAt, s.CreatedAt).Scan(&s.ID)
+}
+
+func (r *RecurringContributionRepository) GetByID(id int) (*RecurringContribution, error) {
+	s := &RecurringContribution{}
+	query := `SELECT ` + recurringContributionColumns + ` FROM recurring_contributions WHERE id = ?`
+	err := scanRecurringContribution(r.db.QueryRow(query, id), s)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *RecurringContributionRepository) GetByGoalID(goalID int) ([]RecurringContribution, error) {
+	query := `
+		SELECT ` + recurringContributionColumns + `
+		FROM recurring_contributions
+		WHERE goal_id = ?
+		ORDER BY created_at
+	`
+	return r.query(query, goalID)
+}
+
+// GetDue returns active schedules with an occurrence at or before now,
+// oldest first.
+func (r *RecurringContributionRepository) GetDue(now time.Time, limit int) ([]RecurringContribution, error) {
+	query := `
+		SELECT ` + recurringContributionColumns + `
+		FROM recurring_contributions
+		WHERE next_run_at <= ? AND paused_at IS NULL
+		ORDER BY next_run_at
+		LIMIT ?
+	`
+	return r.query(query, now, limit)
+}
+
+func (r *RecurringContributionRepository) query(query string, args ...interface{}) ([]RecurringContribution, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []RecurringContribution{}
+	for rows.Next() {
+		var s RecurringContribution
+		if err := scanRecurringContribution(rows, &s); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// Update saves the editable fields and the recomputed next run.
+func (r *RecurringContributionRepository) Update(s *RecurringContribution) error {
+	query := `
+		UPDATE recurring_contributions
+		SET amount = ?, description = ?, rule = ?, start_at = ?, end_at = ?, next_run_at = ?,
+			paused_at = ?, last_error = ?
+		WHERE id = ?
+	`
+	_, err := r.db.Exec(query, s.Amount, s.Description, s.Rule, s.StartAt, s.EndAt, s.NextRunAt,
+		s.PausedAt, s.LastError, s.ID)
+	return err
+}
+
+// Delete removes the schedule. Transactions it created are kept and no
+// longer point at it.
+func (r *RecurringContributionRepository) Delete(id int) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if _, err := tx.Exec(`UPDATE transactions SET schedule_id = NULL WHERE schedule_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM recurring_contributions WHERE id = ?`, id)
+		return err
+	})
+}
+
+// Materialize records the occurrence due at s.NextRunAt as a transaction
+// and moves the schedule on to next, which is nil when it has finished.
+// Both happen in one database transaction, and the schedule only advances
+// if its next run is still the one that was read, so an occurrence is
+// never recorded twice even with several schedulers running.
+func (r *RecurringContributionRepository) Materialize(s *RecurringContribution, next *time.Time) (*Transaction, error) {
+	occurrence := *s.NextRunAt
+	transaction := &Transaction{
+		UserID:      s.UserID,
+		GoalID:      s.GoalID,
+		Amount:      s.Amount,
+		Description: s.Description,
+		Type:        "add",
+		ScheduleID:  &s.ID,
+		CreatedAt:   occurrence,
+	}
+
+	err := r.uow.Do(func(tx DBTX) error {
+		query := `
+			UPDATE recurring_contributions
+			SET next_run_at = ?, last_run_at = ?, run_count = run_count + 1, last_error = ''
+			WHERE id = ? AND next_run_at = ? AND paused_at IS NULL
+		`
+		result, err := tx.Exec(query, next, occurrence, s.ID, occurrence)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrScheduleChanged
+		}
+
+		return RecordTx(tx, transaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.NextRunAt = next
+	s.LastRunAt = &occurrence
+	s.RunCount++
+	s.LastError = ""
+	return transaction, nil
+}
+
+// PauseWithError pauses a schedule the scheduler cannot run, for example
+// because its goal was deleted, and records why.
+func (r *RecurringContributionRepository) PauseWithError(id int, reason string) error {
+	query := `UPDATE recurring_contributions SET paused_at = ?, last_error = ? WHERE id = ?`
+	_, err := r.db.Exec(query, time.Now(), reason, id)
+	return err
+}
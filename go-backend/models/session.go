@@ -0,0 +1,193 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// Session is one signed-in device. Its PublicID is the jti of every access
+// token issued for it, and it holds the hash of the current refresh token.
+type Session struct {
+	ID               int        `json:"-" db:"id"`
+	PublicID         string     `json:"id" db:"public_id"`
+	UserID           int        `json:"user_id" db:"user_id"`
+	RefreshTokenHash string     `json:"-" db:"refresh_token_hash"`
+	Device           string     `json:"device" db:"device"`
+	IPAddress        string     `json:"ip_address" db:"ip_address"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt       time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+const sessionColumns = `id, public_id, user_id, refresh_token_hash, device, ip_address,
+	created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row rowScanner, session *Session) error {
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&session.ID, &session.PublicID, &session.UserID, &session.RefreshTokenHash,
+		&session.Device, &session.IPAddress, &session.CreatedAt, &session.LastUsedAt,
+		&session.ExpiresAt, &revokedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return nil
+}
+
+type SessionRepository struct {
+	db *database.DB
+}
+
+func NewSessionRepository(db *database.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(session *Session) error {
+	query := `
+		INSERT INTO sessions (public_id, user_id, refresh_token_hash, device, ip_address,
+			created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRow(query, session.PublicID, session.UserID, session.RefreshTokenHash,
+		session.Device, session.IPAddress, session.CreatedAt, session.LastUsedAt,
+		session.ExpiresAt).Scan(&session.ID)
+}
+
+func (r *SessionRepository) GetByPublicID(publicID string) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE public_id = ?`
+	return r.get(query, publicID)
+}
+
+// GetByRefreshTokenHash finds the session a refresh token belongs to. If
+// the token was the session's previous one it has already been rotated,
+// and the session is returned together with ErrRefreshTokenReused.
+func (r *SessionRepository) GetByRefreshTokenHash(hash string) (*Session, error) {
+	session, err := r.get(`SELECT `+sessionColumns+` FROM sessions WHERE refresh_token_hash = ?`, hash)
+	if !errors.Is(err, ErrSessionNotFound) {
+		return session, err
+	}
+
+	session, err = r.get(`SELECT `+sessionColumns+` FROM sessions WHERE previous_token_hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	return session, ErrRefreshTokenReused
+}
+
+func (r *SessionRepository) get(query string, arg interface{}) (*Session, error) {
+	session := &Session{}
+	err := scanSession(r.db.QueryRow(query, arg), session)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Rotate replaces the session's refresh token. The update only applies if
+// the token being replaced is still current, so two concurrent refreshes
+// with the same token cannot both succeed.
+func (r *SessionRepository) Rotate(session *Session, newHash string, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET refresh_token_hash = ?, previous_token_hash = refresh_token_hash,
+			last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL
+	`
+	now := time.Now()
+	result, err := r.db.Exec(query, newHash, now, expiresAt, session.ID, session.RefreshTokenHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+
+	session.RefreshTokenHash = newHash
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// ListActiveByUserID returns the user's sessions that are neither revoked
+// nor expired, most recently used first.
+func (r *SessionRepository) ListActiveByUserID(userID int) ([]Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY last_used_at DESC
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		if session.IsActive(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions.
+func (r *SessionRepository) Revoke(userID int, publicID string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE public_id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := r.db.Exec(query, time.Now(), publicID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllByUserID signs the user out everywhere.
+func (r *SessionRepository) RevokeAllByUserID(userID int) error {
+	query := `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+	_, err := r.db.Exec(query, time.Now(), userID)
+	return err
+}
@@ -3,111 +3,404 @@ package models
 import (
 	"database/sql"
 	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
 )
 
 type Transaction struct {
-	ID          int       `json:"id" db:"id"`
-	UserID      int       `json:"user_id" db:"user_id"`
-	GoalID      int       `json:"goal_id" db:"goal_id"`
-	Amount      float64   `json:"amount" db:"amount"`
-	Description string    `json:"description" db:"description"`
-	Type        string    `json:"type" db:"type"` // "add" or "remove"
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID          int    `json:"id" db:"id"`
+	UserID      int    `json:"user_id" db:"user_id"`
+	GoalID      int    `json:"goal_id" db:"goal_id"`
+	Currency    string `json:"currency" db:"currency"` // always the goal's currency
+	Amount      Money  `json:"amount" db:"amount"`
+	Description string `json:"description" db:"description"`
+	Type        string `json:"type" db:"type"` // "add" or "remove"
+	// Set when the contribution was made in another currency and converted
+	// into the goal's currency at ExchangeRate.
+	OriginalAmount   *Money     `json:"original_amount,omitempty" db:"original_amount"`
+	OriginalCurrency string     `json:"original_currency,omitempty" db:"original_currency"`
+	ExchangeRate     string     `json:"exchange_rate,omitempty" db:"exchange_rate"`
+	ScheduleID       *int       `json:"schedule_id,omitempty" db:"schedule_id"` // set by recurring schedules
+	ImportHash       string     `json:"-" db:"import_hash"`                     // set by imports
+	TransferID       *int       `json:"transfer_id,omitempty" db:"transfer_id"` // set on both legs of a transfer
+	VoidedAt         *time.Time `json:"voided_at,omitempty" db:"voided_at"`
+	ReversalOf       *int       `json:"reversal_of,omitempty" db:"reversal_of"` // ID of the voided transaction this offsets
+	// Set on money a goal rule paid in. Source is the rule's kind and
+	// stays when the rule is deleted; MatchOf is the deposit a match was
+	// paid for. Deposits from the budget surplus have Source
+	// SourceSurplus and no rule.
+	RuleID    *int      `json:"rule_id,omitempty" db:"rule_id"`
+	Source    string    `json:"source,omitempty" db:"source"`
+	MatchOf   *int      `json:"match_of,omitempty" db:"match_of"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// Matches recording this deposit paid in; not stored
+	Bonuses []*Transaction `json:"bonuses,omitempty" db:"-"`
+}
+
+// Earned reports whether a goal rule paid the money in, as opposed to
+// someone depositing it.
+func (t *Transaction) Earned() bool {
+	return t.Source == RuleMatch || t.Source == RuleInterest
+}
+
+// Counted reports whether the transaction is still in effect: neither
+// voided nor the reversal of a voided one. The two cancel out, so totals
+// and forecasts skip both.
+func (t *Transaction) Counted() bool {
+	return t.VoidedAt == nil && t.ReversalOf == nil
+}
+
+const transactionColumns = `id, user_id, goal_id, currency, amount, description, type,
+	original_amount, original_currency, exchange_rate, schedule_id, import_hash, transfer_id, voided_at, reversal_of,
+	rule_id, source, match_of, created_at`
+
+func scanTransaction(row rowScanner, transaction *Transaction) error {
+	var (
+		originalAmount   sql.NullInt64
+		originalCurrency sql.NullString
+		exchangeRate     sql.NullString
+		scheduleID       sql.NullInt64
+		importHash       sql.NullString
+		transferID       sql.NullInt64
+		voidedAt         sql.NullTime
+		reversalOf       sql.NullInt64
+		ruleID           sql.NullInt64
+		source           sql.NullString
+		matchOf          sql.NullInt64
+	)
+	err := row.Scan(
+		&transaction.ID, &transaction.UserID, &transaction.GoalID, &transaction.Currency,
+		&transaction.Amount, &transaction.Description, &transaction.Type,
+		&originalAmount, &originalCurrency, &exchangeRate, &scheduleID, &importHash, &transferID,
+		&voidedAt, &reversalOf, &ruleID, &source, &matchOf, &transaction.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	transaction.Amount.Currency = transaction.Currency
+	if originalAmount.Valid {
+		original := NewMoney(originalAmount.Int64, originalCurrency.String)
+		transaction.OriginalAmount = &original
+		transaction.OriginalCurrency = original.Currency
+		transaction.ExchangeRate = exchangeRate.String
+	}
+	if scheduleID.Valid {
+		id := int(scheduleID.Int64)
+		transaction.ScheduleID = &id
+	}
+	transaction.ImportHash = importHash.String
+	if transferID.Valid {
+		id := int(transferID.Int64)
+		transaction.TransferID = &id
+	}
+	transaction.VoidedAt = nullTimePtr(voidedAt)
+	if reversalOf.Valid {
+		id := int(reversalOf.Int64)
+		transaction.ReversalOf = &id
+	}
+	if ruleID.Valid {
+		id := int(ruleID.Int64)
+		transaction.RuleID = &id
+	}
+	transaction.Source = source.String
+	if matchOf.Valid {
+		id := int(matchOf.Int64)
+		transaction.MatchOf = &id
+	}
+	return nil
 }
 
 type TransactionRepository struct {
-	db *sql.DB
+	db *database.DB
 }
 
-func NewTransactionRepository(db *sql.DB) *TransactionRepository {
+func NewTransactionRepository(db *database.DB) *TransactionRepository {
 	return &TransactionRepository{db: db}
 }
 
+// Create inserts a transaction row without touching the goal balance.
+// Money movements should go through Ledger.Record instead.
 func (r *TransactionRepository) Create(transaction *Transaction) error {
+	return insertTransaction(r.db, transaction)
+}
+
+func (r *TransactionRepository) GetByID(id int) (*Transaction, error) {
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE id = ?`
+	var transaction Transaction
+	if err := scanTransaction(r.db.QueryRow(query, id), &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// GetByTransferID returns both legs of a transfer.
+func (r *TransactionRepository) GetByTransferID(transferID int) ([]Transaction, error) {
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE transfer_id = ? ORDER BY id`
+	return r.query(query, transferID)
+}
+
+func (r *TransactionRepository) GetByGoalID(goalID int) ([]Transaction, error) {
 	query := `
-		INSERT INTO transactions (user_id, goal_id, amount, description, type, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE goal_id = ?
+		ORDER BY created_at DESC
 	`
-	result, err := r.db.Exec(query, transaction.UserID, transaction.GoalID, transaction.Amount, transaction.Description, transaction.Type, time.Now())
+	return r.query(query, goalID)
+}
+
+// GetByUserID returns the transactions on the goals the user is a member
+// of, newest first.
+func (r *TransactionRepository) GetByUserID(userID int) ([]Transaction, error) {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE ` + memberGoals + `
+		ORDER BY created_at DESC
+	`
+	return r.query(query, userID)
+}
+
+var transactionSortColumns = map[string]sortKind{
+	"created_at": sortTime,
+	"amount":     sortInt,
+}
+
+// ListByUserID returns one page of the transactions on the goals the user
+// is a member of, whoever recorded them, and the cursor of the next page,
+// which is empty on the last page.
+func (r *TransactionRepository) ListByUserID(userID int, opts ListOptions) ([]Transaction, string, error) {
+	q := r.listQuery(opts)
+	q.filter(memberGoals, userID)
+	return r.list(q, opts)
+}
+
+// ListByGoalID is ListByUserID for the transactions of one goal.
+func (r *TransactionRepository) ListByGoalID(goalID int, opts ListOptions) ([]Transaction, string, error) {
+	q := r.listQuery(opts)
+	q.filter("goal_id = ?", goalID)
+	return r.list(q, opts)
+}
+
+func (r *TransactionRepository) listQuery(opts ListOptions) *listQuery {
+	q := &listQuery{table: "transactions", columns: transactionColumns, sortable: transactionSortColumns}
+	if !opts.CreatedFrom.IsZero() {
+		q.filter("created_at >= ?", opts.CreatedFrom)
+	}
+	if !opts.CreatedTo.IsZero() {
+		q.filter("created_at < ?", opts.CreatedTo)
+	}
+	if opts.MinAmount != nil {
+		q.filter("amount >= ?", *opts.MinAmount)
+	}
+	if opts.MaxAmount != nil {
+		q.filter("amount <= ?", *opts.MaxAmount)
+	}
+	if opts.Type != "" {
+		q.filter("type = ?", opts.Type)
+	}
+	if opts.Search != "" {
+		q.search("description", opts.Search)
+	}
+	return q
+}
+
+func (r *TransactionRepository) list(q *listQuery, opts ListOptions) ([]Transaction, string, error) {
+	query, args, err := q.build(&opts)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	
-	id, err := result.LastInsertId()
+	transactions, err := r.query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if transactions == nil {
+		transactions = []Transaction{}
+	}
+	if len(transactions) <= opts.Limit {
+		return transactions, "", nil
+	}
+
+	transactions = transactions[:opts.Limit]
+	last := transactions[len(transactions)-1]
+	var value interface{} = last.CreatedAt
+	if opts.SortBy == "amount" {
+		value = last.Amount.Amount
+	}
+	next, err := encodeCursor(&opts, value, last.ID)
+	return transactions, next, err
+}
+
+// EachByUserID calls fn with each transaction GetByUserID returns, oldest
+// first, reading them one at a time instead of loading them all. The
+// transaction passed to fn is reused between calls.
+func (r *TransactionRepository) EachByUserID(userID int, fn func(*Transaction) error) error {
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		WHERE ` + memberGoals + `
+		ORDER BY created_at, id
+	`
+	return r.each(query, fn, userID)
+}
+
+func (r *TransactionRepository) query(query string, args ...interface{}) ([]Transaction, error) {
+	var transactions []Transaction
+	err := r.each(query, func(transaction *Transaction) error {
+		transactions = append(transactions, *transaction)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func (r *TransactionRepository) each(query string, fn func(*Transaction) error, args ...interface{}) error {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return err
 	}
-	
-	transaction.ID = int(id)
-	return nil
+	defer rows.Close()
+
+	var transaction Transaction
+	for rows.Next() {
+		transaction = Transaction{}
+		if err := scanTransaction(rows, &transaction); err != nil {
+			return err
+		}
+		if err := fn(&transaction); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
-func (r *TransactionRepository) GetByGoalID(goalID int) ([]Transaction, error) {
+func (r *TransactionRepository) GetTotalByGoalID(goalID int) (Money, error) {
 	query := `
-		SELECT id, user_id, goal_id, amount, description, type, created_at
+		SELECT
+			COALESCE(SUM(CASE WHEN type = 'add' THEN amount ELSE -amount END), 0) as total,
+			COALESCE((SELECT currency FROM goals WHERE id = ?), '') as currency
 		FROM transactions
 		WHERE goal_id = ?
-		ORDER BY created_at DESC
 	`
-	rows, err := r.db.Query(query, goalID)
+	var (
+		total    Money
+		currency string
+	)
+	err := r.db.QueryRow(query, goalID, goalID).Scan(&total, &currency)
+	total.Currency = currency
+	return total, err
+}
+
+// HistoryBucket is the net change of one goal's balance over one period
+// and the goal's balance at the end of it, in the goal's currency.
+type HistoryBucket struct {
+	GoalID  int
+	Period  string // first day of the period, 2006-01-02
+	Net     int64
+	Balance int64
+}
+
+// History sums the transactions before "to" on the user's goals per goal
+// and per day, week or month (see database.Dialect.DateBucket), oldest
+// first. Only periods with transactions are returned. Balance is a
+// running total over all earlier periods, so it holds even for the first
+// period a caller looks at.
+func (r *TransactionRepository) History(userID int, granularity string, to time.Time) ([]HistoryBucket, error) {
+	query := `
+		SELECT goal_id, period, net,
+			SUM(net) OVER (PARTITION BY goal_id ORDER BY period) AS balance
+		FROM (
+			SELECT goal_id, ` + r.db.Dialect.DateBucket("created_at", granularity) + ` AS period,
+				SUM(CASE WHEN type = 'add' THEN amount ELSE -amount END) AS net
+			FROM transactions
+			WHERE ` + memberGoals + ` AND created_at < ?
+			GROUP BY goal_id, period
+		) buckets
+		ORDER BY goal_id, period
+	`
+	rows, err := r.db.Query(query, userID, to)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var transactions []Transaction
+	buckets := []HistoryBucket{}
 	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(
-			&transaction.ID, &transaction.UserID, &transaction.GoalID,
-			&transaction.Amount, &transaction.Description, &transaction.Type,
-			&transaction.CreatedAt,
-		)
-		if err != nil {
+		var b HistoryBucket
+		if err := rows.Scan(&b.GoalID, &b.Period, &b.Net, &b.Balance); err != nil {
 			return nil, err
 		}
-		transactions = append(transactions, transaction)
+		buckets = append(buckets, b)
 	}
-	return transactions, nil
+	return buckets, rows.Err()
 }
 
-func (r *TransactionRepository) GetByUserID(userID int) ([]Transaction, error) {
+// CountImportHashes returns how many of the goal's transactions carry each
+// import hash. Voided transactions don't count, so their rows can be
+// imported again.
+func (r *TransactionRepository) CountImportHashes(goalID int) (map[string]int, error) {
 	query := `
-		SELECT id, user_id, goal_id, amount, description, type, created_at
+		SELECT import_hash, COUNT(*)
 		FROM transactions
-		WHERE user_id = ?
-		ORDER BY created_at DESC
+		WHERE goal_id = ? AND import_hash IS NOT NULL AND voided_at IS NULL
+		GROUP BY import_hash
 	`
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.Query(query, goalID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var transactions []Transaction
+	counts := make(map[string]int)
 	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(
-			&transaction.ID, &transaction.UserID, &transaction.GoalID,
-			&transaction.Amount, &transaction.Description, &transaction.Type,
-			&transaction.CreatedAt,
+		var (
+			hash  string
+			count int
 		)
-		if err != nil {
+		if err := rows.Scan(&hash, &count); err != nil {
 			return nil, err
 		}
-		transactions = append(transactions, transaction)
+		counts[hash] = count
 	}
-	return transactions, nil
+	return counts, rows.Err()
 }
 
-func (r *TransactionRepository) GetTotalByGoalID(goalID int) (float64, error) {
+// MemberTotal is how much one user has added to and taken out of a goal,
+// in the goal's currency.
+type MemberTotal struct {
+	UserID    int
+	Added     int64
+	Withdrawn int64
+}
+
+// TotalsByMember sums the goal's transactions per user who recorded them.
+// Voided transactions and their reversals are left out.
+func (r *TransactionRepository) TotalsByMember(goalID int) (map[int]MemberTotal, error) {
 	query := `
-		SELECT 
-			COALESCE(SUM(CASE WHEN type = 'add' THEN amount ELSE -amount END), 0) as total
+		SELECT user_id,
+			COALESCE(SUM(CASE WHEN type = 'add' THEN amount ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN type = 'remove' THEN amount ELSE 0 END), 0)
 		FROM transactions
-		WHERE goal_id = ?
+		WHERE goal_id = ? AND voided_at IS NULL AND reversal_of IS NULL
+		GROUP BY user_id
 	`
-	var total float64
-	err := r.db.QueryRow(query, goalID).Scan(&total)
-	return total, err
+	rows, err := r.db.Query(query, goalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[int]MemberTotal)
+	for rows.Next() {
+		var t MemberTotal
+		if err := rows.Scan(&t.UserID, &t.Added, &t.Withdrawn); err != nil {
+			return nil, err
+		}
+		totals[t.UserID] = t
+	}
+	return totals, rows.Err()
 }
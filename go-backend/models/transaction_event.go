@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	EventAmend = "amend"
+	EventVoid  = "void"
+)
+
+// Change is the old and new value of one amended field.
+type Change struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TransactionEvent records who amended or voided a transaction and when.
+// Changes is set for amendments, keyed by field name; ReversalID for voids.
+type TransactionEvent struct {
+	ID            int               `json:"id" db:"id"`
+	TransactionID int               `json:"transaction_id" db:"transaction_id"`
+	UserID        int               `json:"user_id" db:"user_id"`
+	Action        string            `json:"action" db:"action"` // "amend" or "void"
+	Changes       map[string]Change `json:"changes,omitempty" db:"changes"`
+	ReversalID    *int              `json:"reversal_id,omitempty" db:"reversal_id"`
+	CreatedAt     time.Time         `json:"created_at" db:"created_at"`
+}
+
+type TransactionEventRepository struct {
+	db *database.DB
+}
+
+func NewTransactionEventRepository(db *database.DB) *TransactionEventRepository {
+	return &TransactionEventRepository{db: db}
+}
+
+// GetByTransactionID returns the transaction's events, oldest first.
+func (r *TransactionEventRepository) GetByTransactionID(transactionID int) ([]TransactionEvent, error) {
+	query := `
+		SELECT id, transaction_id, user_id, action, changes, reversal_id, created_at
+		FROM transaction_events
+		WHERE transaction_id = ?
+		ORDER BY created_at, id
+	`
+	rows, err := r.db.Query(query, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TransactionEvent{}
+	for rows.Next() {
+		var (
+			event      TransactionEvent
+			changes    sql.NullString
+			reversalID sql.NullInt64
+		)
+		err := rows.Scan(&event.ID, &event.TransactionID, &event.UserID, &event.Action,
+			&changes, &reversalID, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if changes.Valid {
+			if err := json.Unmarshal([]byte(changes.String), &event.Changes); err != nil {
+				return nil, err
+			}
+		}
+		if reversalID.Valid {
+			id := int(reversalID.Int64)
+			event.ReversalID = &id
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func insertTransactionEvent(q DBTX, event *TransactionEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	var changes sql.NullString
+	if len(event.Changes) > 0 {
+		encoded, err := json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+		changes = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	query := `
+		INSERT INTO transaction_events (transaction_id, user_id, action, changes, reversal_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return q.QueryRow(query, event.TransactionID, event.UserID, event.Action,
+		changes, event.ReversalID, event.CreatedAt).Scan(&event.ID)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var ErrSameGoal = errors.New("cannot transfer to the same goal")
+
+// Transfer moves money from one goal to another. It is recorded as a
+// "remove" transaction on the source and an "add" on the destination,
+// both carrying the transfer's ID. Amount is in the source goal's
+// currency and ConvertedAmount in the destination's.
+type Transfer struct {
+	ID     int `json:"id" db:"id"`
+	UserID int `json:"user_id" db:"user_id"`
+	// A goal ID is 0 once that goal has been purged
+	FromGoalID      int       `json:"from_goal_id" db:"from_goal_id"`
+	ToGoalID        int       `json:"to_goal_id" db:"to_goal_id"`
+	Amount          Money     `json:"amount" db:"amount"`
+	ConvertedAmount Money     `json:"converted_amount" db:"converted_amount"`
+	ExchangeRate    string    `json:"exchange_rate,omitempty" db:"exchange_rate"`
+	Description     string    `json:"description" db:"description"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+const transferColumns = `id, user_id, from_goal_id, to_goal_id, currency, amount,
+	converted_currency, converted_amount, exchange_rate, description, created_at`
+
+func scanTransfer(row rowScanner, t *Transfer) error {
+	var (
+		fromGoalID, toGoalID        sql.NullInt64
+		currency, convertedCurrency string
+		exchangeRate                sql.NullString
+	)
+	err := row.Scan(
+		&t.ID, &t.UserID, &fromGoalID, &toGoalID, &currency, &t.Amount,
+		&convertedCurrency, &t.ConvertedAmount, &exchangeRate, &t.Description, &t.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	t.Amount.Currency = currency
+	t.ConvertedAmount.Currency = convertedCurrency
+	t.FromGoalID = int(fromGoalID.Int64)
+	t.ToGoalID = int(toGoalID.Int64)
+	t.ExchangeRate = exchangeRate.String
+	return nil
+}
+
+type TransferRepository struct {
+	db *database.DB
+}
+
+func NewTransferRepository(db *database.DB) *TransferRepository {
+	return &TransferRepository{db: db}
+}
+
+func (r *TransferRepository) GetByUserID(userID int) ([]Transfer, error) {
+	query := `
+		SELECT ` + transferColumns + `
+		FROM transfers
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		var t Transfer
+		if err := scanTransfer(rows, &t); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
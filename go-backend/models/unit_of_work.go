@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+// DBTX is satisfied by both *database.DB and *database.Tx, so repository
+// helpers can run either standalone or as part of a unit of work. Queries
+// use ? placeholders; both implementations rebind them for the dialect.
+type DBTX interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type UnitOfWork struct {
+	db *database.DB
+}
+
+func NewUnitOfWork(db *database.DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+// Do runs fn inside a single database transaction. The transaction is
+// committed if fn returns nil and rolled back otherwise.
+func (u *UnitOfWork) Do(fn func(tx DBTX) error) error {
+	tx, err := u.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
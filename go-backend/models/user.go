@@ -2,55 +2,150 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeReused     = errors.New("one-time code was already used")
 )
 
 type User struct {
-	ID           int       `json:"id" db:"id"`
-	Name         string    `json:"name" db:"name"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ID              int        `json:"id" db:"id"`
+	Name            string     `json:"name" db:"name"`
+	Email           string     `json:"email" db:"email"`
+	BaseCurrency    string     `json:"base_currency" db:"base_currency"`
+	PasswordHash    string     `json:"-" db:"password_hash"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	// TOTPSecret is set from enrollment on; TOTPEnabledAt only once the
+	// user has confirmed a code from their authenticator app.
+	TOTPSecret    string     `json:"-" db:"totp_secret"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at" db:"totp_enabled_at"`
+	// ParentID is set on child accounts, whose withdrawals need the
+	// parent's approval.
+	ParentID  *int      `json:"parent_id,omitempty" db:"parent_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) IsTOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+func (u *User) IsChild() bool {
+	return u.ParentID != nil
+}
+
+const userColumns = `id, name, email, base_currency, password_hash, email_verified_at,
+	totp_secret, totp_enabled_at, parent_id, created_at`
+
+func scanUser(row rowScanner, user *User) error {
+	var (
+		verifiedAt    sql.NullTime
+		totpSecret    sql.NullString
+		totpEnabledAt sql.NullTime
+		parentID      sql.NullInt64
+	)
+	err := row.Scan(
+		&user.ID, &user.Name, &user.Email, &user.BaseCurrency, &user.PasswordHash,
+		&verifiedAt, &totpSecret, &totpEnabledAt, &parentID, &user.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	user.TOTPSecret = totpSecret.String
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		user.ParentID = &id
+	}
+	return nil
 }
 
 type UserRepository struct {
-	db *sql.DB
+	db *database.DB
 }
 
-func NewUserRepository(db *sql.DB) *UserRepository {
+func NewUserRepository(db *database.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
 func (r *UserRepository) Create(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, created_at)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO users (name, email, base_currency, password_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id
 	`
-	result, err := r.db.Exec(query, user.Name, user.Email, user.PasswordHash, time.Now())
-	if err != nil {
-		return err
+	if user.BaseCurrency == "" {
+		user.BaseCurrency = DefaultCurrency
 	}
-	
-	id, err := result.LastInsertId()
+	user.CreatedAt = time.Now()
+
+	return r.db.QueryRow(query, user.Name, user.Email, user.BaseCurrency, user.PasswordHash, user.CreatedAt).Scan(&user.ID)
+}
+
+// CreateChild creates an account managed by user.ParentID. The parent
+// chose the address, so it counts as verified from the start.
+func (r *UserRepository) CreateChild(user *User) error {
+	query := `
+		INSERT INTO users (name, email, base_currency, password_hash, email_verified_at, parent_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	if user.BaseCurrency == "" {
+		user.BaseCurrency = DefaultCurrency
+	}
+	user.CreatedAt = time.Now()
+	user.EmailVerifiedAt = &user.CreatedAt
+
+	return r.db.QueryRow(query, user.Name, user.Email, user.BaseCurrency, user.PasswordHash,
+		user.EmailVerifiedAt, *user.ParentID, user.CreatedAt).Scan(&user.ID)
+}
+
+// GetChildren returns the accounts the user manages, oldest first.
+func (r *UserRepository) GetChildren(parentID int) ([]User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE parent_id = ?
+		ORDER BY created_at, id
+	`
+	rows, err := r.db.Query(query, parentID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	user.ID = int(id)
-	return nil
+	defer rows.Close()
+
+	children := []User{}
+	for rows.Next() {
+		var child User
+		if err := scanUser(rows, &child); err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, rows.Err()
 }
 
 func (r *UserRepository) GetByEmail(email string) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, name, email, password_hash, created_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = ?
 	`
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Name, &user.Email, &user.PasswordHash, &user.CreatedAt,
-	)
-	if err != nil {
+	if err := scanUser(r.db.QueryRow(query, email), user); err != nil {
 		return nil, err
 	}
 	return user, nil
@@ -59,15 +154,98 @@ func (r *UserRepository) GetByEmail(email string) (*User, error) {
 func (r *UserRepository) GetByID(id int) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, name, email, password_hash, created_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = ?
 	`
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Name, &user.Email, &user.PasswordHash, &user.CreatedAt,
-	)
-	if err != nil {
+	if err := scanUser(r.db.QueryRow(query, id), user); err != nil {
 		return nil, err
 	}
 	return user, nil
 }
+
+// lockUser holds the user's row until the transaction ends, so transactions
+// that read and then spend something of the user's run one at a time. The
+// no-op update takes a row lock on PostgreSQL and the write lock on SQLite,
+// where it is already held if transactions begin immediately.
+func lockUser(q DBTX, userID int) error {
+	_, err := q.Exec(`UPDATE users SET id = id WHERE id = ?`, userID)
+	return err
+}
+
+func (r *UserRepository) UpdatePassword(id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = ? WHERE id = ?`
+	_, err := r.db.Exec(query, passwordHash, id)
+	return err
+}
+
+// MarkEmailVerified records when the user proved they own their address.
+// Verifying again keeps the original timestamp.
+func (r *UserRepository) MarkEmailVerified(id int) error {
+	query := `UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL`
+	_, err := r.db.Exec(query, time.Now(), id)
+	return err
+}
+
+// SetTOTPSecret stores the secret of a pending enrollment. It fails with
+// ErrTOTPAlreadyEnabled instead of replacing the secret of an enabled one.
+func (r *UserRepository) SetTOTPSecret(id int, secret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = ?, totp_last_step = NULL
+		WHERE id = ? AND totp_enabled_at IS NULL
+	`
+	result, err := r.db.Exec(query, secret, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *UserRepository) EnableTOTP(id int) error {
+	query := `UPDATE users SET totp_enabled_at = ? WHERE id = ? AND totp_secret IS NOT NULL`
+	_, err := r.db.Exec(query, time.Now(), id)
+	return err
+}
+
+func (r *UserRepository) DisableTOTP(id int) error {
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = ?
+	`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// UseTOTPStep records that a code for the given time step was accepted.
+// It fails with ErrTOTPCodeReused if that step or a later one was already
+// used, which stops an observed code from being replayed.
+func (r *UserRepository) UseTOTPStep(id int, step int64) error {
+	query := `
+		UPDATE users
+		SET totp_last_step = ?
+		WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)
+	`
+	result, err := r.db.Exec(query, step, id, step)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
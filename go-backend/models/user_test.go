@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+func TestUseTOTPStepRejectsReuse(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		users := NewUserRepository(db)
+		user := &User{Name: "Saver", Email: "saver@example.com", PasswordHash: "x"}
+		if err := users.Create(user); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+
+		tests := []struct {
+			name    string
+			step    int64
+			wantErr error
+		}{
+			{"first code", 1000, nil},
+			{"same step again", 1000, ErrTOTPCodeReused},
+			{"earlier step", 999, ErrTOTPCodeReused},
+			{"next step", 1001, nil},
+		}
+		for _, tt := range tests {
+			if err := users.UseTOTPStep(user.ID, tt.step); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+	})
+}
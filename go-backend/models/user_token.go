@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+var ErrTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// UserToken is a single-use token sent to a user by email. Only a keyed
+// hash of the token is stored.
+type UserToken struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	Purpose   string     `json:"purpose" db:"purpose"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+type UserTokenRepository struct {
+	db *database.DB
+}
+
+func NewUserTokenRepository(db *database.DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// Create stores a new token and retires the user's earlier unused tokens
+// for the same purpose, so only the most recent email works.
+func (r *UserTokenRepository) Create(token *UserToken) error {
+	token.CreatedAt = time.Now()
+
+	return NewUnitOfWork(r.db).Do(func(tx DBTX) error {
+		retire := `
+			UPDATE user_tokens
+			SET used_at = ?
+			WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+		`
+		if _, err := tx.Exec(retire, token.CreatedAt, token.UserID, token.Purpose); err != nil {
+			return err
+		}
+
+		insert := `
+			INSERT INTO user_tokens (user_id, purpose, token_hash, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING id
+		`
+		return tx.QueryRow(insert, token.UserID, token.Purpose, token.TokenHash,
+			token.CreatedAt, token.ExpiresAt).Scan(&token.ID)
+	})
+}
+
+// Consume marks a token as used and returns it. The check and the update
+// are one statement, so a token can never be used twice.
+func (r *UserTokenRepository) Consume(purpose, tokenHash string) (*UserToken, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING id, user_id, purpose, token_hash, created_at, expires_at
+	`
+	now := time.Now()
+	token := &UserToken{UsedAt: &now}
+	err := r.db.QueryRow(query, now, tokenHash, purpose, now).Scan(
+		&token.ID, &token.UserID, &token.Purpose, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
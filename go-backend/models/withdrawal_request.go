@@ -0,0 +1,273 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+)
+
+const (
+	WithdrawalPending   = "pending"
+	WithdrawalApproved  = "approved"
+	WithdrawalRejected  = "rejected"
+	WithdrawalCancelled = "cancelled"
+	// WithdrawalFailed means the goal could no longer cover a withdrawal
+	// when it was released
+	WithdrawalFailed = "failed"
+)
+
+var (
+	ErrWithdrawalRequestNotFound = errors.New("withdrawal request not found")
+	ErrWithdrawalDecided         = errors.New("withdrawal request has already been decided")
+	ErrWithdrawalCoolingOff      = errors.New("withdrawal request is cooling off")
+	// ErrWithdrawalLimitExceeded is for checks passed to Create to turn a
+	// request down with
+	ErrWithdrawalLimitExceeded = errors.New("withdrawal limit exceeded")
+)
+
+// WithdrawalRequest is a "remove" that cannot happen yet: one a child
+// account asked for, which waits for a parent's approval, or one from a
+// locked goal, which waits until AvailableAt. Nothing leaves the goal
+// before then; TransactionID is the transaction that did. Penalty is the
+// part of Amount that went to PenaltyGoalID instead, if any.
+type WithdrawalRequest struct {
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	UserName      string     `json:"user_name" db:"user_name"`
+	GoalID        int        `json:"goal_id" db:"goal_id"`
+	GoalTitle     string     `json:"goal_title" db:"goal_title"`
+	Currency      string     `json:"currency" db:"currency"`
+	Amount        Money      `json:"amount" db:"amount"`
+	Description   string     `json:"description" db:"description"`
+	Status        string     `json:"status" db:"status"`
+	DecidedBy     *int       `json:"decided_by,omitempty" db:"decided_by"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty" db:"decided_at"`
+	Reason        string     `json:"reason,omitempty" db:"reason"`
+	TransactionID *int       `json:"transaction_id,omitempty" db:"transaction_id"`
+	AvailableAt   *time.Time `json:"available_at,omitempty" db:"available_at"`
+	Penalty       *Money     `json:"penalty,omitempty" db:"penalty"`
+	PenaltyGoalID *int       `json:"penalty_goal_id,omitempty" db:"penalty_goal_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CoolingOff reports whether the request must still wait at now.
+func (w *WithdrawalRequest) CoolingOff(now time.Time) bool {
+	return w.AvailableAt != nil && now.Before(*w.AvailableAt)
+}
+
+const withdrawalRequestSelect = `
+	SELECT w.id, w.user_id, u.name, w.goal_id, g.title, w.currency, w.amount, w.description,
+		w.status, w.decided_by, w.decided_at, w.reason, w.transaction_id, w.available_at, w.penalty,
+		w.penalty_goal_id, w.created_at
+	FROM withdrawal_requests w
+	JOIN users u ON u.id = w.user_id
+	JOIN goals g ON g.id = w.goal_id
+`
+
+func scanWithdrawalRequest(row rowScanner, w *WithdrawalRequest) error {
+	var (
+		amount        int64
+		description   sql.NullString
+		decidedBy     sql.NullInt64
+		decidedAt     sql.NullTime
+		reason        sql.NullString
+		transactionID sql.NullInt64
+		availableAt   sql.NullTime
+		penalty       sql.NullInt64
+		penaltyGoalID sql.NullInt64
+	)
+	err := row.Scan(&w.ID, &w.UserID, &w.UserName, &w.GoalID, &w.GoalTitle, &w.Currency, &amount,
+		&description, &w.Status, &decidedBy, &decidedAt, &reason, &transactionID, &availableAt, &penalty,
+		&penaltyGoalID, &w.CreatedAt)
+	if err != nil {
+		return err
+	}
+	w.Amount = NewMoney(amount, w.Currency)
+	w.Description = description.String
+	if decidedBy.Valid {
+		id := int(decidedBy.Int64)
+		w.DecidedBy = &id
+	}
+	w.DecidedAt = nullTimePtr(decidedAt)
+	w.Reason = reason.String
+	if transactionID.Valid {
+		id := int(transactionID.Int64)
+		w.TransactionID = &id
+	}
+	w.AvailableAt = nullTimePtr(availableAt)
+	w.Penalty = nullMoneyPtr(penalty, w.Currency)
+	if penaltyGoalID.Valid {
+		id := int(penaltyGoalID.Int64)
+		w.PenaltyGoalID = &id
+	}
+	return nil
+}
+
+type WithdrawalRequestRepository struct {
+	db  *database.DB
+	uow *UnitOfWork
+}
+
+func NewWithdrawalRequestRepository(db *database.DB) *WithdrawalRequestRepository {
+	return &WithdrawalRequestRepository{db: db, uow: NewUnitOfWork(db)}
+}
+
+// Create stores a pending request. In the same database transaction it
+// reads the totals of the user's requests since since, after any other
+// request by the user has committed, and passes them to check, which
+// vetoes the request by returning an error (see
+// ErrWithdrawalLimitExceeded).
+func (r *WithdrawalRequestRepository) Create(w *WithdrawalRequest, since time.Time, check func(totals []Money) error) error {
+	return r.uow.Do(func(tx DBTX) error {
+		if err := lockUser(tx, w.UserID); err != nil {
+			return err
+		}
+		totals, err := withdrawalTotalsSince(tx, w.UserID, since)
+		if err != nil {
+			return err
+		}
+		if err := check(totals); err != nil {
+			return err
+		}
+
+		w.Status = WithdrawalPending
+		w.Currency = w.Amount.Currency
+		w.CreatedAt = time.Now()
+		query := `
+			INSERT INTO withdrawal_requests (user_id, goal_id, currency, amount, description, status,
+				available_at, penalty, penalty_goal_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+		`
+		return tx.QueryRow(query, w.UserID, w.GoalID, w.Currency, w.Amount.Amount, w.Description,
+			w.Status, w.AvailableAt, moneyOrNull(w.Penalty), w.PenaltyGoalID, w.CreatedAt).Scan(&w.ID)
+	})
+}
+
+func (r *WithdrawalRequestRepository) GetByID(id int) (*WithdrawalRequest, error) {
+	w := &WithdrawalRequest{}
+	err := scanWithdrawalRequest(r.db.QueryRow(withdrawalRequestSelect+`WHERE w.id = ?`, id), w)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWithdrawalRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// GetForUser returns the user's own requests and those of the children
+// they manage, newest first, optionally only those with status.
+func (r *WithdrawalRequestRepository) GetForUser(userID int, status string) ([]WithdrawalRequest, error) {
+	query := withdrawalRequestSelect + `WHERE (w.user_id = ? OR u.parent_id = ?)`
+	args := []interface{}{userID, userID}
+	if status != "" {
+		query += ` AND w.status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY w.created_at DESC, w.id DESC`
+
+	return r.query(query, args...)
+}
+
+func (r *WithdrawalRequestRepository) query(query string, args ...interface{}) ([]WithdrawalRequest, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []WithdrawalRequest{}
+	for rows.Next() {
+		var w WithdrawalRequest
+		if err := scanWithdrawalRequest(rows, &w); err != nil {
+			return nil, err
+		}
+		requests = append(requests, w)
+	}
+	return requests, rows.Err()
+}
+
+// withdrawalTotalsSince sums the user's pending and approved requests made
+// since the given time, one total per currency.
+func withdrawalTotalsSince(q DBTX, userID int, since time.Time) ([]Money, error) {
+	query := `
+		SELECT currency, SUM(amount)
+		FROM withdrawal_requests
+		WHERE user_id = ? AND status IN (?, ?) AND created_at >= ?
+		GROUP BY currency
+	`
+	rows, err := q.Query(query, userID, WithdrawalPending, WithdrawalApproved, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []Money{}
+	for rows.Next() {
+		var total Money
+		if err := rows.Scan(&total.Currency, &total); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// GetReleasable returns pending requests from locked goals that have
+// waited out their cooling-off period by now, in the order they were made,
+// starting after the request with ID afterID. Children's requests are left
+// to their parents.
+func (r *WithdrawalRequestRepository) GetReleasable(now time.Time, afterID, limit int) ([]WithdrawalRequest, error) {
+	query := withdrawalRequestSelect + `
+		WHERE w.status = ? AND w.available_at <= ? AND u.parent_id IS NULL AND w.id > ?
+		ORDER BY w.id
+		LIMIT ?
+	`
+	return r.query(query, WithdrawalPending, now, afterID, limit)
+}
+
+// Reject turns a pending request down on behalf of a parent.
+func (r *WithdrawalRequestRepository) Reject(w *WithdrawalRequest, parentID int, reason string) error {
+	return decideWithdrawal(r.db, w, WithdrawalRejected, parentID, reason, nil)
+}
+
+// Cancel withdraws a pending request on behalf of the child who made it.
+func (r *WithdrawalRequestRepository) Cancel(w *WithdrawalRequest) error {
+	return decideWithdrawal(r.db, w, WithdrawalCancelled, w.UserID, "", nil)
+}
+
+// Fail closes a released request the goal could no longer cover.
+func (r *WithdrawalRequestRepository) Fail(w *WithdrawalRequest, reason string) error {
+	return decideWithdrawal(r.db, w, WithdrawalFailed, w.UserID, reason, nil)
+}
+
+// decideWithdrawal moves a pending request to status. It returns
+// ErrWithdrawalDecided if someone else decided it in the meantime.
+func decideWithdrawal(q DBTX, w *WithdrawalRequest, status string, decidedBy int, reason string, transactionID *int) error {
+	now := time.Now()
+	query := `
+		UPDATE withdrawal_requests
+		SET status = ?, decided_by = ?, decided_at = ?, reason = ?, transaction_id = ?
+		WHERE id = ? AND status = ?
+	`
+	result, err := q.Exec(query, status, decidedBy, now, reason, transactionID, w.ID, WithdrawalPending)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWithdrawalDecided
+	}
+	w.Status = status
+	w.DecidedBy = &decidedBy
+	w.DecidedAt = &now
+	w.Reason = reason
+	w.TransactionID = transactionID
+	return nil
+}
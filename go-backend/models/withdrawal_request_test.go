@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oleksii-dukh/cashcandy/go-backend/database"
+	"github.com/oleksii-dukh/cashcandy/go-backend/database/dbtest"
+)
+
+func TestConcurrentRequestsCannotExceedLimit(t *testing.T) {
+	dbtest.ForEachMigrated(t, func(t *testing.T, db *database.DB) {
+		goal := newTestGoal(t, db, 1000)
+		repo := NewWithdrawalRequestRepository(db)
+		since := MonthStart(time.Now())
+
+		// At most 250 a month
+		check := func(totals []Money) error {
+			total := int64(100)
+			for _, m := range totals {
+				total += m.Amount
+			}
+			if total > 250 {
+				return ErrWithdrawalLimitExceeded
+			}
+			return nil
+		}
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := &WithdrawalRequest{UserID: goal.UserID, GoalID: goal.ID, Amount: NewMoney(100, "USD")}
+				err := repo.Create(w, since, check)
+				if err != nil && !errors.Is(err, ErrWithdrawalLimitExceeded) {
+					t.Errorf("Create: %v", err)
+				}
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 2 {
+			t.Errorf("%d requests went through, want 2", succeeded)
+		}
+		totals, err := withdrawalTotalsSince(db, goal.UserID, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0].Amount != 200 {
+			t.Errorf("totals = %v, want 200 USD", totals)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package rates
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+const dateLayout = "2006-01-02"
+
+type pair struct {
+	from, to string
+}
+
+type quote struct {
+	date time.Time
+	rate *big.Rat
+}
+
+// CSVProvider serves exchange rates from a local CSV file, so conversions
+// work without network access. The file has a header row and one rate per
+// line (lines starting with # are ignored):
+//
+//	date,from,to,rate
+//	2026-10-01,EUR,USD,1.0842
+//
+// A rate converts one unit of "from" into "to". For a given day the most
+// recent rate on or before that day is used. Inverse pairs are derived
+// automatically, and pairs without a direct rate are crossed through a
+// currency that has rates for both sides.
+type CSVProvider struct {
+	quotes map[pair][]quote
+}
+
+// NewCSVProvider returns a provider without any rates. It can still convert
+// a currency into itself.
+func NewCSVProvider() *CSVProvider {
+	return &CSVProvider{quotes: make(map[pair][]quote)}
+}
+
+func LoadCSV(path string) (*CSVProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseCSV(file)
+}
+
+func ParseCSV(r io.Reader) (*CSVProvider, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	provider := NewCSVProvider()
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rates: %v", err)
+		}
+		line++
+		if line == 1 && strings.EqualFold(record[0], "date") {
+			continue
+		}
+
+		date, err := time.Parse(dateLayout, record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[0])
+		}
+		rate, ok := new(big.Rat).SetString(record[3])
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, record[3])
+		}
+
+		from := strings.ToUpper(record[1])
+		to := strings.ToUpper(record[2])
+		provider.add(pair{from, to}, quote{date: date, rate: rate})
+		provider.add(pair{to, from}, quote{date: date, rate: new(big.Rat).Inv(rate)})
+	}
+
+	for p := range provider.quotes {
+		quotes := provider.quotes[p]
+		sort.Slice(quotes, func(i, j int) bool { return quotes[i].date.Before(quotes[j].date) })
+	}
+	return provider, nil
+}
+
+func (p *CSVProvider) add(key pair, q quote) {
+	p.quotes[key] = append(p.quotes[key], q)
+}
+
+// Rate returns how many units of "to" one unit of "from" was worth at the
+// given time.
+func (p *CSVProvider) Rate(from, to string, at time.Time) (*big.Rat, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	if rate, ok := p.lookup(pair{from, to}, at); ok {
+		return rate, nil
+	}
+
+	// Cross through an intermediate currency, e.g. UAH -> USD -> EUR.
+	// Candidates are tried in alphabetical order to keep results stable.
+	var via []string
+	for key := range p.quotes {
+		if key.from == from && key.to != to {
+			via = append(via, key.to)
+		}
+	}
+	sort.Strings(via)
+
+	for _, middle := range via {
+		first, ok := p.lookup(pair{from, middle}, at)
+		if !ok {
+			continue
+		}
+		second, ok := p.lookup(pair{middle, to}, at)
+		if !ok {
+			continue
+		}
+		return new(big.Rat).Mul(first, second), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s to %s on %s", ErrRateNotFound, from, to, at.Format(dateLayout))
+}
+
+func (p *CSVProvider) lookup(key pair, at time.Time) (*big.Rat, bool) {
+	quotes := p.quotes[key]
+	// Index of the first quote dated after the requested day
+	i := sort.Search(len(quotes), func(i int) bool { return quotes[i].date.After(at) })
+	if i == 0 {
+		return nil, false
+	}
+	return quotes[i-1].rate, true
+}
@@ -0,0 +1,263 @@
+// Package schedule parses and evaluates recurrence rules for standing
+// orders. Rules use a subset of the iCalendar RRULE syntax (RFC 5545):
+//
+//	FREQ=DAILY;INTERVAL=2          every other day
+//	FREQ=WEEKLY;BYDAY=FR           every Friday
+//	FREQ=WEEKLY;BYDAY=MO,TH        every Monday and Thursday
+//	FREQ=MONTHLY;BYMONTHDAY=1      on the 1st of each month
+//	FREQ=MONTHLY;BYMONTHDAY=-1     on the last day of each month
+//	FREQ=MONTHLY;COUNT=12          monthly on the start day, 12 times
+//
+// Occurrences fall at the time of day of the schedule's start, in the
+// start's location. Without BYDAY or BYMONTHDAY the start's weekday or day
+// of month is used. Monthly days past the end of a shorter month fall on
+// its last day, so BYMONTHDAY=31 still runs in February.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []time.Weekday // weekly only
+	ByMonthDay int            // monthly only; 0 means the start's day, -1 the last day
+	Count      int            // 0 means no limit
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;BYDAY=FR". An optional "RRULE:"
+// prefix is accepted.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+	if s == "" {
+		return Rule{}, fmt.Errorf("%w: empty", ErrInvalidRule)
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("%w: %q is not KEY=VALUE", ErrInvalidRule, part)
+		}
+		if seen[key] {
+			return Rule{}, fmt.Errorf("%w: %s given twice", ErrInvalidRule, key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch f := Frequency(value); f {
+			case Daily, Weekly, Monthly:
+				rule.Freq = f
+			default:
+				return Rule{}, fmt.Errorf("%w: unsupported FREQ %s", ErrInvalidRule, value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 366 {
+				return Rule{}, fmt.Errorf("%w: INTERVAL must be between 1 and 366", ErrInvalidRule)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, fmt.Errorf("%w: COUNT must be positive", ErrInvalidRule)
+			}
+			rule.Count = n
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					return Rule{}, fmt.Errorf("%w: unknown day %q", ErrInvalidRule, day)
+				}
+				rule.ByDay = append(rule.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(value)
+			if err != nil || n == 0 || n < -1 || n > 31 {
+				return Rule{}, fmt.Errorf("%w: BYMONTHDAY must be 1 to 31 or -1", ErrInvalidRule)
+			}
+			rule.ByMonthDay = n
+		default:
+			return Rule{}, fmt.Errorf("%w: unsupported part %s", ErrInvalidRule, key)
+		}
+	}
+
+	switch {
+	case rule.Freq == "":
+		return Rule{}, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	case len(rule.ByDay) > 0 && rule.Freq != Weekly:
+		return Rule{}, fmt.Errorf("%w: BYDAY needs FREQ=WEEKLY", ErrInvalidRule)
+	case rule.ByMonthDay != 0 && rule.Freq != Monthly:
+		return Rule{}, fmt.Errorf("%w: BYMONTHDAY needs FREQ=MONTHLY", ErrInvalidRule)
+	}
+	sort.Slice(rule.ByDay, func(i, j int) bool { return rule.ByDay[i] < rule.ByDay[j] })
+	return rule, nil
+}
+
+// String formats the rule in the form Parse reads.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = strings.ToUpper(wd.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.ByMonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.ByMonthDay))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the first occurrence strictly after "after" for a schedule
+// starting at start. The start itself is an occurrence only if it matches
+// the rule. COUNT is not applied here; callers track how many
+// occurrences have run.
+func (r Rule) Next(start, after time.Time) time.Time {
+	if after.Before(start) {
+		after = start.Add(-time.Nanosecond)
+	}
+
+	switch r.Freq {
+	case Daily:
+		days := daysBetween(start, after)
+		if days < 0 {
+			days = 0
+		}
+		k := days / r.Interval * r.Interval
+		for {
+			occurrence := start.AddDate(0, 0, k)
+			if occurrence.After(after) {
+				return occurrence
+			}
+			k += r.Interval
+		}
+
+	case Weekly:
+		byDay := r.ByDay
+		if len(byDay) == 0 {
+			byDay = []time.Weekday{start.Weekday()}
+		}
+		startWeek := weekStart(start)
+		day := dateOf(after, start)
+		// Any window of Interval weeks contains an occurrence
+		for i := 0; i <= 7*r.Interval+7; i++ {
+			occurrence := day.AddDate(0, 0, i)
+			if !occurrence.After(after) || occurrence.Before(start) {
+				continue
+			}
+			weeks := daysBetween(startWeek, weekStart(occurrence)) / 7
+			if weeks%r.Interval == 0 && containsWeekday(byDay, occurrence.Weekday()) {
+				return occurrence
+			}
+		}
+
+	case Monthly:
+		monthDay := r.ByMonthDay
+		if monthDay == 0 {
+			monthDay = start.Day()
+		}
+		months := monthsBetween(start, after)
+		if months < 0 {
+			months = 0
+		}
+		months = months / r.Interval * r.Interval
+		for {
+			occurrence := monthlyOccurrence(start, months, monthDay)
+			if occurrence.After(after) && !occurrence.Before(start) {
+				return occurrence
+			}
+			months += r.Interval
+		}
+	}
+	return time.Time{}
+}
+
+// dateOf is the day of t at the clock time of start.
+func dateOf(t, start time.Time) time.Time {
+	t = t.In(start.Location())
+	return time.Date(t.Year(), t.Month(), t.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+}
+
+func daysBetween(from, to time.Time) int {
+	to = to.In(from.Location())
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func monthsBetween(from, to time.Time) int {
+	to = to.In(from.Location())
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
+
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+	return t.AddDate(0, 0, -offset)
+}
+
+// monthlyOccurrence is the occurrence in the month "months" after start's
+// month, clamping the day to the length of that month.
+func monthlyOccurrence(start time.Time, months, monthDay int) time.Time {
+	first := time.Date(start.Year(), start.Month()+time.Month(months), 1,
+		start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	day := monthDay
+	if day == -1 || day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func containsWeekday(days []time.Weekday, wd time.Weekday) bool {
+	for _, d := range days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// NextRun is the occurrence after "after" that should still run, taking
+// COUNT, the number of occurrences already run and an optional end time
+// into account. It returns nil when the schedule has finished.
+func (r Rule) NextRun(start time.Time, end *time.Time, runs int, after time.Time) *time.Time {
+	if r.Count > 0 && runs >= r.Count {
+		return nil
+	}
+	next := r.Next(start, after)
+	if next.IsZero() || (end != nil && next.After(*end)) {
+		return nil
+	}
+	return &next
+}
@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The shared secret of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B. They
+// are 8 digits long; the 6 digit codes are their last 6 digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"previous step", -Period, true},
+		{"current step", 0, true},
+		{"next step", Period, true},
+		{"two steps ago", -2 * Period, false},
+		{"two steps ahead", 2 * Period, false},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, now.Add(tt.offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != Step(now.Add(tt.offset)) {
+			t.Errorf("%s: step = %d, want %d", tt.name, step, Step(now.Add(tt.offset)))
+		}
+	}
+}
+
+func TestValidateRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name, secret, input string
+	}{
+		{"wrong code", rfcSecret, "000000"},
+		{"too short", rfcSecret, "05047"},
+		{"too long", rfcSecret, "0504710"},
+		{"invalid secret", "not base32!", "050471"},
+	}
+
+	for _, tt := range tests {
+		if _, ok := Validate(tt.secret, tt.input, now); ok {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+
+	// Spaces, as some apps show the code in groups, are ignored
+	if _, ok := Validate(rfcSecret, "050 471", now); !ok {
+		t.Error("code with a space rejected")
+	}
+}
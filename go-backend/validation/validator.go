@@ -0,0 +1,171 @@
+// Package validation checks request structs against their validate tags
+// and describes every failure in a form API clients can show per field.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/oleksii-dukh/cashcandy/go-backend/models"
+	"github.com/oleksii-dukh/cashcandy/go-backend/schedule"
+)
+
+// Validator implements echo.Validator. Besides the built-in rules it knows:
+//
+//   - future: a time.Time after now
+//   - currency: a supported currency code such as "EUR"
+//   - date: a date (2006-01-02) or an RFC 3339 time
+//   - amount: a decimal amount of money such as "12.50"
+//   - rrule: a recurrence rule the schedule package accepts
+//
+// models.Money fields are validated by their minor units, so "gt=0"
+// means a positive amount.
+type Validator struct {
+	validate *validator.Validate
+	now      func() time.Time
+}
+
+// FieldError is one rule one field failed. Field is the JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error lists every failing field of a request.
+type Error struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// New returns a Validator that reads the current time from now, which
+// keeps the "future" rule testable.
+func New(now func() time.Time) *Validator {
+	v := &Validator{validate: validator.New(validator.WithRequiredStructEnabled()), now: now}
+
+	// Report fields by the name clients send, in the body, the query or
+	// a form
+	v.validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "query", "form"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+
+	v.validate.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return field.Interface().(models.Money).Amount
+	}, models.Money{})
+
+	v.validate.RegisterValidation("future", func(fl validator.FieldLevel) bool {
+		t, ok := fl.Field().Interface().(time.Time)
+		return ok && t.After(v.now())
+	})
+	v.validate.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		code, err := models.NormalizeCurrency(fl.Field().String())
+		return err == nil && code != ""
+	})
+
+	v.validate.RegisterValidation("date", func(fl validator.FieldLevel) bool {
+		s := fl.Field().String()
+		if _, err := time.Parse("2006-01-02", s); err == nil {
+			return true
+		}
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	})
+	v.validate.RegisterValidation("amount", func(fl validator.FieldLevel) bool {
+		_, err := models.ParseMoney(fl.Field().String(), "")
+		return err == nil
+	})
+	v.validate.RegisterValidation("rrule", func(fl validator.FieldLevel) bool {
+		_, err := schedule.Parse(fl.Field().String())
+		return err == nil
+	})
+
+	return v
+}
+
+func (v *Validator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	result := &Error{Fields: make([]FieldError, 0, len(errs))}
+	for _, fe := range errs {
+		result.Fields = append(result.Fields, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message(fe),
+		})
+	}
+	return result
+}
+
+func message(fe validator.FieldError) string {
+	field, param := fe.Field(), fe.Param()
+	isString := fe.Kind() == reflect.String
+
+	switch fe.Tag() {
+	case "required":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	case "min":
+		if isString {
+			return fmt.Sprintf("%s must be at least %s characters long", field, param)
+		}
+		return fmt.Sprintf("%s must be at least %s", field, param)
+	case "max":
+		if isString {
+			return fmt.Sprintf("%s must be at most %s characters long", field, param)
+		}
+		return fmt.Sprintf("%s must be at most %s", field, param)
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, param)
+	case "gte":
+		return fmt.Sprintf("%s must be %s or more", field, param)
+	case "lt":
+		return fmt.Sprintf("%s must be less than %s", field, param)
+	case "lte":
+		return fmt.Sprintf("%s must be %s or less", field, param)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(param, " ", ", "))
+	case "future":
+		return field + " must be in the future"
+	case "currency":
+		return field + " must be a supported currency code such as EUR"
+	case "date":
+		return field + " must be a date (YYYY-MM-DD) or an RFC 3339 time"
+	case "amount":
+		return field + " must be a decimal amount with at most two decimal places"
+	case "rrule":
+		return field + " must be a recurrence rule such as FREQ=WEEKLY;BYDAY=FR"
+	default:
+		return fmt.Sprintf("%s failed the %s rule", field, fe.Tag())
+	}
+}